@@ -1,24 +1,30 @@
 package compose
 
 import (
+	"context"
 	"fmt"
 	"../util"
 	"strings"
 	"os"
+	"path/filepath"
 )
 
-const dockerComposeFlowPath = "docker-compose-flow.yml.tmp"
+const dockerComposeFlowFile = "docker-compose-flow.yml"
+const dockerComposeFlowDirPrefix = "docker-flow-"
 
 var dockerCompose DockerComposer = DockerCompose{}
 
 type DockerComposer interface {
-	CreateFlowFile(dcPath, serviceName, target string, sideTargets []string, color string, blueGreen bool) error
-	RemoveFlow() error
-	PullTargets(host, certPath, project string, targets []string) error
-	UpTargets(host, certPath, project string, targets []string) error
-	ScaleTargets(host, certPath, project, target string, scale int) error
-	RmTargets(host, certPath, project string, targets []string) error
-	StopTargets(host, certPath, project string, targets []string) error
+	NewFlowPath() (string, error)
+	CreateFlowFile(flowPath, dcPath, serviceName, target string, sideTargets []string, color string, blueGreen bool) error
+	RemoveFlow(flowPath string) error
+	Cleanup(flowPath string) error
+	PullTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error
+	UpTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error
+	ScaleTargets(ctx context.Context, flowPath, dcPath, host, certPath, project, target string, scale int) error
+	RmTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error
+	StopTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error
+	RunService(ctx context.Context, host, certPath, project, composePath, service string, env []string) error
 }
 
 type DockerCompose struct{}
@@ -27,12 +33,25 @@ var GetDockerCompose = func() DockerComposer {
 	return dockerCompose
 }
 
-func (dc DockerCompose) CreateFlowFile(dcPath, serviceName, target string, sideTargets []string, color string, blueGreen bool) error {
+func (dc DockerCompose) NewFlowPath() (string, error) {
+	dir, err := util.TempDir("", dockerComposeFlowDirPrefix)
+	if err != nil {
+		return "", fmt.Errorf("Could not create a directory for the Docker Flow file\n%s", err.Error())
+	}
+	return filepath.Join(dir, dockerComposeFlowFile), nil
+}
+
+func (dc DockerCompose) CreateFlowFile(flowPath, dcPath, serviceName, target string, sideTargets []string, color string, blueGreen bool) error {
 	// TODO: Start remove
 	data, err := util.ReadFile(dcPath)
 	if err != nil {
 		return fmt.Errorf("Could not read the Docker Compose file %s\n%s", dcPath, err.Error())
 	}
+	// The flow file is not next to the Docker Compose file so extends must not use a relative path.
+	extendsPath, err := filepath.Abs(dcPath)
+	if err != nil {
+		return fmt.Errorf("Could not resolve the path of the Docker Compose file %s\n%s", dcPath, err.Error())
+	}
 	s := string(data)
 	// TODO: End remove
 	extendedTarget := target
@@ -68,7 +87,7 @@ services:`
 		extendedTarget,
 		indent,
 		indent,
-		dcPath,
+		extendsPath,
 		indent,
 		target,
 		indent,
@@ -83,79 +102,122 @@ services:`
 			sideTarget,
 			indent,
 			indent,
-			dcPath,
+			extendsPath,
 			indent,
 			sideTarget,
 		)
 	}
-	err = util.WriteFile(dockerComposeFlowPath, []byte(strings.Trim(s, "\n")), 0644)
+	err = util.WriteFile(flowPath, []byte(strings.Trim(s, "\n")), 0644)
 	if err != nil {
-		return fmt.Errorf("Could not write the Docker Flow file %s\n%s", dockerComposeFlowPath, err.Error())
+		return fmt.Errorf("Could not write the Docker Flow file %s\n%s", flowPath, err.Error())
 	}
 	return nil
 }
 
-func (dc DockerCompose) RemoveFlow() error {
-	if err := util.RemoveFile(dockerComposeFlowPath); err != nil {
-		return fmt.Errorf("Could not remove the temp file %s\n%s", dockerComposeFlowPath, err.Error())
+func (dc DockerCompose) RemoveFlow(flowPath string) error {
+	if err := util.RemoveFile(flowPath); err != nil {
+		return fmt.Errorf("Could not remove the temp file %s\n%s", flowPath, err.Error())
+	}
+	return nil
+}
+
+func (dc DockerCompose) Cleanup(flowPath string) error {
+	if len(flowPath) == 0 {
+		return nil
+	}
+	if err := util.RemoveFile(flowPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not remove the temp file %s\n%s", flowPath, err.Error())
+	}
+	dir := filepath.Dir(flowPath)
+	if !strings.HasPrefix(filepath.Base(dir), dockerComposeFlowDirPrefix) {
+		return nil
+	}
+	if err := util.RemoveFile(dir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not remove the temp directory %s\n%s", dir, err.Error())
 	}
 	return nil
 }
 
-func (dc DockerCompose) PullTargets(host, certPath, project string, targets []string) error {
+func (dc DockerCompose) PullTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error {
 	if len(targets) == 0 {
 		return nil
 	}
 	args := append([]string{"pull"}, targets...)
-	return dc.runCmd(host, certPath, project, args)
+	return util.Retry(ctx, fmt.Sprintf("Pulling %s", strings.Join(targets, ", ")), func() error {
+		return dc.runCmd(ctx, flowPath, dcPath, host, certPath, project, args)
+	})
 }
 
-func (dc DockerCompose) UpTargets(host, certPath, project string, targets []string) error {
+func (dc DockerCompose) UpTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error {
 	if len(targets) == 0 {
 		return nil
 	}
 	args := append([]string{"up", "-d"}, targets...)
-	return dc.runCmd(host, certPath, project, args)
+	return dc.runCmd(ctx, flowPath, dcPath, host, certPath, project, args)
 }
 
-func (dc DockerCompose) ScaleTargets(host, certPath, project, target string, scale int) error {
+func (dc DockerCompose) ScaleTargets(ctx context.Context, flowPath, dcPath, host, certPath, project, target string, scale int) error {
 	if len(target) == 0 {
 		return nil
 	}
 	args := []string{"scale", fmt.Sprintf("%s=%d", target, scale)}
-	return dc.runCmd(host, certPath, project, args)
+	return dc.runCmd(ctx, flowPath, dcPath, host, certPath, project, args)
 }
 
-func (dc DockerCompose) RmTargets(host, certPath, project string, targets []string) error {
+func (dc DockerCompose) RmTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error {
 	if len(targets) == 0 {
 		return nil
 	}
 	args := append([]string{"rm", "-f"}, targets...)
-	return dc.runCmd(host, certPath, project, args)
+	return dc.runCmd(ctx, flowPath, dcPath, host, certPath, project, args)
 }
 
-func (dc DockerCompose) StopTargets(host, certPath, project string, targets []string) error {
+func (dc DockerCompose) StopTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error {
 	if len(targets) == 0 {
 		return nil
 	}
 	args := append([]string{"stop"}, targets...)
-	return dc.runCmd(host, certPath, project, args)
+	return dc.runCmd(ctx, flowPath, dcPath, host, certPath, project, args)
 }
 
-func (dc DockerCompose) getArgs(host, certPath, project string) []string {
-	args := []string{"-f", dockerComposeFlowPath}
-	util.SetDockerHost(host, certPath)
+func (dc DockerCompose) RunService(ctx context.Context, host, certPath, project, composePath, service string, env []string) error {
+	if len(service) == 0 {
+		return nil
+	}
+	args := []string{"-f", composePath}
+	if len(project) > 0 {
+		args = append(args, "-p", project)
+	}
+	args = append(args, "run", "--rm")
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, service)
+	return dc.execCmd(ctx, host, certPath, args)
+}
+
+func (dc DockerCompose) getArgs(flowPath, dcPath, project string) []string {
+	args := []string{"-f", flowPath}
+	// The flow file is inside a temporary directory so the project directory, used for the .env file and the
+	// default project name, is set to the directory of the Docker Compose file.
+	if len(dcPath) > 0 {
+		args = append(args, "--project-directory", filepath.Dir(dcPath))
+	}
 	if len(project) > 0 {
 		args = append(args, "-p", project)
 	}
 	return args
 }
 
-func (dc DockerCompose) runCmd(host, certPath, project string, args []string) error {
-	args = append(dc.getArgs(host, certPath, project), args...)
-	cmd := util.ExecCmd("docker-compose", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func (dc DockerCompose) runCmd(ctx context.Context, flowPath, dcPath, host, certPath, project string, args []string) error {
+	return dc.execCmd(ctx, host, certPath, append(dc.getArgs(flowPath, dcPath, project), args...))
+}
+
+func (dc DockerCompose) execCmd(ctx context.Context, host, certPath string, args []string) error {
+	cmd := util.ExecCmd(ctx, "docker-compose", args...)
+	cmd.Env = util.GetDockerEnv(host, certPath)
+	cmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
 	if err := util.RunCmd(cmd); err != nil {
 		return fmt.Errorf("Docker Compose command: docker-compose %s\n%s", strings.Join(cmd.Args, ","), err.Error())
 	}
@@ -1,10 +1,12 @@
 package compose
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/suite"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"../util"
 "testing"
 )
@@ -20,6 +22,7 @@ type DockerComposeTestSuite struct {
 	host              string
 	certPath          string
 	project           string
+	flowPath          string
 }
 
 func (s *DockerComposeTestSuite) SetupTest() {
@@ -32,6 +35,7 @@ func (s *DockerComposeTestSuite) SetupTest() {
 	s.host = "tcp://1.2.3.4:1234"
 	s.certPath = "/path/to/docker/cert"
 	s.project = "my-project"
+	s.flowPath = filepath.Join(os.TempDir(), "docker-flow-123", "docker-compose-flow.yml")
 	util.ReadFile = func(fileName string) ([]byte, error) {
 		return []byte(""), nil
 	}
@@ -41,7 +45,7 @@ func (s *DockerComposeTestSuite) SetupTest() {
 	util.RemoveFile = func(name string) error {
 		return nil
 	}
-	util.ExecCmd = func(name string, arg ...string) *exec.Cmd {
+	util.ExecCmd = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
 		return &exec.Cmd{}
 	}
 }
@@ -57,7 +61,7 @@ func (s DockerComposeTestSuite) Test_GetDockerCompose_ReturnsDockerCompose() {
 // CreateFlow
 
 func (s DockerComposeTestSuite) Test_CreateFlowFile_ReturnsNil() {
-	actual := DockerCompose{}.CreateFlowFile(s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
+	actual := DockerCompose{}.CreateFlowFile(s.flowPath, s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
 
 	s.Nil(actual)
 }
@@ -67,7 +71,7 @@ func (s DockerComposeTestSuite) Test_CreateFlowFile_ReturnsError_WhenReadFile()
 		return []byte(""), fmt.Errorf("Some error")
 	}
 
-	err := DockerCompose{}.CreateFlowFile(s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
+	err := DockerCompose{}.CreateFlowFile(s.flowPath, s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
 
 	s.Error(err)
 }
@@ -79,9 +83,9 @@ func (s DockerComposeTestSuite) Test_CreateFlowFile_CreatesTheFile() {
 		return nil
 	}
 
-	DockerCompose{}.CreateFlowFile(s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
+	DockerCompose{}.CreateFlowFile(s.flowPath, s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
 
-	s.Equal(dockerComposeFlowPath, actual)
+	s.Equal(s.flowPath, actual)
 }
 
 func (s DockerComposeTestSuite) Test_CreateFlowFile_CreatesDockerComposeReplica() {
@@ -91,7 +95,7 @@ func (s DockerComposeTestSuite) Test_CreateFlowFile_CreatesDockerComposeReplica(
 		return []byte(""), nil
 	}
 
-	DockerCompose{}.CreateFlowFile(s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
+	DockerCompose{}.CreateFlowFile(s.flowPath, s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
 
 	s.Equal(s.dockerComposePath, actual)
 }
@@ -105,6 +109,7 @@ func (s DockerComposeTestSuite) Test_CreateFlowFile_CreatesNewTarget_WhenBlueGre
 		s.target,
 	)
 	newTarget := fmt.Sprintf("%s-%s", s.target, color)
+	dcAbsPath, _ := filepath.Abs(s.dockerComposePath)
 	expected := fmt.Sprintf(`%s:
   extends:
     file: %s
@@ -120,15 +125,15 @@ func (s DockerComposeTestSuite) Test_CreateFlowFile_CreatesNewTarget_WhenBlueGre
     file: %s
     service: %s`,
 		newTarget,
-		s.dockerComposePath,
+		dcAbsPath,
 		s.target,
 		s.serviceName,
 		color,
 		s.sideTargets[0],
-		s.dockerComposePath,
+		dcAbsPath,
 		s.sideTargets[0],
 		s.sideTargets[1],
-		s.dockerComposePath,
+		dcAbsPath,
 		s.sideTargets[1],
 	)
 	util.ReadFile = func(filename string) ([]byte, error) {
@@ -139,7 +144,7 @@ func (s DockerComposeTestSuite) Test_CreateFlowFile_CreatesNewTarget_WhenBlueGre
 		return nil
 	}
 
-	DockerCompose{}.CreateFlowFile(s.dockerComposePath, s.serviceName, s.target, s.sideTargets, color, true)
+	DockerCompose{}.CreateFlowFile(s.flowPath, s.dockerComposePath, s.serviceName, s.target, s.sideTargets, color, true)
 
 	s.Equal(expected, actual)
 }
@@ -148,6 +153,7 @@ func (s DockerComposeTestSuite) Test_CreateFlowFile_UsesV2_WhenBlueGreen() {
 	color := "orange"
 	var actual string
 	newTarget := fmt.Sprintf("%s-%s", s.target, color)
+	dcAbsPath, _ := filepath.Abs(s.dockerComposePath)
 	var dcContent = fmt.Sprintf(`version: '2'
 
 services:
@@ -173,15 +179,15 @@ services:
       file: %s
       service: %s`,
 		newTarget,
-		s.dockerComposePath,
+		dcAbsPath,
 		s.target,
 		s.serviceName,
 		color,
 		s.sideTargets[0],
-		s.dockerComposePath,
+		dcAbsPath,
 		s.sideTargets[0],
 		s.sideTargets[1],
-		s.dockerComposePath,
+		dcAbsPath,
 		s.sideTargets[1],
 	)
 	util.ReadFile = func(filename string) ([]byte, error) {
@@ -192,7 +198,7 @@ services:
 		return nil
 	}
 
-	DockerCompose{}.CreateFlowFile(s.dockerComposePath, s.serviceName, s.target, s.sideTargets, color, true)
+	DockerCompose{}.CreateFlowFile(s.flowPath, s.dockerComposePath, s.serviceName, s.target, s.sideTargets, color, true)
 
 	s.Equal(expected, actual)
 }
@@ -202,7 +208,7 @@ func (s DockerComposeTestSuite) Test_CreateFlowFile_ReturnsError_WhenWriteFile()
 		return fmt.Errorf("Some error")
 	}
 
-	err := DockerCompose{}.CreateFlowFile(s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
+	err := DockerCompose{}.CreateFlowFile(s.flowPath, s.dockerComposePath, s.serviceName, s.target, s.sideTargets, s.color, s.blueGreen)
 
 	s.Error(err)
 }
@@ -216,9 +222,9 @@ func (s DockerComposeTestSuite) Test_RemoveFlow_RemovesTheFile() {
 		return nil
 	}
 
-	DockerCompose{}.RemoveFlow()
+	DockerCompose{}.RemoveFlow(s.flowPath)
 
-	s.Equal(dockerComposeFlowPath, actual)
+	s.Equal(s.flowPath, actual)
 }
 
 func (s DockerComposeTestSuite) Test_RemoveFlow_ReturnsError() {
@@ -226,15 +232,98 @@ func (s DockerComposeTestSuite) Test_RemoveFlow_ReturnsError() {
 		return fmt.Errorf("Some error")
 	}
 
-	err := DockerCompose{}.RemoveFlow()
+	err := DockerCompose{}.RemoveFlow(s.flowPath)
 
 	s.Error(err)
 }
 
+// NewFlowPath
+
+func (s DockerComposeTestSuite) Test_NewFlowPath_ReturnsFlowFileInsideTempDir() {
+	dir := filepath.Join(os.TempDir(), "docker-flow-123")
+	actualPrefix := ""
+	tempDirOrig := util.TempDir
+	defer func() { util.TempDir = tempDirOrig }()
+	util.TempDir = func(parent, prefix string) (string, error) {
+		actualPrefix = prefix
+		return dir, nil
+	}
+
+	actual, err := DockerCompose{}.NewFlowPath()
+
+	s.NoError(err)
+	s.Equal(filepath.Join(dir, "docker-compose-flow.yml"), actual)
+	s.Equal("docker-flow-", actualPrefix)
+}
+
+func (s DockerComposeTestSuite) Test_NewFlowPath_ReturnsUniquePaths() {
+	first, _ := DockerCompose{}.NewFlowPath()
+	second, _ := DockerCompose{}.NewFlowPath()
+	defer os.Remove(filepath.Dir(first))
+	defer os.Remove(filepath.Dir(second))
+
+	s.NotEqual(first, second)
+}
+
+func (s DockerComposeTestSuite) Test_NewFlowPath_ReturnsError_WhenTempDirFails() {
+	tempDirOrig := util.TempDir
+	defer func() { util.TempDir = tempDirOrig }()
+	util.TempDir = func(parent, prefix string) (string, error) {
+		return "", fmt.Errorf("This is an error")
+	}
+
+	_, err := DockerCompose{}.NewFlowPath()
+
+	s.Error(err)
+}
+
+// Cleanup
+
+func (s DockerComposeTestSuite) Test_Cleanup_RemovesFlowFileAndItsDir() {
+	actual := []string{}
+	util.RemoveFile = func(name string) error {
+		actual = append(actual, name)
+		return nil
+	}
+
+	err := DockerCompose{}.Cleanup(s.flowPath)
+
+	s.NoError(err)
+	s.Equal([]string{s.flowPath, filepath.Dir(s.flowPath)}, actual)
+}
+
+func (s DockerComposeTestSuite) Test_Cleanup_DoesNotRemoveDir_WhenItIsNotCreatedByNewFlowPath() {
+	actual := []string{}
+	util.RemoveFile = func(name string) error {
+		actual = append(actual, name)
+		return nil
+	}
+
+	DockerCompose{}.Cleanup("docker-compose-flow.yml")
+
+	s.Equal([]string{"docker-compose-flow.yml"}, actual)
+}
+
+func (s DockerComposeTestSuite) Test_Cleanup_ReturnsNil_WhenFlowFileDoesNotExist() {
+	util.RemoveFile = func(name string) error {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	s.NoError(DockerCompose{}.Cleanup(s.flowPath))
+}
+
+func (s DockerComposeTestSuite) Test_Cleanup_ReturnsError_WhenRemoveFails() {
+	util.RemoveFile = func(name string) error {
+		return fmt.Errorf("This is an error")
+	}
+
+	s.Error(DockerCompose{}.Cleanup(s.flowPath))
+}
+
 // PullTargets
 
 func (s DockerComposeTestSuite) Test_PullTargets_ReturnsNil_WhenTargetsAreEmpty() {
-	actual := DockerCompose{}.PullTargets(s.host, s.certPath, s.project, []string{})
+	actual := DockerCompose{}.PullTargets(context.Background(), s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, []string{})
 
 	s.Nil(actual)
 }
@@ -248,8 +337,43 @@ func (s DockerComposeTestSuite) Test_PullTargets_ReturnsError_WhenCommandFails()
 	defer func() { util.RunCmd = runCmdOrig }()
 	util.RunCmd = func(cmd *exec.Cmd) error { return fmt.Errorf("This is an error") }
 
-	actual := DockerCompose{}.PullTargets(s.host, s.certPath, s.project, []string{s.target})
+	actual := DockerCompose{}.PullTargets(context.Background(), s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, []string{s.target})
+	s.Error(actual)
+}
+
+func (s DockerComposeTestSuite) Test_PullTargets_RetriesFailedPulls() {
+	runCmdOrig := util.RunCmd
+	defer func() { util.RunCmd = runCmdOrig }()
+	calls := 0
+	util.RunCmd = func(cmd *exec.Cmd) error {
+		calls++
+		if calls == 1 {
+			return fmt.Errorf("net/http: TLS handshake timeout")
+		}
+		return nil
+	}
+	ctx := util.WithRetryPolicy(context.Background(), util.RetryPolicy{Attempts: 2, RetryOn: []string{"timeout"}})
+
+	actual := DockerCompose{}.PullTargets(ctx, s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, []string{s.target})
+
+	s.NoError(actual)
+	s.Equal(2, calls)
+}
+
+func (s DockerComposeTestSuite) Test_PullTargets_DoesNotRetry_WhenErrorDoesNotMatchRetryOn() {
+	runCmdOrig := util.RunCmd
+	defer func() { util.RunCmd = runCmdOrig }()
+	calls := 0
+	util.RunCmd = func(cmd *exec.Cmd) error {
+		calls++
+		return fmt.Errorf("image not found")
+	}
+	ctx := util.WithRetryPolicy(context.Background(), util.RetryPolicy{Attempts: 2, RetryOn: []string{"timeout"}})
+
+	actual := DockerCompose{}.PullTargets(ctx, s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, []string{s.target})
+
 	s.Error(actual)
+	s.Equal(1, calls)
 }
 
 // UpTargets
@@ -261,17 +385,26 @@ func (s DockerComposeTestSuite) Test_UpTargets() {
 // ScaleTargets
 
 func (s DockerComposeTestSuite) Test_ScaleTargets_ReturnsNil_WhenTargetIsEmpty() {
-	actual := DockerCompose{}.ScaleTargets(s.host, s.certPath, s.project, "", 8)
+	actual := DockerCompose{}.ScaleTargets(context.Background(), s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, "", 8)
 
 	s.Nil(actual)
 }
 
 func (s DockerComposeTestSuite) Test_ScaleTargets_CreatesTheCommand() {
 	var scale = 7
-	expected := []string{"docker-compose", "-f", dockerComposeFlowPath, "-p", s.project, "scale", fmt.Sprintf("%s=%d", s.target, scale)}
+	expected := []string{"docker-compose", "-f", s.flowPath, "--project-directory", ".", "-p", s.project, "scale", fmt.Sprintf("%s=%d", s.target, scale)}
+	actual := s.mockExecCmd()
+
+	DockerCompose{}.ScaleTargets(context.Background(), s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, s.target, scale)
+
+	s.Equal(expected, *actual)
+}
+
+func (s DockerComposeTestSuite) Test_ScaleTargets_SetsProjectDirectoryToDirOfDockerComposeFile() {
+	expected := []string{"docker-compose", "-f", s.flowPath, "--project-directory", "/path/to/my", "scale", fmt.Sprintf("%s=%d", s.target, 3)}
 	actual := s.mockExecCmd()
 
-	DockerCompose{}.ScaleTargets(s.host, s.certPath, s.project, s.target, scale)
+	DockerCompose{}.ScaleTargets(context.Background(), s.flowPath, "/path/to/my/docker-compose.yml", s.host, s.certPath, "", s.target, 3)
 
 	s.Equal(expected, *actual)
 }
@@ -288,6 +421,57 @@ func (s DockerComposeTestSuite) Test_StopTargets() {
 	s.testCmd(DockerCompose{}.StopTargets, "stop", s.target)
 }
 
+// RunService
+
+func (s DockerComposeTestSuite) Test_RunService_ReturnsNil_WhenServiceIsEmpty() {
+	actual := DockerCompose{}.RunService(context.Background(), s.host, s.certPath, s.project, s.dockerComposePath, "", []string{})
+
+	s.Nil(actual)
+}
+
+func (s DockerComposeTestSuite) Test_RunService_CreatesTheCommand() {
+	expected := []string{
+		"docker-compose", "-f", s.dockerComposePath, "-p", s.project,
+		"run", "--rm", "-e", "COLOR=blue", "-e", "TARGET=app", "migrations",
+	}
+	actual := s.mockExecCmd()
+
+	actualEnv := s.mockRunCmdEnv()
+
+	DockerCompose{}.RunService(context.Background(), s.host, s.certPath, s.project, s.dockerComposePath, "migrations", []string{"COLOR=blue", "TARGET=app"})
+
+	s.Equal(expected, *actual)
+	s.Contains(*actualEnv, "DOCKER_HOST="+s.host)
+}
+
+func (s DockerComposeTestSuite) Test_RunService_ReturnsError_WhenCommandFails() {
+	runCmdOrig := util.RunCmd
+	defer func() { util.RunCmd = runCmdOrig }()
+	util.RunCmd = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("This is an error")
+	}
+
+	actual := DockerCompose{}.RunService(context.Background(), s.host, s.certPath, s.project, s.dockerComposePath, "migrations", []string{})
+
+	s.Error(actual)
+}
+
+// Context
+
+func (s DockerComposeTestSuite) Test_PullTargets_PassesContextToCommand() {
+	var actual context.Context
+	util.ExecCmd = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		actual = ctx
+		return &exec.Cmd{}
+	}
+	expected, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	DockerCompose{}.PullTargets(expected, s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, []string{s.target})
+
+	s.Equal(expected, actual)
+}
+
 // Suite
 
 func TestDockerComposeTestSuite(t *testing.T) {
@@ -307,7 +491,7 @@ func TestDockerComposeTestSuite(t *testing.T) {
 
 func (s DockerComposeTestSuite) mockExecCmd() *[]string {
 	var actualCommand []string
-	util.ExecCmd = func(name string, arg ...string) *exec.Cmd {
+	util.ExecCmd = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
 		actualCommand = append([]string{name}, arg...)
 		cmd := &exec.Cmd{}
 		return cmd
@@ -315,38 +499,48 @@ func (s DockerComposeTestSuite) mockExecCmd() *[]string {
 	return &actualCommand
 }
 
-type testCmdType func(host, certPath, project string, targets []string) error
+type testCmdType func(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error
 
 func (s DockerComposeTestSuite) testCmd(f testCmdType, args ...string) {
 	var expected []string
 	var actual *[]string
 
 	// Returns nil when targets are empty
-	s.Nil(f(s.host, s.certPath, s.project, []string{}))
+	s.Nil(f(context.Background(), s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, []string{}))
 
 	// Creates command
-	expected = append([]string{"docker-compose", "-f", dockerComposeFlowPath, "-p", s.project}, args...)
+	expected = append([]string{"docker-compose", "-f", s.flowPath, "--project-directory", ".", "-p", s.project}, args...)
 	actual = s.mockExecCmd()
-	f(s.host, s.certPath, s.project, []string{s.target})
+	f(context.Background(), s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, []string{s.target})
 	s.Equal(expected, *actual)
 
 	// Does not add project when empty
-	expected = append([]string{"docker-compose", "-f", dockerComposeFlowPath}, args...)
+	expected = append([]string{"docker-compose", "-f", s.flowPath, "--project-directory", "."}, args...)
 	actual = s.mockExecCmd()
-	f(s.host, s.certPath, "", []string{s.target})
+	f(context.Background(), s.flowPath, s.dockerComposePath, s.host, s.certPath, "", []string{s.target})
 	s.Equal(expected, *actual)
 
-	// Adds DOCKER_HOST variable
-	f(s.host, s.certPath, s.project, []string{s.target})
-	host := s.host
-	s.Equal(host, s.host)
+	// Adds DOCKER_HOST and DOCKER_CERT_PATH variables to the command
+	actualEnv := s.mockRunCmdEnv()
+	f(context.Background(), s.flowPath, s.dockerComposePath, s.host, s.certPath, s.project, []string{s.target})
+	s.Contains(*actualEnv, "DOCKER_HOST="+s.host)
+	s.Contains(*actualEnv, "DOCKER_CERT_PATH="+s.certPath)
 
 	// Does not add DOCKER_HOST variable when empty
-	f("", s.certPath, s.project, []string{s.target})
-	s.NotEqual(os.Getenv("DOCKER_HOST"), s.host)
+	os.Setenv("DOCKER_HOST", "tcp://other-host:2376")
+	f(context.Background(), s.flowPath, s.dockerComposePath, "", s.certPath, s.project, []string{s.target})
+	s.NotContains(*actualEnv, "DOCKER_HOST="+s.host)
+	s.NotContains(*actualEnv, "DOCKER_HOST=tcp://other-host:2376")
 
-	// Adds DOCKER_CERT_PATH variable
-	f(s.host, s.certPath, s.project, []string{s.target})
-	s.Equal(os.Getenv("DOCKER_CERT_PATH"), s.certPath)
+	// Does not change the environment of the process
+	s.Equal("tcp://other-host:2376", os.Getenv("DOCKER_HOST"))
+}
 
+func (s DockerComposeTestSuite) mockRunCmdEnv() *[]string {
+	var actualEnv []string
+	util.RunCmd = func(cmd *exec.Cmd) error {
+		actualEnv = cmd.Env
+		return nil
+	}
+	return &actualEnv
 }
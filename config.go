@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"./util"
+)
+
+const configProfilesKey = "profiles"
+const configIncludeKey = "include"
+const OptSourceDefault = "default"
+const OptSourceFile = "file"
+const OptSourceEnv = "env"
+const OptSourceFlag = "flag"
+
+var configWriter io.Writer = os.Stdout
+
+type option struct {
+	Name  string
+	Env   string
+	Long  string
+	Index int
+}
+
+var configVariableRegexp = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// getOptions lists the options that can be set through the Docker Flow file, environment variables or arguments.
+// The name of each option is its key in the Docker Flow file and its environment variable is FLOW_ followed by that key.
+func getOptions() []option {
+	options := []option{}
+	t := reflect.TypeOf(Opts{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("yaml")
+		envName := field.Tag.Get("envconfig")
+		if len(name) == 0 || name == "-" {
+			name = envName
+		}
+		if len(name) == 0 {
+			continue
+		}
+		o := option{Name: name, Long: field.Tag.Get("long"), Index: i}
+		if len(envName) > 0 && field.Tag.Get("ignored") != "true" {
+			o.Env = "FLOW_" + strings.ToUpper(envName)
+		}
+		options = append(options, o)
+	}
+	return options
+}
+
+func setOptSource(opts *Opts, name, source string) {
+	if opts.Sources == nil {
+		opts.Sources = map[string]string{}
+	}
+	opts.Sources[name] = source
+}
+
+func RunConfigCommand() error {
+	opts, err := LoadOpts()
+	if err != nil {
+		return err
+	}
+	SetDefaultOpts(&opts)
+	data, err := FormatConfig(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(configWriter, data)
+	return nil
+}
+
+// FormatConfig returns the options as YAML with a comment stating the source of each value.
+func FormatConfig(opts Opts) (string, error) {
+	lines := []string{"# Effective configuration. Each option is followed by the source of its value."}
+	value := reflect.ValueOf(opts)
+	for _, option := range getOptions() {
+		data, err := yaml.Marshal(map[string]interface{}{option.Name: value.Field(option.Index).Interface()})
+		if err != nil {
+			return "", err
+		}
+		source := opts.Sources[option.Name]
+		if len(source) == 0 {
+			source = OptSourceDefault
+		}
+		optionLines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		optionLines[0] = fmt.Sprintf("%s # %s", optionLines[0], source)
+		lines = append(lines, optionLines...)
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// GetConfigArgs sets the options that decide how the Docker Flow file is loaded.
+// They are needed before the file is parsed so they are read from environment variables and arguments in advance.
+func GetConfigArgs(opts *Opts) {
+	args := struct {
+		Config       string `long:"config"`
+		Profile      string `long:"profile"`
+		StrictConfig bool   `long:"strict-config"`
+	}{
+		Config:  os.Getenv("FLOW_CONFIG"),
+		Profile: os.Getenv("FLOW_PROFILE"),
+	}
+	args.StrictConfig, _ = strconv.ParseBool(os.Getenv("FLOW_STRICT_CONFIG"))
+	flags.NewParser(&args, flags.IgnoreUnknown).ParseArgs(os.Args[1:])
+	opts.Config = args.Config
+	opts.Profile = args.Profile
+	opts.StrictConfig = args.StrictConfig
+}
+
+func unmarshalConfig(path string, data []byte) (map[interface{}]interface{}, error) {
+	var value interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		config := map[string]interface{}{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+		value = config
+	case ".toml":
+		config := map[string]interface{}{}
+		if _, err := toml.Decode(string(data), &config); err != nil {
+			return nil, err
+		}
+		value = config
+	default:
+		config := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+		value = config
+	}
+	return normalizeConfig(value).(map[interface{}]interface{}), nil
+}
+
+// normalizeConfig converts maps and lists decoded from JSON and TOML to the types used by the YAML decoder.
+func normalizeConfig(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		config := map[interface{}]interface{}{}
+		for key, item := range v {
+			config[key] = normalizeConfig(item)
+		}
+		return config
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			v[key] = normalizeConfig(item)
+		}
+	case []map[string]interface{}:
+		items := []interface{}{}
+		for _, item := range v {
+			items = append(items, normalizeConfig(item))
+		}
+		return items
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeConfig(item)
+		}
+	}
+	return value
+}
+
+// parseConfig parses the Docker Flow file, merges the files it includes and the selected profile
+// and interpolates environment variables in the result.
+// Variables used only by profiles that are not selected do not need to be set.
+func parseConfig(path string, data []byte, profile string) (map[interface{}]interface{}, error) {
+	config, err := parseConfigFile(path, data, []string{})
+	if err != nil {
+		return nil, err
+	}
+	if config, err = applyProfile(config, profile); err != nil {
+		return nil, err
+	}
+	missing := map[string]bool{}
+	interpolateOptions(config, missing)
+	if len(missing) > 0 {
+		names := []string{}
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("The following environment variables are not set: %s", strings.Join(names, ", "))
+	}
+	return config, nil
+}
+
+func parseConfigFile(path string, data []byte, parents []string) (map[interface{}]interface{}, error) {
+	config, err := unmarshalConfig(path, data)
+	if err != nil {
+		return nil, err
+	}
+	includes, err := getConfigIncludes(config[configIncludeKey])
+	if err != nil {
+		return nil, err
+	}
+	delete(config, configIncludeKey)
+	chain := append(append([]string{}, parents...), path)
+	merged := map[interface{}]interface{}{}
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		for _, parent := range chain {
+			if filepath.Clean(parent) == filepath.Clean(include) {
+				return nil, fmt.Errorf("The file %s is included recursively", include)
+			}
+		}
+		includeData, err := util.ReadFile(include)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the included file %s\n%s", include, err.Error())
+		}
+		included, err := parseConfigFile(include, includeData, chain)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse the included file %s\n%s", include, err.Error())
+		}
+		merged = mergeConfig(merged, included)
+	}
+	return mergeConfig(merged, config), nil
+}
+
+func getConfigIncludes(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return []string{}, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		includes := []string{}
+		for _, item := range v {
+			include, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a path or a list of paths", configIncludeKey)
+			}
+			includes = append(includes, include)
+		}
+		return includes, nil
+	}
+	return nil, fmt.Errorf("%s must be a path or a list of paths", configIncludeKey)
+}
+
+// interpolateOptions interpolates environment variables in the options except hooks and parameters of pipeline stages.
+// Their commands and messages are kept as written since they use variables, like FLOW_NEXT_COLOR, set only when they run.
+func interpolateOptions(config map[interface{}]interface{}, missing map[string]bool) {
+	mapConfigOptions(config, func(value string, t reflect.Type) interface{} {
+		if !strings.Contains(value, "$") {
+			return value
+		}
+		return convertConfigValue(interpolateValue(value, missing), t)
+	})
+}
+
+// escapeOptions escapes $ in the options that are interpolated so that they keep their values once interpolated.
+func escapeOptions(config map[interface{}]interface{}) {
+	mapConfigOptions(config, func(value string, t reflect.Type) interface{} {
+		return strings.Replace(value, "$", "$$", -1)
+	})
+}
+
+// mapConfigOptions replaces the strings of the options that are interpolated with the result of f.
+func mapConfigOptions(config map[interface{}]interface{}, f func(value string, t reflect.Type) interface{}) {
+	optsType := reflect.TypeOf(Opts{})
+	for key, value := range config {
+		switch key {
+		case "hooks":
+		case "pipeline":
+			stageType := reflect.TypeOf(Stage{})
+			stages, _ := value.([]interface{})
+			for _, stage := range stages {
+				if stageMap, ok := stage.(map[interface{}]interface{}); ok {
+					for stageKey, stageValue := range stageMap {
+						if stageKey != "params" {
+							stageMap[stageKey] = mapConfigValue(stageValue, getConfigFieldType(stageType, stageKey), f)
+						}
+					}
+				}
+			}
+		default:
+			config[key] = mapConfigValue(value, getConfigFieldType(optsType, key), f)
+		}
+	}
+}
+
+// mapConfigValue replaces the strings inside the value with the result of f. t is the type the value is unmarshaled into, if known.
+func mapConfigValue(value interface{}, t reflect.Type, f func(value string, t reflect.Type) interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return f(v, t)
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			v[key] = mapConfigValue(item, getConfigFieldType(t, key), f)
+		}
+	case []interface{}:
+		var itemType reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			itemType = t.Elem()
+		}
+		for i, item := range v {
+			v[i] = mapConfigValue(item, itemType, f)
+		}
+	}
+	return value
+}
+
+// getConfigFieldType returns the type of the key of a struct or a map, or nil when it is not known.
+func getConfigFieldType(t reflect.Type, key interface{}) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		name := fmt.Sprintf("%v", key)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fieldName := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if len(fieldName) == 0 {
+				fieldName = strings.ToLower(field.Name)
+			}
+			if fieldName == name {
+				return field.Type
+			}
+		}
+	}
+	return nil
+}
+
+// convertConfigValue converts an interpolated value to a number or a boolean when the option it is set to has that type.
+// Values of other options are kept exactly as they are so that, for example, a tag 0123 does not become 123.
+func convertConfigValue(value string, t reflect.Type) interface{} {
+	if t == nil {
+		return value
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// interpolateValue replaces ${VAR} and ${VAR:-default} with environment variables and $$ with $.
+func interpolateValue(value string, missing map[string]bool) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+	return configVariableRegexp.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := configVariableRegexp.FindStringSubmatch(match)
+		name, hasDefault, defaultValue := groups[1], len(groups[2]) > 0, groups[3]
+		if env, ok := os.LookupEnv(name); ok && (len(env) > 0 || !hasDefault) {
+			return env
+		}
+		if hasDefault {
+			return defaultValue
+		}
+		missing[name] = true
+		return ""
+	})
+}
+
+// applyProfile removes the profiles from the configuration and deep merges the selected profile over the rest of it.
+// Maps are merged key by key while lists and scalar values of the profile replace those of the base configuration.
+func applyProfile(config map[interface{}]interface{}, profile string) (map[interface{}]interface{}, error) {
+	profiles, _ := config[configProfilesKey].(map[interface{}]interface{})
+	delete(config, configProfilesKey)
+	if len(profile) > 0 {
+		value, ok := profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("The profile %s is not defined", profile)
+		}
+		if value != nil {
+			override, ok := value.(map[interface{}]interface{})
+			if !ok {
+				return nil, fmt.Errorf("The profile %s must be a map of options", profile)
+			}
+			config = mergeConfig(config, override)
+		}
+	}
+	return config, nil
+}
+
+func mergeConfig(base, override map[interface{}]interface{}) map[interface{}]interface{} {
+	for key, value := range override {
+		if overrideMap, ok := value.(map[interface{}]interface{}); ok {
+			if baseMap, ok := base[key].(map[interface{}]interface{}); ok {
+				base[key] = mergeConfig(baseMap, overrideMap)
+				continue
+			}
+		}
+		base[key] = value
+	}
+	return base
+}
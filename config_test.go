@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"./util"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+	args []string
+}
+
+func (s *ConfigTestSuite) SetupTest() {
+	s.args = os.Args
+	s.unsetEnvVars()
+}
+
+func (s *ConfigTestSuite) TearDownTest() {
+	os.Args = s.args
+	s.unsetEnvVars()
+}
+
+func (s ConfigTestSuite) unsetEnvVars() {
+	os.Unsetenv("FLOW_CONFIG")
+	os.Unsetenv("FLOW_PROFILE")
+	os.Unsetenv("FLOW_STRICT_CONFIG")
+}
+
+// GetConfigArgs
+
+func (s ConfigTestSuite) Test_GetConfigArgs_ReturnsEmptyValues_WhenNotSpecified() {
+	os.Args = []string{"myProgram", "--target", "app"}
+	opts := Opts{}
+
+	GetConfigArgs(&opts)
+
+	s.Empty(opts.Config)
+	s.Empty(opts.Profile)
+	s.False(opts.StrictConfig)
+}
+
+func (s ConfigTestSuite) Test_GetConfigArgs_SetsValuesFromEnvVars() {
+	os.Setenv("FLOW_CONFIG", "flow.json")
+	os.Setenv("FLOW_PROFILE", "staging")
+	os.Setenv("FLOW_STRICT_CONFIG", "true")
+	os.Args = []string{"myProgram"}
+	opts := Opts{}
+
+	GetConfigArgs(&opts)
+
+	s.Equal("flow.json", opts.Config)
+	s.Equal("staging", opts.Profile)
+	s.True(opts.StrictConfig)
+}
+
+func (s ConfigTestSuite) Test_GetConfigArgs_SetsValuesFromArgs() {
+	os.Setenv("FLOW_CONFIG", "flow.json")
+	os.Setenv("FLOW_PROFILE", "staging")
+	os.Args = []string{"myProgram", "-t", "app", "--config", "flow.toml", "--profile", "production", "--strict-config", "--blue-green"}
+	opts := Opts{}
+
+	GetConfigArgs(&opts)
+
+	s.Equal("flow.toml", opts.Config)
+	s.Equal("production", opts.Profile)
+	s.True(opts.StrictConfig)
+}
+
+// mergeConfig
+
+func (s ConfigTestSuite) Test_MergeConfig_MergesMapsAndReplacesOtherValues() {
+	base := map[interface{}]interface{}{
+		"scale":        2,
+		"service_path": []interface{}{"/api"},
+		"hooks": map[interface{}]interface{}{
+			"before_deploy": []interface{}{"migrations"},
+		},
+	}
+	override := map[interface{}]interface{}{
+		"service_path": []interface{}{"/api/v1"},
+		"hooks": map[interface{}]interface{}{
+			"after_proxy": []interface{}{"purge"},
+		},
+	}
+	expected := map[interface{}]interface{}{
+		"scale":        2,
+		"service_path": []interface{}{"/api/v1"},
+		"hooks": map[interface{}]interface{}{
+			"before_deploy": []interface{}{"migrations"},
+			"after_proxy":   []interface{}{"purge"},
+		},
+	}
+
+	s.Equal(expected, mergeConfig(base, override))
+}
+
+// interpolateValue
+
+func (s ConfigTestSuite) Test_InterpolateValue() {
+	os.Setenv("FLOW_TEST_SET", "myValue")
+	os.Setenv("FLOW_TEST_EMPTY", "")
+	defer func() {
+		os.Unsetenv("FLOW_TEST_SET")
+		os.Unsetenv("FLOW_TEST_EMPTY")
+	}()
+	data := []struct {
+		value    string
+		expected string
+	}{
+		{"plain value", "plain value"},
+		{"${FLOW_TEST_SET}", "myValue"},
+		{"prefix-${FLOW_TEST_SET}-suffix", "prefix-myValue-suffix"},
+		{"${FLOW_TEST_EMPTY}", ""},
+		{"${FLOW_TEST_EMPTY:-default}", "default"},
+		{"${FLOW_TEST_UNSET:-default value}", "default value"},
+		{"${FLOW_TEST_UNSET:-5}", "5"},
+		{"${FLOW_TEST_UNSET:-false}", "false"},
+		{"pa$$word", "pa$word"},
+		{"$${FLOW_TEST_SET}", "${FLOW_TEST_SET}"},
+	}
+	for _, d := range data {
+		missing := map[string]bool{}
+
+		actual := interpolateValue(d.value, missing)
+
+		s.Equal(d.expected, actual, d.value)
+		s.Empty(missing, d.value)
+	}
+}
+
+func (s ConfigTestSuite) Test_InterpolateValue_RecordsMissingEnvVars() {
+	missing := map[string]bool{}
+
+	interpolateValue("${FLOW_TEST_UNSET}", missing)
+
+	s.Equal(map[string]bool{"FLOW_TEST_UNSET": true}, missing)
+}
+
+// getOptions
+
+func (s ConfigTestSuite) Test_GetOptions_UsesConsistentNames() {
+	t := reflect.TypeOf(Opts{})
+	for _, option := range getOptions() {
+		field := t.Field(option.Index)
+		if field.Tag.Get("ignored") == "true" {
+			s.Empty(option.Env, option.Name)
+			continue
+		}
+		s.Equal("FLOW_"+strings.ToUpper(option.Name), option.Env, option.Name)
+		if yamlKey := field.Tag.Get("yaml"); yamlKey != "-" {
+			s.Equal(option.Name, yamlKey)
+		}
+	}
+}
+
+// FormatConfig
+
+func (s ConfigTestSuite) Test_FormatConfig_ReturnsOptionsWithSources() {
+	opts := Opts{
+		Target:      "app",
+		SideTargets: []string{"db"},
+		Scale:       "3",
+		Sources: map[string]string{
+			"target":       OptSourceFile,
+			"side_targets": OptSourceEnv,
+			"scale":        OptSourceFlag,
+		},
+	}
+
+	actual, err := FormatConfig(opts)
+
+	s.NoError(err)
+	s.Contains(actual, "\ntarget: app # file\n")
+	s.Contains(actual, "\nside_targets: # env\n- db\n")
+	s.Contains(actual, "\nscale: \"3\" # flag\n")
+	s.Contains(actual, "\nblue_green: false # default\n")
+}
+
+// RunConfigCommand
+
+func (s ConfigTestSuite) Test_RunConfigCommand_WritesEffectiveConfig() {
+	readFileOrig := util.ReadFile
+	configWriterOrig := configWriter
+	defer func() {
+		util.ReadFile = readFileOrig
+		configWriter = configWriterOrig
+	}()
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte("target: app"), nil
+	}
+	os.Args = []string{"myProgram", "config", "--scale", "3"}
+	actual := new(bytes.Buffer)
+	configWriter = actual
+
+	err := RunConfigCommand()
+
+	s.NoError(err)
+	s.Contains(actual.String(), "\ntarget: app # file\n")
+	s.Contains(actual.String(), "\nscale: \"3\" # flag\n")
+	s.Contains(actual.String(), "\nproxy_reconf_port: \"8080\" # default\n")
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
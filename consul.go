@@ -1,22 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"./util"
 )
 
 const ConsulScaleKey = "scale"
 const ConsulColorKey = "color"
+const ConsulRunKey = "run"
 
 type Consul struct{}
 
-func (c Consul) GetScaleCalc(address, serviceName, scale string) (int, error) {
+func (c Consul) GetScaleCalc(ctx context.Context, address, serviceName, scale string) (int, error) {
 	s := 1
 	inc := 0
-	resp, err := http.Get(fmt.Sprintf("%s/v1/kv/docker-flow/%s/scale?raw", address, serviceName))
+	resp, err := c.get(ctx, fmt.Sprintf("%s/v1/kv/docker-flow/%s/scale?raw", address, serviceName))
 	if err != nil {
 		return 0, fmt.Errorf("Please make sure that Consul address is correct\n%s", err.Error())
 	}
@@ -39,8 +43,8 @@ func (c Consul) GetScaleCalc(address, serviceName, scale string) (int, error) {
 	return total, nil
 }
 
-func (c Consul) GetColor(address, serviceName string) (string, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/v1/kv/docker-flow/%s/color?raw", address, serviceName))
+func (c Consul) GetColor(ctx context.Context, address, serviceName string) (string, error) {
+	resp, err := c.get(ctx, fmt.Sprintf("%s/v1/kv/docker-flow/%s/color?raw", address, serviceName))
 	if err != nil {
 		return "", fmt.Errorf("Could not retrieve the color from Consul. Please make sure that Consul address is correct\n%s", err.Error())
 	}
@@ -60,19 +64,64 @@ func (c Consul) GetNextColor(currentColor string) string {
 	return BlueColor
 }
 
-func (c Consul) PutScale(address, serviceName string, value int) (string, error) {
-	return c.putValue(address, serviceName, ConsulScaleKey, strconv.Itoa(value))
+func (c Consul) PutScale(ctx context.Context, address, serviceName string, value int) (string, error) {
+	return c.putValue(ctx, address, serviceName, ConsulScaleKey, strconv.Itoa(value))
 }
 
-func (c Consul) PutColor(address, serviceName string, value string) (string, error) {
-	return c.putValue(address, serviceName, ConsulColorKey, value)
+func (c Consul) PutColor(ctx context.Context, address, serviceName string, value string) (string, error) {
+	return c.putValue(ctx, address, serviceName, ConsulColorKey, value)
 }
 
-func (c Consul) putValue(address, serviceName, key, value string) (string, error) {
+func (c Consul) GetRun(ctx context.Context, address, serviceName string) (FlowRun, error) {
+	run := FlowRun{}
+	resp, err := c.get(ctx, fmt.Sprintf("%s/v1/kv/docker-flow/%s/%s?raw", address, serviceName, ConsulRunKey))
+	if err != nil {
+		return run, fmt.Errorf("Could not retrieve the flow run from Consul. Please make sure that Consul address is correct\n%s", err.Error())
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound || len(data) == 0 {
+		return run, nil
+	}
+	if err := json.Unmarshal(data, &run); err != nil {
+		return run, fmt.Errorf("Could not parse the flow run stored in Consul\n%s", err.Error())
+	}
+	return run, nil
+}
+
+func (c Consul) PutRun(ctx context.Context, address, serviceName string, run FlowRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	_, err = c.putValue(ctx, address, serviceName, ConsulRunKey, string(data))
+	return err
+}
+
+func (c Consul) get(ctx context.Context, url string) (*http.Response, error) {
+	var resp *http.Response
+	err := util.Retry(ctx, fmt.Sprintf("Consul request %s", url), func() error {
+		request, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return util.Permanent(err)
+		}
+		resp, err = c.do(request.WithContext(ctx))
+		return err
+	})
+	return resp, err
+}
+
+func (c Consul) putValue(ctx context.Context, address, serviceName, key, value string) (string, error) {
 	url := fmt.Sprintf("%s/v1/kv/docker-flow/%s/%s", address, serviceName, key)
-	client := &http.Client{}
-	request, _ := http.NewRequest("PUT", url, strings.NewReader(value))
-	resp, err := client.Do(request)
+	var resp *http.Response
+	err := util.Retry(ctx, fmt.Sprintf("Consul request %s", url), func() error {
+		request, err := http.NewRequest("PUT", url, strings.NewReader(value))
+		if err != nil {
+			return util.Permanent(err)
+		}
+		resp, err = c.do(request.WithContext(ctx))
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("Could not store store information in Consul\n%s", err.Error())
 	}
@@ -80,3 +129,15 @@ func (c Consul) putValue(address, serviceName, key, value string) (string, error
 	data, _ := ioutil.ReadAll(resp.Body)
 	return string(data), nil
 }
+
+func (c Consul) do(request *http.Request) (*http.Response, error) {
+	resp, err := httpDo(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 500 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Consul responded with status code %d", resp.StatusCode)
+	}
+	return resp, nil
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/suite"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strconv"
 	"testing"
+	"time"
+	"./util"
 )
 
 type ConsulTestSuite struct {
@@ -18,6 +23,7 @@ type ConsulTestSuite struct {
 	ServiceColor     string
 	PutScaleResponse string
 	PutColorResponse string
+	ServiceRun       string
 }
 
 func (s *ConsulTestSuite) SetupTest() {
@@ -26,9 +32,11 @@ func (s *ConsulTestSuite) SetupTest() {
 	s.ServiceColor = BlueColor
 	s.PutScaleResponse = "PUT_SCALE"
 	s.PutColorResponse = "PUT_COLOR"
+	s.ServiceRun = `{"id":"myRunId","status":"failed","steps":["deploy","proxy"],"completed_steps":["deploy"],"position":1,"failed_step":"proxy","current_color":"green","next_color":"blue","deployed":true}`
 	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		scaleGetUrl := fmt.Sprintf("/v1/kv/docker-flow/%s/scale?raw", s.ServiceName)
 		colorGetUrl := fmt.Sprintf("/v1/kv/docker-flow/%s/color?raw", s.ServiceName)
+		runGetUrl := fmt.Sprintf("/v1/kv/docker-flow/%s/run?raw", s.ServiceName)
 		scalePutUrl := fmt.Sprintf("/v1/kv/docker-flow/%s/scale?", s.ServiceName)
 		colorPutUrl := fmt.Sprintf("/v1/kv/docker-flow/%s/color?", s.ServiceName)
 		actualUrl := fmt.Sprintf("%s?%s", r.URL.Path, r.URL.RawQuery)
@@ -37,6 +45,8 @@ func (s *ConsulTestSuite) SetupTest() {
 				fmt.Fprint(w, s.ConsulScale)
 			} else if actualUrl == colorGetUrl {
 				fmt.Fprint(w, s.ServiceColor)
+			} else if actualUrl == runGetUrl {
+				fmt.Fprint(w, s.ServiceRun)
 			} else {
 				fmt.Fprint(w, "")
 			}
@@ -52,19 +62,19 @@ func (s *ConsulTestSuite) SetupTest() {
 }
 
 func (s ConsulTestSuite) Test_GetScaleCalc_Returns1() {
-	actual, _ := Consul{}.GetScaleCalc(s.Server.URL, "SERVICE_NEVER_DEPLOYED_BEFORE", "")
+	actual, _ := Consul{}.GetScaleCalc(context.Background(), s.Server.URL, "SERVICE_NEVER_DEPLOYED_BEFORE", "")
 
 	s.Equal(1, actual)
 }
 
 func (s ConsulTestSuite) Test_GetScaleCalc_ReturnsNumberFromConsul() {
-	actual, _ := Consul{}.GetScaleCalc(s.Server.URL, s.ServiceName, "")
+	actual, _ := Consul{}.GetScaleCalc(context.Background(), s.Server.URL, s.ServiceName, "")
 
 	s.Equal(s.ConsulScale, actual)
 }
 
 func (s ConsulTestSuite) Test_GetScaleCalc_ReturnsErrorFromHttpGet() {
-	_, err := Consul{}.GetScaleCalc("WRONG_URL", s.ServiceName, "")
+	_, err := Consul{}.GetScaleCalc(context.Background(), "WRONG_URL", s.ServiceName, "")
 
 	s.Error(err)
 }
@@ -72,47 +82,56 @@ func (s ConsulTestSuite) Test_GetScaleCalc_ReturnsErrorFromHttpGet() {
 func (s ConsulTestSuite) Test_GetScaleCalc_ReturnScaleFuncArg() {
 	expected := 7
 
-	actual, _ := Consul{}.GetScaleCalc(s.Server.URL, s.ServiceName, strconv.Itoa(expected))
+	actual, _ := Consul{}.GetScaleCalc(context.Background(), s.Server.URL, s.ServiceName, strconv.Itoa(expected))
 
 	s.Equal(expected, actual)
 }
 
 func (suite ConsulTestSuite) Test_GetScaleCalc_IncrementsScale() {
-	actual, _ := Consul{}.GetScaleCalc(suite.Server.URL, suite.ServiceName, "+2")
+	actual, _ := Consul{}.GetScaleCalc(context.Background(), suite.Server.URL, suite.ServiceName, "+2")
 
 	suite.Equal(suite.ConsulScale+2, actual)
 }
 
 func (suite ConsulTestSuite) Test_GetScaleCalc_DecrementsScale() {
-	actual, _ := Consul{}.GetScaleCalc(suite.Server.URL, suite.ServiceName, "-2")
+	actual, _ := Consul{}.GetScaleCalc(context.Background(), suite.Server.URL, suite.ServiceName, "-2")
 
 	suite.Equal(suite.ConsulScale-2, actual)
 }
 
 func (suite ConsulTestSuite) Test_GetScaleCalc_Returns1_WhenScaleIsNegativeOrZero() {
-	actual, _ := Consul{}.GetScaleCalc(suite.Server.URL, suite.ServiceName, "-100")
+	actual, _ := Consul{}.GetScaleCalc(context.Background(), suite.Server.URL, suite.ServiceName, "-100")
 
 	suite.Equal(1, actual)
 }
 
 func (suite ConsulTestSuite) Test_GetColor_ReturnsGreen() {
-	actual, _ := Consul{}.GetColor(suite.Server.URL, "SERVICE_NEVER_DEPLOYED_BEFORE")
+	actual, _ := Consul{}.GetColor(context.Background(), suite.Server.URL, "SERVICE_NEVER_DEPLOYED_BEFORE")
 
 	suite.Equal(GreenColor, actual)
 }
 
 func (suite ConsulTestSuite) Test_GetColor_ReturnServiceColor() {
-	actual, _ := Consul{}.GetColor(suite.Server.URL, suite.ServiceName)
+	actual, _ := Consul{}.GetColor(context.Background(), suite.Server.URL, suite.ServiceName)
 
 	suite.Equal(suite.ServiceColor, actual)
 }
 
 func (suite ConsulTestSuite) Test_GetColor_ReturnsErrorFromHttpGet() {
-	_, err := Consul{}.GetColor("WRONG_URL", suite.ServiceName)
+	_, err := Consul{}.GetColor(context.Background(), "WRONG_URL", suite.ServiceName)
 
 	suite.Error(err)
 }
 
+func (s ConsulTestSuite) Test_GetColor_ReturnsError_WhenContextIsCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Consul{}.GetColor(ctx, s.Server.URL, s.ServiceName)
+
+	s.Error(err)
+}
+
 func (suite ConsulTestSuite) Test_GetNextColor_ReturnsBlueWhenGreen() {
 	actual := Consul{}.GetNextColor(GreenColor)
 
@@ -126,29 +145,131 @@ func (suite ConsulTestSuite) Test_GetNextColor_ReturnsGreenWhenBlue() {
 }
 
 func (suite ConsulTestSuite) Test_PutScale_PutsToConsul() {
-	actual, _ := Consul{}.PutScale(suite.Server.URL, suite.ServiceName, 34)
+	actual, _ := Consul{}.PutScale(context.Background(), suite.Server.URL, suite.ServiceName, 34)
 
 	suite.Equal(suite.PutScaleResponse, actual)
 }
 
 func (suite ConsulTestSuite) Test_GetScaleCalc_ReturnsErrorFromHttpPut() {
-	_, err := Consul{}.PutScale("WRONG_URL", suite.ServiceName, 45)
+	_, err := Consul{}.PutScale(context.Background(), "WRONG_URL", suite.ServiceName, 45)
 
 	suite.Error(err)
 }
 
 func (suite ConsulTestSuite) Test_PutColor_PutsToConsul() {
-	actual, _ := Consul{}.PutColor(suite.Server.URL, suite.ServiceName, "orange")
+	actual, _ := Consul{}.PutColor(context.Background(), suite.Server.URL, suite.ServiceName, "orange")
 
 	suite.Equal(suite.PutColorResponse, actual)
 }
 
 func (suite ConsulTestSuite) Test_GetColorCalc_ReturnsErrorFromHttpPut() {
-	_, err := Consul{}.PutColor("WRONG_URL", suite.ServiceName, "purple")
+	_, err := Consul{}.PutColor(context.Background(), "WRONG_URL", suite.ServiceName, "purple")
 
 	suite.Error(err)
 }
 
+func (s ConsulTestSuite) Test_GetRun_ReturnsEmptyRun_WhenServiceWasNeverRun() {
+	actual, err := Consul{}.GetRun(context.Background(), s.Server.URL, "SERVICE_NEVER_DEPLOYED_BEFORE")
+
+	s.NoError(err)
+	s.Equal(FlowRun{}, actual)
+}
+
+func (s ConsulTestSuite) Test_GetRun_ReturnsRunFromConsul() {
+	expected := FlowRun{
+		Id:             "myRunId",
+		Status:         FlowRunStatusFailed,
+		Steps:          []string{"deploy", "proxy"},
+		CompletedSteps: []string{"deploy"},
+		Position:       1,
+		FailedStep:     "proxy",
+		CurrentColor:   GreenColor,
+		NextColor:      BlueColor,
+		Deployed:       true,
+	}
+
+	actual, _ := Consul{}.GetRun(context.Background(), s.Server.URL, s.ServiceName)
+
+	s.Equal(expected, actual)
+}
+
+func (s ConsulTestSuite) Test_GetRun_ReturnsError_WhenRunIsNotValid() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "not json")
+	}))
+	defer server.Close()
+
+	_, err := Consul{}.GetRun(context.Background(), server.URL, s.ServiceName)
+
+	s.Error(err)
+}
+
+func (s ConsulTestSuite) Test_GetRun_ReturnsErrorFromHttpGet() {
+	_, err := Consul{}.GetRun(context.Background(), "WRONG_URL", s.ServiceName)
+
+	s.Error(err)
+}
+
+func (s ConsulTestSuite) Test_PutRun_PutsJsonToConsul() {
+	actualPath := ""
+	actualBody := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		actualPath = r.URL.Path
+		actualBody = string(body)
+	}))
+	defer server.Close()
+	run := FlowRun{Id: "myRunId", Status: FlowRunStatusCompleted}
+	expected, _ := json.Marshal(run)
+
+	err := Consul{}.PutRun(context.Background(), server.URL, s.ServiceName, run)
+
+	s.NoError(err)
+	s.Equal(fmt.Sprintf("/v1/kv/docker-flow/%s/run", s.ServiceName), actualPath)
+	s.Equal(string(expected), actualBody)
+}
+
+func (s ConsulTestSuite) Test_PutRun_ReturnsErrorFromHttpPut() {
+	err := Consul{}.PutRun(context.Background(), "WRONG_URL", s.ServiceName, FlowRun{})
+
+	s.Error(err)
+}
+
+func (s ConsulTestSuite) Test_GetColor_RetriesServerErrors() {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, GreenColor)
+	}))
+	defer server.Close()
+	ctx := util.WithRetryPolicy(context.Background(), util.RetryPolicy{Attempts: 2, Delay: time.Millisecond})
+
+	actual, err := Consul{}.GetColor(ctx, server.URL, s.ServiceName)
+
+	s.NoError(err)
+	s.Equal(GreenColor, actual)
+	s.Equal(2, calls)
+}
+
+func (s ConsulTestSuite) Test_PutRun_ReturnsError_WhenServerErrorsPersist() {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	ctx := util.WithRetryPolicy(context.Background(), util.RetryPolicy{Attempts: 3, Delay: time.Millisecond})
+
+	err := Consul{}.PutRun(ctx, server.URL, s.ServiceName, FlowRun{})
+
+	s.Error(err)
+	s.Equal(3, calls)
+}
+
 func TestConsulTestSuite(t *testing.T) {
 	dockerHost := os.Getenv("DOCKER_HOST")
 	dockerCertPath := os.Getenv("DOCKER_CERT_PATH")
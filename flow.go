@@ -1,21 +1,31 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 	"./compose"
+	"./util"
 )
 
 type Flowable interface {
-	Deploy(opts Opts, dc compose.DockerComposer) error
+	Run(ctx context.Context, opts Opts) ([]StepResult, error)
+	Deploy(ctx context.Context, opts Opts, dc compose.DockerComposer) error
 	GetPullTargets(opts Opts) []string
-	Scale(opts Opts, dc compose.DockerComposer, target string, createFlowFile bool) error
-	Proxy(opts Opts, proxy Proxy) error
+	Scale(ctx context.Context, opts Opts, dc compose.DockerComposer, target string, createFlowFile bool) error
+	Proxy(ctx context.Context, opts Opts, proxy Proxy, color string) error
 }
 
 const FLOW_DEPLOY = "deploy"
 const FLOW_SCALE = "scale"
 const FLOW_STOP_OLD = "stop-old"
 const FLOW_PROXY = "proxy"
+const FLOW_TEST = "test"
+const FLOW_WAIT = "wait"
+const FLOW_NOTIFY = "notify"
+const FLOW_SHELL = "shell"
 
 type Flow struct{}
 
@@ -25,8 +35,66 @@ func getFlow() Flowable {
 	return flow
 }
 
-func (m Flow) Deploy(opts Opts, dc compose.DockerComposer) error {
+func (m Flow) Run(ctx context.Context, opts Opts) ([]StepResult, error) {
+	ctx, cancel := getRequestContext(ctx, time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+	ctx = util.WithRetryPolicy(ctx, getRetryPolicy(opts))
+	if len(opts.Services) > 0 {
+		return m.runServices(ctx, opts)
+	}
+	stages := opts.Pipeline
+	if len(stages) == 0 {
+		stages = pipeline.GetStages(opts.Flow)
+	}
+	run := NewFlowRun(opts, stages)
+	if opts.Resume {
+		var err error
+		if run, err = m.getResumedRun(ctx, opts, stages); err != nil {
+			return []StepResult{}, err
+		}
+		opts.CurrentColor = run.CurrentColor
+		opts.NextColor = run.NextColor
+		opts.CurrentTarget = run.CurrentTarget
+		opts.NextTarget = run.NextTarget
+		logPrintln(fmt.Sprintf("Resuming the flow %s from the %s step...", run.Id, run.Steps[run.Position]))
+	}
+	dc := compose.GetDockerCompose()
+	flowPath, err := dc.NewFlowPath()
+	if err != nil {
+		return []StepResult{}, err
+	}
+	defer func() {
+		if err := dc.Cleanup(flowPath); err != nil {
+			logPrintf("Could not clean up temporary files\n%s", err.Error())
+		}
+	}()
+	opts.FlowPath = flowPath
+	state := NewRunState(opts, dc)
+	state.Deployed = run.Deployed
+	state.Run = &run
+	ctx = getReport(ctx).addService(ctx, opts, run.Id)
+	ctx = withLogFields(ctx, state, "")
+	err = pipeline.Run(ctx, state, stages)
+	getReportService(ctx).setResults(state.Results, err)
+	return state.Results, err
+}
+
+func (m Flow) getResumedRun(ctx context.Context, opts Opts, stages []Stage) (FlowRun, error) {
+	run, err := getServiceDiscovery().GetRun(ctx, opts.ServiceDiscoveryAddress, opts.ServiceName)
+	if err != nil {
+		return run, err
+	}
+	if err := run.ValidateResume(stages); err != nil {
+		return run, fmt.Errorf("Could not resume the flow of the service %s\n%s", opts.ServiceName, err.Error())
+	}
+	run.Status = FlowRunStatusRunning
+	run.FailedStep = ""
+	return run, nil
+}
+
+func (m Flow) Deploy(ctx context.Context, opts Opts, dc compose.DockerComposer) error {
 	if err := dc.CreateFlowFile(
+		opts.FlowPath,
 		opts.ComposePath,
 		opts.ServiceName,
 		opts.Target,
@@ -36,32 +104,33 @@ func (m Flow) Deploy(opts Opts, dc compose.DockerComposer) error {
 	); err != nil {
 		return fmt.Errorf("Failed to create the Docker Flow file\n%s\n", err.Error())
 	}
-	logPrintln(fmt.Sprintf("Deploying (%s)...", opts.NextTarget))
+	logContextPrintln(ctx, fmt.Sprintf("Deploying (%s)...", opts.NextTarget))
 
-	if err := dc.PullTargets(opts.Host, opts.CertPath, opts.Project, m.GetPullTargets(opts)); err != nil {
+	if err := dc.PullTargets(ctx, opts.FlowPath, opts.ComposePath, opts.Host, opts.CertPath, opts.Project, m.GetPullTargets(opts)); err != nil {
 		return fmt.Errorf("The deployment phase failed (pull)\n%s", err.Error())
 	}
 	if opts.BlueGreen {
-		if err := dc.RmTargets(opts.Host, opts.CertPath, opts.Project, []string{opts.NextTarget}); err != nil {
+		if err := dc.RmTargets(ctx, opts.FlowPath, opts.ComposePath, opts.Host, opts.CertPath, opts.Project, []string{opts.NextTarget}); err != nil {
 			return fmt.Errorf("The deployment phase failed (rm)\n%s", err.Error())
 		}
 	}
 	targets := append(opts.SideTargets, opts.NextTarget)
-	if err := dc.UpTargets(opts.Host, opts.CertPath, opts.Project, targets); err != nil {
+	if err := dc.UpTargets(ctx, opts.FlowPath, opts.ComposePath, opts.Host, opts.CertPath, opts.Project, targets); err != nil {
 		return fmt.Errorf("The deployment phase failed (up)\n%s", err.Error())
 	}
-	if err := m.Scale(opts, dc, opts.NextTarget, false); err != nil {
+	if err := m.Scale(ctx, opts, dc, opts.NextTarget, false); err != nil {
 		return err
 	}
-	if err := dc.RemoveFlow(); err != nil {
+	if err := dc.RemoveFlow(opts.FlowPath); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m Flow) Scale(opts Opts, dc compose.DockerComposer, target string, createFlowFile bool) error {
+func (m Flow) Scale(ctx context.Context, opts Opts, dc compose.DockerComposer, target string, createFlowFile bool) error {
 	if createFlowFile {
 		if err := dc.CreateFlowFile(
+			opts.FlowPath,
 			opts.ComposePath,
 			opts.ServiceName,
 			opts.Target,
@@ -73,16 +142,17 @@ func (m Flow) Scale(opts Opts, dc compose.DockerComposer, target string, createF
 		}
 	}
 	sc := getServiceDiscovery()
-	scale, err := sc.GetScaleCalc(opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
+	scale, err := sc.GetScaleCalc(ctx, opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
 	if err != nil {
 		return err
 	}
-	if err := dc.ScaleTargets(opts.Host, opts.CertPath, opts.Project, target, scale); err != nil {
+	if err := dc.ScaleTargets(ctx, opts.FlowPath, opts.ComposePath, opts.Host, opts.CertPath, opts.Project, target, scale); err != nil {
 		return fmt.Errorf("The scale phase failed\n%s", err.Error())
 	}
-	sc.PutScale(opts.ServiceDiscoveryAddress, opts.ServiceName, scale)
+	getReportService(ctx).setScale(scale)
+	sc.PutScale(ctx, opts.ServiceDiscoveryAddress, opts.ServiceName, scale)
 	if createFlowFile {
-		if err := dc.RemoveFlow(); err != nil {
+		if err := dc.RemoveFlow(opts.FlowPath); err != nil {
 			return err
 		}
 	}
@@ -94,35 +164,124 @@ func (m Flow) Test(opts Opts, dc compose.DockerComposer) {
 
 
 
-func (m Flow) Proxy(opts Opts, proxy Proxy) error {
-	if err := proxy.Provision(
-		opts.ProxyDockerHost,
-		opts.ProxyReconfPort,
-		opts.ProxyDockerCertPath,
-		opts.ServiceDiscoveryAddress,
-	); err != nil {
-		return err
+func (m Flow) Proxy(ctx context.Context, opts Opts, proxy Proxy, color string) error {
+	instances := m.getProxyInstances(opts)
+	errs := make([]error, len(instances))
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func(i int, instance ProxyInstance) {
+			defer wg.Done()
+			errs[i] = m.proxyInstance(ctx, opts, proxy, instance, color)
+		}(i, instance)
 	}
-	color := opts.CurrentColor
-	if m.contains(opts.Flow, FLOW_DEPLOY) {
-		color = opts.NextColor
+	wg.Wait()
+	return m.getProxyError(opts.ProxyFailPolicy, instances, errs)
+}
+
+func (m Flow) proxyInstance(ctx context.Context, opts Opts, proxy Proxy, instance ProxyInstance, color string) error {
+	if err := proxy.Provision(m.getProvisionRequest(ctx, opts, instance)); err != nil {
+		return err
 	}
-	if err := proxy.Reconfigure(
-		opts.ProxyDockerHost,
-		opts.ProxyDockerCertPath,
-		opts.ProxyHost,
-		opts.ProxyReconfPort,
-		opts.ServiceName,
-		color,
-		opts.ServicePath,
-		opts.ConsulTemplateFePath,
-		opts.ConsulTemplateBePath,
-	); err != nil {
+	req := m.getReconfigureRequest(ctx, opts, instance, color)
+	if err := proxy.Reconfigure(req); err != nil {
 		return err
 	}
+	if opts.ProxyVerify {
+		if err := proxy.Verify(req); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (m Flow) getProvisionRequest(ctx context.Context, opts Opts, instance ProxyInstance) ProvisionRequest {
+	return ProvisionRequest{
+		Context:        ctx,
+		Timeout:        time.Duration(opts.ProxyTimeout) * time.Second,
+		DockerHost:     instance.DockerHost,
+		DockerCertPath: instance.DockerCertPath,
+		ReconfPort:     instance.ReconfPort,
+		ScAddress:      opts.ServiceDiscoveryAddress,
+		Container: ProxyContainer{
+			Name:    opts.ProxyName,
+			Image:   opts.ProxyImage,
+			Ports:   opts.ProxyPorts,
+			Env:     opts.ProxyEnv,
+			Volumes: opts.ProxyVolumes,
+			Restart: opts.ProxyRestart,
+			Network: opts.ProxyNetwork,
+		},
+	}
+}
+
+func (m Flow) getReconfigureRequest(ctx context.Context, opts Opts, instance ProxyInstance, color string) ReconfigureRequest {
+	return ReconfigureRequest{
+		Context:              ctx,
+		Timeout:              time.Duration(opts.ProxyTimeout) * time.Second,
+		DockerHost:           instance.DockerHost,
+		DockerCertPath:       instance.DockerCertPath,
+		Host:                 instance.Host,
+		ReconfPort:           instance.ReconfPort,
+		ContainerName:        opts.ProxyName,
+		ServiceName:          opts.ServiceName,
+		ServiceColor:         color,
+		ServicePath:          opts.ServicePath,
+		ServiceDomain:        opts.ServiceDomain,
+		PathType:             opts.ServicePathType,
+		HttpsOnly:            opts.HttpsOnly,
+		CertPath:             opts.ServiceCertPath,
+		Params:               opts.ProxyParams,
+		ConsulTemplateFePath: opts.ConsulTemplateFePath,
+		ConsulTemplateBePath: opts.ConsulTemplateBePath,
+		VerifyHeader:         opts.ProxyVerifyHeader,
+		VerifyTimeout:        time.Duration(opts.ProxyVerifyTimeout) * time.Second,
+	}
+}
+
+func (m Flow) getProxyInstances(opts Opts) []ProxyInstance {
+	if len(opts.Proxies) == 0 {
+		return []ProxyInstance{{
+			Host:           opts.ProxyHost,
+			DockerHost:     opts.ProxyDockerHost,
+			DockerCertPath: opts.ProxyDockerCertPath,
+			ReconfPort:     opts.ProxyReconfPort,
+		}}
+	}
+	instances := make([]ProxyInstance, len(opts.Proxies))
+	for i, instance := range opts.Proxies {
+		if len(instance.DockerCertPath) == 0 {
+			instance.DockerCertPath = opts.ProxyDockerCertPath
+		}
+		if len(instance.ReconfPort) == 0 {
+			instance.ReconfPort = opts.ProxyReconfPort
+		}
+		instances[i] = instance
+	}
+	return instances
+}
+
+func (m Flow) getProxyError(failPolicy string, instances []ProxyInstance, errs []error) error {
+	failed := []string{}
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", instances[i].Host, err.Error()))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if len(instances) == 1 {
+		return errs[0]
+	}
+	msg := strings.Join(failed, "\n")
+	if failPolicy == ProxyFailPolicyQuorum && len(failed)*2 <= len(instances) {
+		logPrintf("%d out of %d proxies could not be reconfigured\n%s", len(failed), len(instances), msg)
+		return nil
+	}
+	return fmt.Errorf("%d out of %d proxies could not be reconfigured\n%s", len(failed), len(instances), msg)
+}
+
 func (m Flow) GetPullTargets(opts Opts) []string {
 	targets := make([]string, 0)
 	targets = append(targets, opts.NextTarget)
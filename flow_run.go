@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const FlowRunStatusRunning = "running"
+const FlowRunStatusFailed = "failed"
+const FlowRunStatusCompleted = "completed"
+const FlowRunStatusInterrupted = "interrupted"
+const FlowRunPutTimeout = 10 * time.Second
+
+type FlowRun struct {
+	Id             string   `json:"id"`
+	Status         string   `json:"status"`
+	Steps          []string `json:"steps"`
+	CompletedSteps []string `json:"completed_steps"`
+	Position       int      `json:"position"`
+	FailedStep     string   `json:"failed_step,omitempty"`
+	CurrentColor   string   `json:"current_color"`
+	NextColor      string   `json:"next_color"`
+	CurrentTarget  string   `json:"current_target"`
+	NextTarget     string   `json:"next_target"`
+	Deployed       bool     `json:"deployed"`
+}
+
+var getFlowRunId = func() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func NewFlowRun(opts Opts, stages []Stage) FlowRun {
+	return FlowRun{
+		Id:             getFlowRunId(),
+		Status:         FlowRunStatusRunning,
+		Steps:          getFlowRunSteps(stages),
+		CompletedSteps: []string{},
+		CurrentColor:   opts.CurrentColor,
+		NextColor:      opts.NextColor,
+		CurrentTarget:  opts.CurrentTarget,
+		NextTarget:     opts.NextTarget,
+	}
+}
+
+func (r FlowRun) IsUnfinished() bool {
+	return r.Status == FlowRunStatusRunning || r.Status == FlowRunStatusFailed || r.Status == FlowRunStatusInterrupted
+}
+
+func (r FlowRun) ValidateResume(stages []Stage) error {
+	if !r.IsUnfinished() {
+		return fmt.Errorf("There is no unfinished flow to resume")
+	}
+	steps := getFlowRunSteps(stages)
+	if len(steps) != len(r.Steps) || r.Position < 0 || r.Position >= len(steps) {
+		return fmt.Errorf("The steps of the flow %s do not match the current steps", r.Id)
+	}
+	for i := range steps {
+		if steps[i] != r.Steps[i] {
+			return fmt.Errorf("The steps of the flow %s do not match the current steps", r.Id)
+		}
+	}
+	return nil
+}
+
+func getFlowRunSteps(stages []Stage) []string {
+	names := []string{}
+	for _, stage := range stages {
+		names = append(names, pipeline.getStageName(stage))
+	}
+	return names
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type FlowRunTestSuite struct {
+	suite.Suite
+	stages []Stage
+}
+
+func (s *FlowRunTestSuite) SetupTest() {
+	s.stages = []Stage{{Type: FLOW_DEPLOY}, {Name: "myProxy", Type: FLOW_PROXY}}
+}
+
+// NewFlowRun
+
+func (s FlowRunTestSuite) Test_NewFlowRun_ReturnsRunningRun() {
+	getFlowRunIdOrig := getFlowRunId
+	defer func() { getFlowRunId = getFlowRunIdOrig }()
+	getFlowRunId = func() string { return "myRunId" }
+	opts := Opts{CurrentColor: "blue", NextColor: "green", CurrentTarget: "myTarget-blue", NextTarget: "myTarget-green"}
+	expected := FlowRun{
+		Id:             "myRunId",
+		Status:         FlowRunStatusRunning,
+		Steps:          []string{FLOW_DEPLOY, "myProxy"},
+		CompletedSteps: []string{},
+		CurrentColor:   "blue",
+		NextColor:      "green",
+		CurrentTarget:  "myTarget-blue",
+		NextTarget:     "myTarget-green",
+	}
+
+	actual := NewFlowRun(opts, s.stages)
+
+	s.Equal(expected, actual)
+}
+
+func (s FlowRunTestSuite) Test_NewFlowRun_GeneratesUniqueIds() {
+	s.NotEqual(NewFlowRun(Opts{}, s.stages).Id, NewFlowRun(Opts{}, s.stages).Id)
+}
+
+// ValidateResume
+
+func (s FlowRunTestSuite) Test_ValidateResume_ReturnsNil_WhenRunIsUnfinished() {
+	for _, status := range []string{FlowRunStatusRunning, FlowRunStatusFailed, FlowRunStatusInterrupted} {
+		run := FlowRun{Status: status, Steps: []string{FLOW_DEPLOY, "myProxy"}, Position: 1}
+
+		s.NoError(run.ValidateResume(s.stages))
+	}
+}
+
+func (s FlowRunTestSuite) Test_ValidateResume_ReturnsError_WhenRunIsFinished() {
+	for _, status := range []string{"", FlowRunStatusCompleted} {
+		run := FlowRun{Status: status, Steps: []string{FLOW_DEPLOY, "myProxy"}}
+
+		s.Error(run.ValidateResume(s.stages))
+	}
+}
+
+func (s FlowRunTestSuite) Test_ValidateResume_ReturnsError_WhenStepsChanged() {
+	run := FlowRun{Status: FlowRunStatusFailed, Steps: []string{FLOW_DEPLOY, FLOW_PROXY}, Position: 1}
+
+	s.Error(run.ValidateResume(s.stages))
+}
+
+func (s FlowRunTestSuite) Test_ValidateResume_ReturnsError_WhenPositionIsOutOfRange() {
+	run := FlowRun{Status: FlowRunStatusFailed, Steps: []string{FLOW_DEPLOY, "myProxy"}, Position: 2}
+
+	s.Error(run.ValidateResume(s.stages))
+}
+
+// Suite
+
+func TestFlowRunTestSuite(t *testing.T) {
+	suite.Run(t, new(FlowRunTestSuite))
+}
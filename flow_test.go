@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"os"
 	"testing"
+	"time"
 	"./compose"
 )
 
@@ -46,6 +48,460 @@ func (s *FlowTestSuite) SetupTest() {
 	logPrintln = func(v ...interface{}) {}
 }
 
+// Run
+
+func (s FlowTestSuite) Test_Run_InvokesFlowDeploy_WhenDeploy() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "Deploy", mock.Anything, s.opts, s.dc)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenDeployFails() {
+	mockObj := getFlowMock("Deploy")
+	mockObj.On("Deploy", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	flow = mockObj
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesServiceDiscoveryPutColor_WhenDeploy() {
+	mockObj := getServiceDiscoveryMock(s.opts, "")
+	serviceDiscovery = mockObj
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "PutColor", mock.Anything, s.opts.ServiceDiscoveryAddress, s.opts.ServiceName, s.opts.NextColor)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenDeployAndServiceDiscoveryPutColorFails() {
+	mockObj := getServiceDiscoveryMock(s.opts, "PutColor")
+	mockObj.On("PutColor", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", fmt.Errorf("This is an error"))
+	serviceDiscovery = mockObj
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesFlowScale_WhenScaleAndNotDeploy() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Flow = []string{FLOW_SCALE}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "Scale", mock.Anything, s.opts, s.dc, s.opts.CurrentTarget, true)
+}
+
+func (s FlowTestSuite) Test_Run_DoesNotInvokeFlowScale_WhenScaleAndDeploy() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertNotCalled(s.T(), "Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenScaleFails() {
+	mockObj := getFlowMock("Scale")
+	mockObj.On("Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	flow = mockObj
+	s.opts.Flow = []string{FLOW_SCALE}
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesDockerComposeCreateFlowFileWithCurrentColor_WhenStopOldAndDeployed() {
+	mockObj := getDockerComposeMock(s.opts, "")
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_DEPLOY, FLOW_STOP_OLD}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(
+		s.T(),
+		"CreateFlowFile",
+		s.opts.FlowPath,
+		s.opts.ComposePath,
+		s.opts.ServiceName,
+		s.opts.Target,
+		s.opts.SideTargets,
+		s.opts.CurrentColor,
+		s.opts.BlueGreen,
+	)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesDockerComposeCreateFlowFileWithNextColor_WhenStopOldAndNotDeployed() {
+	mockObj := getDockerComposeMock(s.opts, "")
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_STOP_OLD}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(
+		s.T(),
+		"CreateFlowFile",
+		s.opts.FlowPath,
+		s.opts.ComposePath,
+		s.opts.ServiceName,
+		s.opts.Target,
+		s.opts.SideTargets,
+		s.opts.NextColor,
+		s.opts.BlueGreen,
+	)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenStopOldAndDockerComposeCreateFlowFileFails() {
+	mockObj := getDockerComposeMock(s.opts, "CreateFlowFile")
+	mockObj.On(
+		"CreateFlowFile",
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+	).Return(fmt.Errorf("This is an error"))
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_STOP_OLD}
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesDockerComposeStopTargetWithCurrentTarget_WhenStopOldAndDeployed() {
+	mockObj := getDockerComposeMock(s.opts, "")
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_DEPLOY, FLOW_STOP_OLD}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "StopTargets", mock.Anything, mock.Anything, mock.Anything, s.opts.Host, s.opts.CertPath, s.opts.Project, []string{s.opts.CurrentTarget})
+}
+
+func (s FlowTestSuite) Test_Run_InvokesDockerComposeStopTargetWithNextTarget_WhenStopOldAndNotDeployed() {
+	mockObj := getDockerComposeMock(s.opts, "")
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_STOP_OLD}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "StopTargets", mock.Anything, mock.Anything, mock.Anything, s.opts.Host, s.opts.CertPath, s.opts.Project, []string{s.opts.NextTarget})
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenStopOldAndDockerComposeStopTargetsFails() {
+	mockObj := getDockerComposeMock(s.opts, "StopTargets")
+	mockObj.On("StopTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_STOP_OLD}
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_DoesNotRunStopOld_WhenStopOldAndNotBlueGreen() {
+	mockObj := getDockerComposeMock(s.opts, "")
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_STOP_OLD}
+	s.opts.BlueGreen = false
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertNotCalled(s.T(), "StopTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesDockerComposeRemoveFlow_WhenStopOld() {
+	mockObj := getDockerComposeMock(s.opts, "")
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_STOP_OLD}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "RemoveFlow", mock.Anything)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenStopOldAndDockerComposeRemoveFlowFails() {
+	mockObj := getDockerComposeMock(s.opts, "RemoveFlow")
+	mockObj.On("RemoveFlow", mock.Anything).Return(fmt.Errorf("This is an error"))
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	s.opts.Flow = []string{FLOW_STOP_OLD}
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesFlowProxy_WhenProxy() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Flow = []string{FLOW_PROXY}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "Proxy", mock.Anything, s.opts, haProxy, s.opts.CurrentColor)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesFlowProxyWithNextColor_WhenDeployed() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Flow = []string{FLOW_DEPLOY, FLOW_PROXY}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "Proxy", mock.Anything, mock.Anything, haProxy, s.opts.NextColor)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesFlowProxyWithCurrentColor_WhenFlowFailed() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+	RegisterStep("failing", StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		return fmt.Errorf("This is an error")
+	}))
+	defer func() { delete(steps, "failing") }()
+	s.opts.Pipeline = []Stage{
+		{Type: FLOW_DEPLOY},
+		{Type: "failing"},
+		{Type: FLOW_PROXY, When: StageWhenOnFailure},
+	}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "Proxy", mock.Anything, mock.Anything, haProxy, s.opts.CurrentColor)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesFlowProxyWithColorParam() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Pipeline = []Stage{
+		{Type: FLOW_DEPLOY},
+		{Type: FLOW_PROXY, Params: map[string]string{"color": ProxyColorCurrent}},
+	}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "Proxy", mock.Anything, mock.Anything, haProxy, s.opts.CurrentColor)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenProxyColorParamIsInvalid() {
+	s.opts.Pipeline = []Stage{{Type: FLOW_PROXY, Params: map[string]string{"color": "purple"}}}
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenProxyFails() {
+	mockObj := getFlowMock("Proxy")
+	mockObj.On("Proxy", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	flow = mockObj
+	s.opts.Flow = []string{FLOW_PROXY}
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_RunsPipelineStages_WhenPipelineIsSpecified() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Pipeline = []Stage{{Type: FLOW_PROXY}}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertNotCalled(s.T(), "Deploy", mock.Anything, mock.Anything, mock.Anything)
+	mockObj.AssertCalled(s.T(), "Proxy", mock.Anything, s.opts, haProxy, s.opts.CurrentColor)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsStepResults() {
+	mockObj := getFlowMock("Proxy")
+	mockObj.On("Proxy", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	flow = mockObj
+	s.opts.Flow = []string{FLOW_DEPLOY, FLOW_PROXY, FLOW_STOP_OLD}
+
+	actual, _ := Flow{}.Run(context.Background(), s.opts)
+
+	s.Len(actual, 3)
+	s.Equal(StepStatusSuccess, actual[0].Status)
+	s.Equal(StepStatusFailure, actual[1].Status)
+	s.Error(actual[1].Error)
+	s.Equal(StepStatusSkipped, actual[2].Status)
+}
+
+func (s FlowTestSuite) Test_Run_InvokesRegisteredStep() {
+	actual := false
+	RegisterStep("custom", StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		actual = state.Deployed
+		return nil
+	}))
+	defer func() { delete(steps, "custom") }()
+	s.opts.Flow = []string{FLOW_DEPLOY, "custom"}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	s.True(actual)
+}
+
+func (s FlowTestSuite) Test_Run_CancelsSteps_WhenTimeoutIsReached() {
+	cancelled := make(chan error, 1)
+	RegisterStep("slow", StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return ctx.Err()
+	}))
+	defer func() { delete(steps, "slow") }()
+	s.opts.Flow = []string{"slow"}
+	s.opts.Timeout = 1
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+	select {
+	case actual := <-cancelled:
+		s.Equal(context.DeadlineExceeded, actual)
+	case <-time.After(time.Second):
+		s.Fail("The step was not cancelled")
+	}
+}
+
+func (s FlowTestSuite) Test_Run_PassesNewFlowPathToSteps() {
+	mockObj := getDockerComposeMock(s.opts, "NewFlowPath")
+	mockObj.On("NewFlowPath").Return("/tmp/docker-flow-123/docker-compose-flow.yml", nil)
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	flowMock := getFlowMock("")
+	flow = flowMock
+	expected := s.opts
+	expected.FlowPath = "/tmp/docker-flow-123/docker-compose-flow.yml"
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	flowMock.AssertCalled(s.T(), "Deploy", mock.Anything, expected, mockObj)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenNewFlowPathFails() {
+	mockObj := getDockerComposeMock(s.opts, "NewFlowPath")
+	mockObj.On("NewFlowPath").Return("", fmt.Errorf("This is an error"))
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s FlowTestSuite) Test_Run_CleansUpFlowPath() {
+	mockObj := getDockerComposeMock(s.opts, "NewFlowPath")
+	mockObj.On("NewFlowPath").Return("/tmp/docker-flow-123/docker-compose-flow.yml", nil)
+	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	flowMock := getFlowMock("Deploy")
+	flowMock.On("Deploy", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	flow = flowMock
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "Cleanup", "/tmp/docker-flow-123/docker-compose-flow.yml")
+}
+
+func (s FlowTestSuite) Test_Run_StoresCompletedRun() {
+	mockObj := getServiceDiscoveryMock(s.opts, "")
+	serviceDiscovery = mockObj
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "PutRun", mock.Anything, s.opts.ServiceDiscoveryAddress, s.opts.ServiceName, mock.MatchedBy(func(run FlowRun) bool {
+		return run.Status == FlowRunStatusCompleted &&
+			run.NextColor == s.opts.NextColor &&
+			len(run.CompletedSteps) == 2
+	}))
+}
+
+func (s FlowTestSuite) Test_Run_StoresFailedStep_WhenStepFails() {
+	mockObj := getServiceDiscoveryMock(s.opts, "")
+	serviceDiscovery = mockObj
+	flowMock := getFlowMock("Proxy")
+	flowMock.On("Proxy", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	flow = flowMock
+	s.opts.Flow = []string{FLOW_DEPLOY, FLOW_PROXY}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "PutRun", mock.Anything, s.opts.ServiceDiscoveryAddress, s.opts.ServiceName, mock.MatchedBy(func(run FlowRun) bool {
+		return run.Status == FlowRunStatusFailed && run.FailedStep == FLOW_PROXY && run.Position == 1 && run.Deployed
+	}))
+}
+
+func (s FlowTestSuite) Test_Run_ContinuesFromFailedStep_WhenResume() {
+	run := FlowRun{
+		Id:             "myRunId",
+		Status:         FlowRunStatusFailed,
+		Steps:          []string{FLOW_DEPLOY, FLOW_PROXY},
+		CompletedSteps: []string{FLOW_DEPLOY},
+		Position:       1,
+		FailedStep:     FLOW_PROXY,
+		CurrentColor:   "blue",
+		NextColor:      "green",
+		CurrentTarget:  "myTarget-blue",
+		NextTarget:     "myTarget-green",
+		Deployed:       true,
+	}
+	sdMock := getServiceDiscoveryMock(s.opts, "GetRun")
+	sdMock.On("GetRun", mock.Anything, s.opts.ServiceDiscoveryAddress, s.opts.ServiceName).Return(run, nil)
+	serviceDiscovery = sdMock
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Flow = []string{FLOW_DEPLOY, FLOW_PROXY}
+	s.opts.Resume = true
+	expected := s.opts
+	expected.CurrentColor = run.CurrentColor
+	expected.NextColor = run.NextColor
+	expected.CurrentTarget = run.CurrentTarget
+	expected.NextTarget = run.NextTarget
+
+	actual, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.NoError(err)
+	mockObj.AssertNotCalled(s.T(), "Deploy", mock.Anything, mock.Anything, mock.Anything)
+	mockObj.AssertCalled(s.T(), "Proxy", mock.Anything, expected, haProxy, run.NextColor)
+	s.Equal(StepStatusSkipped, actual[0].Status)
+	s.Equal(StepStatusSuccess, actual[1].Status)
+	sdMock.AssertCalled(s.T(), "PutRun", mock.Anything, s.opts.ServiceDiscoveryAddress, s.opts.ServiceName, mock.MatchedBy(func(actual FlowRun) bool {
+		return actual.Id == run.Id && actual.Status == FlowRunStatusCompleted
+	}))
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenResumeAndThereIsNoUnfinishedRun() {
+	sdMock := getServiceDiscoveryMock(s.opts, "GetRun")
+	sdMock.On("GetRun", mock.Anything, mock.Anything, mock.Anything).Return(FlowRun{Status: FlowRunStatusCompleted}, nil)
+	serviceDiscovery = sdMock
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Resume = true
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+	mockObj.AssertNotCalled(s.T(), "Deploy", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s FlowTestSuite) Test_Run_ReturnsError_WhenResumeAndGetRunFails() {
+	sdMock := getServiceDiscoveryMock(s.opts, "GetRun")
+	sdMock.On("GetRun", mock.Anything, mock.Anything, mock.Anything).Return(FlowRun{}, fmt.Errorf("This is an error"))
+	serviceDiscovery = sdMock
+	s.opts.Resume = true
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
 // Deploy
 
 func (s FlowTestSuite) Test_DeployReturnsNil() {
@@ -53,7 +509,7 @@ func (s FlowTestSuite) Test_DeployReturnsNil() {
 	mockObj := getDockerComposeMock(opts, "")
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	actual := Flow{}.Deploy(opts, mockObj)
+	actual := Flow{}.Deploy(context.Background(), opts, mockObj)
 
 	s.Nil(actual)
 }
@@ -64,11 +520,12 @@ func (s FlowTestSuite) Test_Deploy_InvokesDockerComposeCreateFlowFile_WhenDeploy
 	mockObj := getDockerComposeMock(s.opts, "")
 	s.dc = mockObj
 
-	Flow{}.Deploy(s.opts, s.dc)
+	Flow{}.Deploy(context.Background(), s.opts, s.dc)
 
 	mockObj.AssertCalled(
 		s.T(),
 		"CreateFlowFile",
+		s.opts.FlowPath,
 		s.opts.ComposePath,
 		s.opts.ServiceName,
 		s.opts.Target,
@@ -88,10 +545,11 @@ func (s MainTestSuite) Test_Deploy_ReturnsError_WhenDeployAndDockerComposeCreate
 		mock.Anything,
 		mock.Anything,
 		mock.Anything,
+		mock.Anything,
 	).Return(fmt.Errorf("This is an error"))
 	s.dc = mockObj
 
-	err := Flow{}.Deploy(s.opts, s.dc)
+	err := Flow{}.Deploy(context.Background(), s.opts, s.dc)
 
 	s.Error(err)
 }
@@ -109,18 +567,18 @@ func (s FlowTestSuite) Test_DeployInvokesPullTargets() {
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 	flow := Flow{}
 
-	flow.Deploy(opts, mockObj)
+	flow.Deploy(context.Background(), opts, mockObj)
 
-	mockObj.AssertCalled(s.T(), "PullTargets", opts.Host, opts.CertPath, opts.Project, flow.GetPullTargets(opts))
+	mockObj.AssertCalled(s.T(), "PullTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.CertPath, opts.Project, flow.GetPullTargets(opts))
 }
 
 func (s FlowTestSuite) Test_DeployReturnsError_WhenPullTargetsFails() {
 	opts := Opts{}
 	mockObj := getDockerComposeMock(opts, "PullTargets")
-	mockObj.On("PullTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	mockObj.On("PullTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	actual := Flow{}.Deploy(opts, mockObj)
+	actual := Flow{}.Deploy(context.Background(), opts, mockObj)
 
 	s.Error(actual)
 }
@@ -136,9 +594,9 @@ func (s FlowTestSuite) Test_DeployInvokesUpTargets() {
 	mockObj := getDockerComposeMock(opts, "")
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	Flow{}.Deploy(opts, mockObj)
+	Flow{}.Deploy(context.Background(), opts, mockObj)
 
-	mockObj.AssertCalled(s.T(), "UpTargets", opts.Host, opts.CertPath, opts.Project, append(opts.SideTargets, opts.NextTarget))
+	mockObj.AssertCalled(s.T(), "UpTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.CertPath, opts.Project, append(opts.SideTargets, opts.NextTarget))
 }
 
 func (s FlowTestSuite) Test_DeployReturnsError_WhenUpTargetsFails() {
@@ -150,10 +608,13 @@ func (s FlowTestSuite) Test_DeployReturnsError_WhenUpTargetsFails() {
 		mock.Anything,
 		mock.Anything,
 		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
 	).Return(fmt.Errorf("This is an error"))
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	actual := Flow{}.Deploy(opts, mockObj)
+	actual := Flow{}.Deploy(context.Background(), opts, mockObj)
 
 	s.Error(actual)
 }
@@ -170,9 +631,9 @@ func (s FlowTestSuite) Test_DeployInvokesRmTargets() {
 	mockObj := getDockerComposeMock(opts, "")
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	Flow{}.Deploy(opts, mockObj)
+	Flow{}.Deploy(context.Background(), opts, mockObj)
 
-	mockObj.AssertCalled(s.T(), "RmTargets", opts.Host, opts.CertPath, opts.Project, []string{opts.NextTarget})
+	mockObj.AssertCalled(s.T(), "RmTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.CertPath, opts.Project, []string{opts.NextTarget})
 }
 
 func (s FlowTestSuite) Test_DeployDoesNotInvokeRmTargets_WhenBlueGreenIsFalse() {
@@ -182,9 +643,9 @@ func (s FlowTestSuite) Test_DeployDoesNotInvokeRmTargets_WhenBlueGreenIsFalse()
 	mockObj := getDockerComposeMock(opts, "")
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	Flow{}.Deploy(opts, mockObj)
+	Flow{}.Deploy(context.Background(), opts, mockObj)
 
-	mockObj.AssertNotCalled(s.T(), "RmTargets", opts.Host, opts.Project, append(opts.SideTargets, opts.NextTarget))
+	mockObj.AssertNotCalled(s.T(), "RmTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.Project, append(opts.SideTargets, opts.NextTarget))
 }
 
 func (s FlowTestSuite) Test_DeployReturnsError_WhenRmTargetsFails() {
@@ -192,9 +653,9 @@ func (s FlowTestSuite) Test_DeployReturnsError_WhenRmTargetsFails() {
 		BlueGreen: true,
 	}
 	mockObj := getDockerComposeMock(opts, "RmTargets")
-	mockObj.On("RmTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	mockObj.On("RmTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
 
-	actual := Flow{}.Deploy(opts, mockObj)
+	actual := Flow{}.Deploy(context.Background(), opts, mockObj)
 	s.Error(actual)
 }
 
@@ -204,10 +665,10 @@ func (s FlowTestSuite) Test_DeployReturnsError_WhenGetScaleCalcFails() {
 	opts := Opts{}
 	mockObj := getDockerComposeMock(opts, "")
 	scMockObj := getServiceDiscoveryMock(opts, "GetScaleCalc")
-	scMockObj.On("GetScaleCalc", mock.Anything, mock.Anything, mock.Anything).Return(0, fmt.Errorf("This is an error"))
+	scMockObj.On("GetScaleCalc", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(0, fmt.Errorf("This is an error"))
 	serviceDiscovery = scMockObj
 
-	actual := Flow{}.Deploy(opts, mockObj)
+	actual := Flow{}.Deploy(context.Background(), opts, mockObj)
 
 	s.Error(actual)
 }
@@ -226,11 +687,11 @@ func (s FlowTestSuite) Test_DeployDoesNotInvokeScaleTargets() {
 	mockObj := getDockerComposeMock(opts, "")
 	flow := Flow{}
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
-	scale, _ := serviceDiscovery.GetScaleCalc(opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
+	scale, _ := serviceDiscovery.GetScaleCalc(context.Background(), opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
 
-	flow.Deploy(opts, mockObj)
+	flow.Deploy(context.Background(), opts, mockObj)
 
-	mockObj.AssertCalled(s.T(), "ScaleTargets", opts.Host, opts.CertPath, opts.Project, opts.NextTarget, scale)
+	mockObj.AssertCalled(s.T(), "ScaleTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.CertPath, opts.Project, opts.NextTarget, scale)
 }
 
 func (s FlowTestSuite) Test_DeployReturnsError_WhenScaleTargetsFails() {
@@ -243,10 +704,13 @@ func (s FlowTestSuite) Test_DeployReturnsError_WhenScaleTargetsFails() {
 		mock.Anything,
 		mock.Anything,
 		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
 	).Return(fmt.Errorf("This is an error"))
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	actual := Flow{}.Deploy(opts, mockObj)
+	actual := Flow{}.Deploy(context.Background(), opts, mockObj)
 
 	s.Error(actual)
 }
@@ -256,7 +720,7 @@ func (s FlowTestSuite) Test_DeployInvokesCreateFlowFileOnlyOnce() {
 	mockObj := getDockerComposeMock(opts, "")
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	Flow{}.Deploy(opts, mockObj)
+	Flow{}.Deploy(context.Background(), opts, mockObj)
 
 	mockObj.AssertNumberOfCalls(s.T(), "CreateFlowFile", 1)
 }
@@ -266,7 +730,7 @@ func (s FlowTestSuite) Test_DeployInvokesRemoveFlowFileOnlyOnce() {
 	mockObj := getDockerComposeMock(opts, "")
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	Flow{}.Deploy(opts, mockObj)
+	Flow{}.Deploy(context.Background(), opts, mockObj)
 
 	mockObj.AssertNumberOfCalls(s.T(), "CreateFlowFile", 1)
 }
@@ -282,11 +746,11 @@ func (s FlowTestSuite) Test_DeployInvokesPutScale() {
 	mockObj := getDockerComposeMock(opts, "")
 	scMockObj := getServiceDiscoveryMock(opts, "")
 	serviceDiscovery = scMockObj
-	scale, _ := serviceDiscovery.GetScaleCalc(opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
+	scale, _ := serviceDiscovery.GetScaleCalc(context.Background(), opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
 
-	Flow{}.Deploy(opts, mockObj)
+	Flow{}.Deploy(context.Background(), opts, mockObj)
 
-	scMockObj.AssertCalled(s.T(), "PutScale", opts.ServiceDiscoveryAddress, opts.ServiceName, scale)
+	scMockObj.AssertCalled(s.T(), "PutScale", mock.Anything, opts.ServiceDiscoveryAddress, opts.ServiceName, scale)
 }
 
 // Deploy > RemoveFlow
@@ -295,17 +759,17 @@ func (s FlowTestSuite) Test_Deploy_InvokesDockerComposeRemoveFlow() {
 	mockObj := getDockerComposeMock(s.opts, "")
 	s.dc = mockObj
 
-	Flow{}.Deploy(s.opts, s.dc)
+	Flow{}.Deploy(context.Background(), s.opts, s.dc)
 
-	mockObj.AssertCalled(s.T(), "RemoveFlow")
+	mockObj.AssertCalled(s.T(), "RemoveFlow", mock.Anything)
 }
 
 func (s FlowTestSuite) Test_Deploy_ReturnsError_WhenDockerComposeRemoveFlowFails() {
 	mockObj := getDockerComposeMock(s.opts, "RemoveFlow")
-	mockObj.On("RemoveFlow").Return(fmt.Errorf("This is an error"))
+	mockObj.On("RemoveFlow", mock.Anything).Return(fmt.Errorf("This is an error"))
 	s.dc = mockObj
 
-	err := Flow{}.Deploy(s.opts, s.dc)
+	err := Flow{}.Deploy(context.Background(), s.opts, s.dc)
 
 	s.Error(err)
 }
@@ -316,11 +780,12 @@ func (s FlowTestSuite) Test_Scale_InvokesDockerComposeCreateFlowFile() {
 	mockObj := getDockerComposeMock(s.opts, "")
 	s.dc = mockObj
 
-	Flow{}.Scale(s.opts, s.dc, s.opts.CurrentTarget, true)
+	Flow{}.Scale(context.Background(), s.opts, s.dc, s.opts.CurrentTarget, true)
 
 	mockObj.AssertCalled(
 		s.T(),
 		"CreateFlowFile",
+		s.opts.FlowPath,
 		s.opts.ComposePath,
 		s.opts.ServiceName,
 		s.opts.Target,
@@ -340,10 +805,11 @@ func (s MainTestSuite) Test_Scale_ReturnsError_WhenDeployAndDockerComposeCreateF
 		mock.Anything,
 		mock.Anything,
 		mock.Anything,
+		mock.Anything,
 	).Return(fmt.Errorf("This is an error"))
 	s.dc = mockObj
 
-	err := Flow{}.Scale(s.opts, s.dc, s.opts.CurrentTarget, true)
+	err := Flow{}.Scale(context.Background(), s.opts, s.dc, s.opts.CurrentTarget, true)
 
 	s.Error(err)
 }
@@ -355,9 +821,9 @@ func (s FlowTestSuite) Test_ScaleReturnsError_WhenGetScaleCalcFails() {
 	mockObj := getDockerComposeMock(opts, "")
 	scMockObj := getServiceDiscoveryMock(opts, "GetScaleCalc")
 	serviceDiscovery = scMockObj
-	scMockObj.On("GetScaleCalc", mock.Anything, mock.Anything, mock.Anything).Return(0, fmt.Errorf("This is an error"))
+	scMockObj.On("GetScaleCalc", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(0, fmt.Errorf("This is an error"))
 
-	actual := Flow{}.Scale(opts, mockObj, "myTarget", true)
+	actual := Flow{}.Scale(context.Background(), opts, mockObj, "myTarget", true)
 
 	s.Error(actual)
 }
@@ -376,11 +842,11 @@ func (s FlowTestSuite) Test_ScaleInvokesScaleTargets() {
 	flow := Flow{}
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 	target := "myTarget"
-	scale, _ := serviceDiscovery.GetScaleCalc(opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
+	scale, _ := serviceDiscovery.GetScaleCalc(context.Background(), opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
 
-	flow.Scale(opts, mockObj, target, true)
+	flow.Scale(context.Background(), opts, mockObj, target, true)
 
-	mockObj.AssertCalled(s.T(), "ScaleTargets", opts.Host, opts.CertPath, opts.Project, target, scale)
+	mockObj.AssertCalled(s.T(), "ScaleTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.CertPath, opts.Project, target, scale)
 }
 
 func (s FlowTestSuite) Test_ScaleReturnsError_WhenScaleTargetsFails() {
@@ -393,10 +859,13 @@ func (s FlowTestSuite) Test_ScaleReturnsError_WhenScaleTargetsFails() {
 		mock.Anything,
 		mock.Anything,
 		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
 	).Return(fmt.Errorf("This is an error"))
 	serviceDiscovery = getServiceDiscoveryMock(opts, "")
 
-	actual := Flow{}.Scale(opts, mockObj, "myTarget", true)
+	actual := Flow{}.Scale(context.Background(), opts, mockObj, "myTarget", true)
 
 	s.Error(actual)
 }
@@ -412,11 +881,11 @@ func (s FlowTestSuite) Test_ScaleInvokesPutScale() {
 	mockObj := getDockerComposeMock(opts, "")
 	scMockObj := getServiceDiscoveryMock(opts, "")
 	serviceDiscovery = scMockObj
-	scale, _ := scMockObj.GetScaleCalc(opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
+	scale, _ := scMockObj.GetScaleCalc(context.Background(), opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale)
 
-	Flow{}.Scale(opts, mockObj, "myTarget", true)
+	Flow{}.Scale(context.Background(), opts, mockObj, "myTarget", true)
 
-	scMockObj.AssertCalled(s.T(), "PutScale", opts.ServiceDiscoveryAddress, opts.ServiceName, scale)
+	scMockObj.AssertCalled(s.T(), "PutScale", mock.Anything, opts.ServiceDiscoveryAddress, opts.ServiceName, scale)
 }
 
 // Deploy > RemoveFlow
@@ -425,17 +894,17 @@ func (s FlowTestSuite) Test_Scale_InvokesDockerComposeRemoveFlow() {
 	mockObj := getDockerComposeMock(s.opts, "")
 	s.dc = mockObj
 
-	Flow{}.Scale(s.opts, s.dc, s.opts.CurrentTarget, true)
+	Flow{}.Scale(context.Background(), s.opts, s.dc, s.opts.CurrentTarget, true)
 
-	mockObj.AssertCalled(s.T(), "RemoveFlow")
+	mockObj.AssertCalled(s.T(), "RemoveFlow", mock.Anything)
 }
 
 func (s FlowTestSuite) Test_Scale_ReturnsError_WhenDockerComposeRemoveFlowFails() {
 	mockObj := getDockerComposeMock(s.opts, "RemoveFlow")
-	mockObj.On("RemoveFlow").Return(fmt.Errorf("This is an error"))
+	mockObj.On("RemoveFlow", mock.Anything).Return(fmt.Errorf("This is an error"))
 	s.dc = mockObj
 
-	err := Flow{}.Scale(s.opts, s.dc, s.opts.CurrentTarget, true)
+	err := Flow{}.Scale(context.Background(), s.opts, s.dc, s.opts.CurrentTarget, true)
 
 	s.Error(err)
 }
@@ -472,91 +941,289 @@ func (s FlowTestSuite) Test_GetTargetsExcludesSideTargets_WhenNotPullSideTargets
 
 func (s FlowTestSuite) Test_Proxy_InvokesProvision() {
 	mockObj := getProxyMock("")
+	expected := ProvisionRequest{
+		Context:        context.Background(),
+		DockerHost:     s.opts.ProxyDockerHost,
+		DockerCertPath: s.opts.ProxyDockerCertPath,
+		ScAddress:      s.opts.ServiceDiscoveryAddress,
+	}
 
-	Flow{}.Proxy(s.opts, mockObj)
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
 
-	mockObj.AssertCalled(
-		s.T(),
-		"Provision",
-		s.opts.ProxyDockerHost,
-		s.opts.ProxyDockerCertPath,
-		s.opts.ServiceDiscoveryAddress,
-	)
+	mockObj.AssertCalled(s.T(), "Provision", expected)
+}
+
+func (s FlowTestSuite) Test_Proxy_InvokesProvisionWithProxyContainer() {
+	mockObj := getProxyMock("")
+	s.opts.ProxyName = "myProxyName"
+	s.opts.ProxyImage = "myProxyImage:1.2"
+	s.opts.ProxyPorts = []string{"443:443"}
+	s.opts.ProxyEnv = []string{"STATS_USER=admin"}
+	s.opts.ProxyVolumes = []string{"/certs:/certs"}
+	s.opts.ProxyRestart = "always"
+	s.opts.ProxyNetwork = "myNetwork"
+	expected := ProvisionRequest{
+		Context:        context.Background(),
+		DockerHost:     s.opts.ProxyDockerHost,
+		DockerCertPath: s.opts.ProxyDockerCertPath,
+		ScAddress:      s.opts.ServiceDiscoveryAddress,
+		Container: ProxyContainer{
+			Name:    s.opts.ProxyName,
+			Image:   s.opts.ProxyImage,
+			Ports:   s.opts.ProxyPorts,
+			Env:     s.opts.ProxyEnv,
+			Volumes: s.opts.ProxyVolumes,
+			Restart: s.opts.ProxyRestart,
+			Network: s.opts.ProxyNetwork,
+		},
+	}
+
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
+
+	mockObj.AssertCalled(s.T(), "Provision", expected)
+}
+
+func (s FlowTestSuite) Test_Proxy_InvokesProvisionWithTimeout() {
+	mockObj := getProxyMock("")
+	s.opts.ProxyTimeout = 45
+
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
+
+	mockObj.AssertCalled(s.T(), "Provision", mock.MatchedBy(func(req ProvisionRequest) bool {
+		return req.Timeout == 45*time.Second
+	}))
 }
 
 func (s FlowTestSuite) Test_Proxy_ReturnsError_WhenProvisionFails() {
 	opts := Opts{}
 	mockObj := getProxyMock("Provision")
-	mockObj.On("Provision", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	mockObj.On("Provision", mock.Anything).Return(fmt.Errorf("This is an error"))
 
-	actual := Flow{}.Proxy(opts, mockObj)
+	actual := Flow{}.Proxy(context.Background(), opts, mockObj, opts.CurrentColor)
 
 	s.Error(actual)
 }
 
-func (s FlowTestSuite) Test_Proxy_InvokesReconfigure_WhenDeploy() {
+func (s FlowTestSuite) Test_Proxy_InvokesReconfigureWithColor() {
 	mockObj := getProxyMock("")
-	s.opts.Flow = []string{FLOW_DEPLOY}
+	expected := ReconfigureRequest{
+		Context:        context.Background(),
+		DockerHost:     s.opts.ProxyDockerHost,
+		DockerCertPath: s.opts.ProxyDockerCertPath,
+		Host:           s.opts.ProxyHost,
+		ReconfPort:     s.opts.ProxyReconfPort,
+		ServiceName:    s.opts.ServiceName,
+		ServiceColor:   s.opts.NextColor,
+		ServicePath:    s.opts.ServicePath,
+	}
 
-	Flow{}.Proxy(s.opts, mockObj)
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.NextColor)
 
-	mockObj.AssertCalled(
-		s.T(),
-		"Reconfigure",
-		s.opts.ProxyDockerHost,
-		s.opts.ProxyDockerCertPath,
-		s.opts.ProxyHost,
-		s.opts.ProxyReconfPort,
-		s.opts.ServiceName,
-		s.opts.NextColor,
-		s.opts.ServicePath,
-		"",
-		"",
-	)
+	mockObj.AssertCalled(s.T(), "Reconfigure", expected)
 }
 
-func (s FlowTestSuite) Test_Proxy_InvokesReconfigure_WhenScale() {
+func (s FlowTestSuite) Test_Proxy_InvokesReconfigure() {
 	mockObj := getProxyMock("")
-	s.opts.Flow = []string{FLOW_SCALE}
+	expected := ReconfigureRequest{
+		Context:        context.Background(),
+		DockerHost:     s.opts.ProxyDockerHost,
+		DockerCertPath: s.opts.ProxyDockerCertPath,
+		Host:           s.opts.ProxyHost,
+		ReconfPort:     s.opts.ProxyReconfPort,
+		ServiceName:    s.opts.ServiceName,
+		ServiceColor:   s.opts.CurrentColor,
+		ServicePath:    s.opts.ServicePath,
+	}
 
-	Flow{}.Proxy(s.opts, mockObj)
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
 
-	mockObj.AssertCalled(
-		s.T(),
-		"Reconfigure",
-		s.opts.ProxyDockerHost,
-		s.opts.ProxyDockerCertPath,
-		s.opts.ProxyHost,
-		s.opts.ProxyReconfPort,
-		s.opts.ServiceName,
-		s.opts.CurrentColor,
-		s.opts.ServicePath,
-		"",
-		"",
-	)
+	mockObj.AssertCalled(s.T(), "Reconfigure", expected)
+}
+
+func (s FlowTestSuite) Test_Proxy_InvokesReconfigureWithTimeout() {
+	mockObj := getProxyMock("")
+	s.opts.ProxyTimeout = 45
+
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
+
+	mockObj.AssertCalled(s.T(), "Reconfigure", mock.MatchedBy(func(req ReconfigureRequest) bool {
+		return req.Timeout == 45*time.Second
+	}))
 }
 
 func (s FlowTestSuite) Test_Proxy_ReturnsError_WhenReconfigureFails() {
 	s.opts.Flow = []string{FLOW_DEPLOY}
 	mockObj := getProxyMock("Reconfigure")
-	mockObj.On(
-		"Reconfigure",
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-	).Return(fmt.Errorf("This is an error"))
+	mockObj.On("Reconfigure", mock.Anything).Return(fmt.Errorf("This is an error"))
 
-	actual := Flow{}.Proxy(s.opts, mockObj)
+	actual := Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
 
 	s.Error(actual)
 }
 
+func (s FlowTestSuite) Test_Proxy_InvokesProvisionAndReconfigureForEachProxy() {
+	mockObj := getProxyMock("")
+	s.opts.Flow = []string{FLOW_DEPLOY}
+	s.opts.ProxyReconfPort = "1234"
+	s.opts.Proxies = []ProxyInstance{
+		{Host: "proxy1", DockerHost: "tcp://proxy1:2376", DockerCertPath: "/certs/proxy1"},
+		{Host: "proxy2", DockerHost: "tcp://proxy2:2376", ReconfPort: "4321"},
+	}
+
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.NextColor)
+
+	mockObj.AssertNumberOfCalls(s.T(), "Provision", 2)
+	mockObj.AssertNumberOfCalls(s.T(), "Reconfigure", 2)
+	mockObj.AssertCalled(s.T(), "Provision", ProvisionRequest{
+		Context:        context.Background(),
+		DockerHost:     "tcp://proxy1:2376",
+		DockerCertPath: "/certs/proxy1",
+		ReconfPort:     "1234",
+		ScAddress:      s.opts.ServiceDiscoveryAddress,
+	})
+	mockObj.AssertCalled(s.T(), "Provision", ProvisionRequest{
+		Context:        context.Background(),
+		DockerHost:     "tcp://proxy2:2376",
+		DockerCertPath: s.opts.ProxyDockerCertPath,
+		ReconfPort:     "4321",
+		ScAddress:      s.opts.ServiceDiscoveryAddress,
+	})
+	mockObj.AssertCalled(s.T(), "Reconfigure", ReconfigureRequest{
+		Context:        context.Background(),
+		DockerHost:     "tcp://proxy1:2376",
+		DockerCertPath: "/certs/proxy1",
+		Host:           "proxy1",
+		ReconfPort:     "1234",
+		ServiceName:    s.opts.ServiceName,
+		ServiceColor:   s.opts.NextColor,
+		ServicePath:    s.opts.ServicePath,
+	})
+	mockObj.AssertCalled(s.T(), "Reconfigure", ReconfigureRequest{
+		Context:        context.Background(),
+		DockerHost:     "tcp://proxy2:2376",
+		DockerCertPath: s.opts.ProxyDockerCertPath,
+		Host:           "proxy2",
+		ReconfPort:     "4321",
+		ServiceName:    s.opts.ServiceName,
+		ServiceColor:   s.opts.NextColor,
+		ServicePath:    s.opts.ServicePath,
+	})
+}
+
+func (s FlowTestSuite) Test_Proxy_ReturnsError_WhenAnyProxyFailsAndFailPolicyIsAny() {
+	s.opts.ProxyFailPolicy = ProxyFailPolicyAny
+	s.opts.Proxies = []ProxyInstance{{DockerHost: "proxy1"}, {DockerHost: "proxy2"}, {DockerHost: "proxy3"}}
+	mockObj := getProxyMock("Provision")
+	mockObj.On("Provision", getProvisionRequestMatcher("proxy1")).Return(fmt.Errorf("This is an error"))
+	mockObj.On("Provision", mock.Anything).Return(nil)
+
+	actual := Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
+
+	s.Error(actual)
+}
+
+func (s FlowTestSuite) Test_Proxy_ReturnsNil_WhenMinorityOfProxiesFailsAndFailPolicyIsQuorum() {
+	s.opts.ProxyFailPolicy = ProxyFailPolicyQuorum
+	s.opts.Proxies = []ProxyInstance{{DockerHost: "proxy1"}, {DockerHost: "proxy2"}, {DockerHost: "proxy3"}}
+	mockObj := getProxyMock("Provision")
+	mockObj.On("Provision", getProvisionRequestMatcher("proxy1")).Return(fmt.Errorf("This is an error"))
+	mockObj.On("Provision", mock.Anything).Return(nil)
+	logPrintf = func(format string, v ...interface{}) {}
+
+	actual := Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
+
+	s.Nil(actual)
+}
+
+func (s FlowTestSuite) Test_Proxy_ReturnsError_WhenMajorityOfProxiesFailsAndFailPolicyIsQuorum() {
+	s.opts.ProxyFailPolicy = ProxyFailPolicyQuorum
+	s.opts.Proxies = []ProxyInstance{{DockerHost: "proxy1"}, {DockerHost: "proxy2"}, {DockerHost: "proxy3"}}
+	mockObj := getProxyMock("Provision")
+	mockObj.On("Provision", getProvisionRequestMatcher("proxy3")).Return(nil)
+	mockObj.On("Provision", mock.Anything).Return(fmt.Errorf("This is an error"))
+
+	actual := Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
+
+	s.Error(actual)
+}
+
+func (s FlowTestSuite) Test_Proxy_DoesNotInvokeVerify_WhenProxyVerifyIsFalse() {
+	mockObj := getProxyMock("")
+
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
+
+	mockObj.AssertNotCalled(s.T(), "Verify", mock.Anything)
+}
+
+func (s FlowTestSuite) Test_Proxy_InvokesVerify_WhenProxyVerifyIsTrue() {
+	mockObj := getProxyMock("")
+	s.opts.Flow = []string{FLOW_DEPLOY}
+	s.opts.ProxyVerify = true
+	s.opts.ProxyVerifyHeader = "X-Color"
+	s.opts.ProxyVerifyTimeout = 12
+	expected := ReconfigureRequest{
+		Context:        context.Background(),
+		DockerHost:     s.opts.ProxyDockerHost,
+		DockerCertPath: s.opts.ProxyDockerCertPath,
+		Host:           s.opts.ProxyHost,
+		ReconfPort:     s.opts.ProxyReconfPort,
+		ServiceName:    s.opts.ServiceName,
+		ServiceColor:   s.opts.NextColor,
+		ServicePath:    s.opts.ServicePath,
+		VerifyHeader:   s.opts.ProxyVerifyHeader,
+		VerifyTimeout:  12 * time.Second,
+	}
+
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.NextColor)
+
+	mockObj.AssertCalled(s.T(), "Verify", expected)
+}
+
+func (s FlowTestSuite) Test_Proxy_ReturnsError_WhenVerifyFails() {
+	s.opts.ProxyVerify = true
+	mockObj := getProxyMock("Verify")
+	mockObj.On("Verify", mock.Anything).Return(fmt.Errorf("This is an error"))
+
+	actual := Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.CurrentColor)
+
+	s.Error(actual)
+}
+
+func (s FlowTestSuite) Test_Proxy_InvokesReconfigureWithServiceSettings() {
+	mockObj := getProxyMock("")
+	s.opts.Flow = []string{FLOW_DEPLOY}
+	s.opts.ProxyName = "myProxyName"
+	s.opts.ServiceDomain = []string{"my-domain.com", "my-other-domain.com"}
+	s.opts.ServicePathType = ProxyPathTypeRegex
+	s.opts.HttpsOnly = true
+	s.opts.ServiceCertPath = "/path/to/my-domain.pem"
+	s.opts.ProxyParams = []string{"skipCheck=true"}
+	s.opts.ConsulTemplateFePath = "/path/to/fe.tmpl"
+	s.opts.ConsulTemplateBePath = "/path/to/be.tmpl"
+	expected := ReconfigureRequest{
+		Context:        context.Background(),
+		DockerHost:           s.opts.ProxyDockerHost,
+		DockerCertPath:       s.opts.ProxyDockerCertPath,
+		Host:                 s.opts.ProxyHost,
+		ReconfPort:           s.opts.ProxyReconfPort,
+		ContainerName:        s.opts.ProxyName,
+		ServiceName:          s.opts.ServiceName,
+		ServiceColor:         s.opts.NextColor,
+		ServicePath:          s.opts.ServicePath,
+		ServiceDomain:        s.opts.ServiceDomain,
+		PathType:             s.opts.ServicePathType,
+		HttpsOnly:            s.opts.HttpsOnly,
+		CertPath:             s.opts.ServiceCertPath,
+		Params:               s.opts.ProxyParams,
+		ConsulTemplateFePath: s.opts.ConsulTemplateFePath,
+		ConsulTemplateBePath: s.opts.ConsulTemplateBePath,
+	}
+
+	Flow{}.Proxy(context.Background(), s.opts, mockObj, s.opts.NextColor)
+
+	mockObj.AssertCalled(s.T(), "Reconfigure", expected)
+}
+
 // Suite
 
 func TestFlowTestSuite(t *testing.T) {
@@ -575,8 +1242,13 @@ type FlowMock struct {
 	mock.Mock
 }
 
-func (m *FlowMock) Deploy(opts Opts, dc compose.DockerComposer) error {
-	args := m.Called(opts, dc)
+func (m *FlowMock) Run(ctx context.Context, opts Opts) ([]StepResult, error) {
+	args := m.Called(ctx, opts)
+	return nil, args.Error(0)
+}
+
+func (m *FlowMock) Deploy(ctx context.Context, opts Opts, dc compose.DockerComposer) error {
+	args := m.Called(ctx, opts, dc)
 	return args.Error(0)
 }
 
@@ -585,29 +1257,38 @@ func (m *FlowMock) GetPullTargets(opts Opts) []string {
 	return []string{}
 }
 
-func (m *FlowMock) Scale(opts Opts, dc compose.DockerComposer, target string, createFlowFile bool) error {
-	args := m.Called(opts, dc, target, createFlowFile)
+func (m *FlowMock) Scale(ctx context.Context, opts Opts, dc compose.DockerComposer, target string, createFlowFile bool) error {
+	args := m.Called(ctx, opts, dc, target, createFlowFile)
 	return args.Error(0)
 }
 
-func (m *FlowMock) Proxy(opts Opts, proxy Proxy) error {
-	args := m.Called(opts, proxy)
+func (m *FlowMock) Proxy(ctx context.Context, opts Opts, proxy Proxy, color string) error {
+	args := m.Called(ctx, opts, proxy, color)
 	return args.Error(0)
 }
 
 func getFlowMock(skipMethod string) *FlowMock {
 	mockObj := new(FlowMock)
+	if skipMethod != "Run" {
+		mockObj.On("Run", mock.Anything, mock.Anything).Return(nil)
+	}
 	if skipMethod != "Deploy" {
-		mockObj.On("Deploy", mock.Anything, mock.Anything).Return(nil)
+		mockObj.On("Deploy", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	}
 	if skipMethod != "GetPullTargets" {
 		mockObj.On("GetPullTargets", mock.Anything).Return(nil)
 	}
 	if skipMethod != "Scale" {
-		mockObj.On("Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockObj.On("Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	}
 	if skipMethod != "Proxy" {
-		mockObj.On("Proxy", mock.Anything, mock.Anything).Return(nil)
+		mockObj.On("Proxy", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	}
 	return mockObj
 }
+
+func getProvisionRequestMatcher(dockerHost string) interface{} {
+	return mock.MatchedBy(func(req ProvisionRequest) bool {
+		return req.DockerHost == dockerHost
+	})
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
-	"os"
+	"net/url"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 	"./util"
 )
@@ -16,9 +20,18 @@ const containerStatusExited = 2
 const containerStatusRemoved = 3
 const ProxyReconfigureDefaultPort = 8080
 const ConsulTemplatesDir = "/consul_templates"
+const ProxyDefaultName = "docker-flow-proxy"
+const ProxyDefaultImage = "vfarcic/docker-flow-proxy"
+const ProxyVerifyInterval = time.Second
 
 var haProxy Proxy = HaProxy{}
 
+// Checking whether the proxy container exists and running it is not atomic and neither is copying a Consul template
+// through its temporary file. Flows that do it at the same time are serialized by a lock per Docker host and container
+// or per template so that proxies on different hosts are still provisioned concurrently.
+var haProxyLocks = map[string]*sync.Mutex{}
+var haProxyLocksMutex = &sync.Mutex{}
+
 type HaProxy struct{}
 
 var runHaProxyRunCmd = func(cmd *exec.Cmd) error { return cmd.Run() }
@@ -26,130 +39,332 @@ var runHaProxyExecCmd = func(cmd *exec.Cmd) error { return cmd.Run() }
 var runHaProxyCpCmd = func(cmd *exec.Cmd) error { return cmd.Run() }
 var runHaProxyPsCmd = func(cmd *exec.Cmd) error { return cmd.Run() }
 var runHaProxyStartCmd = func(cmd *exec.Cmd) error { return cmd.Run() }
-var httpGet = http.Get
+var runHaProxyInspectCmd = func(cmd *exec.Cmd) error { return cmd.Run() }
+var runHaProxyRmCmd = func(cmd *exec.Cmd) error { return cmd.Run() }
+var httpDo = http.DefaultClient.Do
 
-func (m HaProxy) Provision(dockerHost, reconfPort, certPath, scAddress string) error {
-	if len(dockerHost) == 0 {
+func (m HaProxy) Provision(req ProvisionRequest) error {
+	if len(req.DockerHost) == 0 {
 		return fmt.Errorf("Proxy docker host is mandatory for the proxy step. Please set the proxy-docker-host argument.")
 	}
-	if len(scAddress) == 0 {
+	if len(req.ScAddress) == 0 {
 		return fmt.Errorf("Service Discovery Address is mandatory.")
 	}
-	util.SetDockerHost(dockerHost, certPath)
-	status, err := m.ps()
+	ctx, cancel := getRequestContext(req.Context, req.Timeout)
+	started, err := m.provisionContainer(ctx, req)
+	cancel()
 	if err != nil {
 		return err
 	}
+	if started {
+		// Waiting for the proxy to start is not limited by the proxy timeout but it stops with the flow
+		waitCtx, waitCancel := getRequestContext(req.Context, 0)
+		defer waitCancel()
+		if err := util.Sleep(waitCtx, time.Second*5); err != nil {
+			return fmt.Errorf("The flow was stopped while waiting for the proxy to start\n%s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (m HaProxy) provisionContainer(ctx context.Context, req ProvisionRequest) (bool, error) {
+	env := util.GetDockerEnv(req.DockerHost, req.DockerCertPath)
+	name := m.getContainerName(req.Container.Name)
+	defer m.lock(req.DockerHost + "/" + name)()
+	status, err := m.ps(ctx, env, name)
+	if err != nil {
+		return false, err
+	}
+	if status != containerStatusRemoved && len(req.Container.Image) > 0 {
+		upgrade, err := m.isImageOutdated(ctx, env, name, req.Container.Image)
+		if err != nil {
+			return false, err
+		}
+		if upgrade {
+			if err := m.rm(ctx, env, name); err != nil {
+				return false, err
+			}
+			status = containerStatusRemoved
+		}
+	}
 	switch status {
 	case containerStatusRunning:
-		return nil
+		return false, nil
 	case containerStatusExited:
-		if err := m.start(); err != nil {
-			return err
+		if err := m.start(ctx, env, name); err != nil {
+			return false, err
 		}
-		util.Sleep(time.Second * 5)
 	default:
-		if err := m.run(reconfPort, scAddress); err != nil {
-			return err
+		if err := m.run(ctx, env, req.ReconfPort, req.ScAddress, req.Container); err != nil {
+			return false, err
 		}
-		util.Sleep(time.Second * 5)
 	}
-	return nil
+	return true, nil
 }
 
-// TODO: Change args to struct
-func (m HaProxy) Reconfigure(
-	dockerHost, dockerCertPath, host, reconfPort, serviceName, serviceColor string,
-	servicePath []string,
-	consulTemplateFePath string, consulTemplateBePath string,
-) error {
-	if len(consulTemplateFePath) > 0 {
-		if err := m.sendConsulTemplatesToTheProxy(dockerHost, dockerCertPath, consulTemplateFePath, consulTemplateBePath, serviceName, serviceColor); err != nil {
+func (m HaProxy) Reconfigure(req ReconfigureRequest) error {
+	ctx, cancel := getRequestContext(req.Context, req.Timeout)
+	defer cancel()
+	if len(req.ConsulTemplateFePath) > 0 {
+		if err := m.sendConsulTemplatesToTheProxy(ctx, req); err != nil {
 			return err
 		}
-	} else if len(servicePath) == 0 {
+	} else if len(req.ServicePath) == 0 {
 		return fmt.Errorf("It is mandatory to specify servicePath or consulTemplatePath. Please set one of the two.")
 	}
-	if len(host) == 0 {
+	if len(req.Host) == 0 {
 		return fmt.Errorf("Proxy host is mandatory for the proxy step. Please set the proxy-host argument.")
 	}
-	if len(serviceName) == 0 {
+	if len(req.ServiceName) == 0 {
 		return fmt.Errorf("Service name is mandatory for the proxy step.")
 	}
-	if len(reconfPort) == 0 && !strings.Contains(host, ":") {
+	if len(req.ReconfPort) == 0 && !strings.Contains(req.Host, ":") {
 		return fmt.Errorf("Reconfigure port is mandatory.")
 	}
-	if err := m.sendReconfigureRequest(host, reconfPort, serviceName, serviceColor, servicePath, consulTemplateFePath, consulTemplateBePath); err != nil {
+	if len(req.CertPath) > 0 {
+		if err := m.sendCert(ctx, req); err != nil {
+			return err
+		}
+	}
+	if err := m.sendReconfigureRequest(ctx, req); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m HaProxy) sendReconfigureRequest(
-	host, reconfPort, serviceName, serviceColor string,
-	servicePath []string,
-	consulTemplateFePath, consulTemplateBePath string,
-) error {
-	address := host
-	if len(reconfPort) > 0 {
-		address = fmt.Sprintf("%s:%s", host, reconfPort)
-	}
-	if !strings.HasPrefix(strings.ToLower(address), "http") {
-		address = fmt.Sprintf("http://%s", address)
-	}
+func (m HaProxy) sendReconfigureRequest(ctx context.Context, req ReconfigureRequest) error {
 	proxyUrl := fmt.Sprintf(
 		"%s/v1/docker-flow-proxy/reconfigure?serviceName=%s",
-		address,
-		serviceName,
+		m.getAddress(req.Host, req.ReconfPort),
+		req.ServiceName,
 	)
-	if len(consulTemplateFePath) > 0 {
-		proxyUrl = fmt.Sprintf("%s&consulTemplateFePath=%s/%s-fe.tmpl&consulTemplateBePath=%s/%s-be.tmpl", proxyUrl, ConsulTemplatesDir, serviceName, ConsulTemplatesDir, serviceName)
+	if len(req.ConsulTemplateFePath) > 0 {
+		proxyUrl = fmt.Sprintf("%s&consulTemplateFePath=%s/%s-fe.tmpl&consulTemplateBePath=%s/%s-be.tmpl", proxyUrl, ConsulTemplatesDir, req.ServiceName, ConsulTemplatesDir, req.ServiceName)
 	} else {
-		if len(serviceColor) > 0 {
-			proxyUrl = fmt.Sprintf("%s&serviceColor=%s", proxyUrl, serviceColor)
+		if len(req.ServiceColor) > 0 {
+			proxyUrl = fmt.Sprintf("%s&serviceColor=%s", proxyUrl, req.ServiceColor)
+		}
+		path := strings.Join(req.ServicePath, ",")
+		if req.PathType == ProxyPathTypeRegex {
+			// Regular expressions may contain characters with a special meaning inside URLs
+			path = url.QueryEscape(path)
+		}
+		proxyUrl = fmt.Sprintf("%s&servicePath=%s", proxyUrl, path)
+	}
+	proxyUrl += m.getSettingsQuery(req)
+	logContextPrintf(ctx, "Sending request to %s to reconfigure the proxy", proxyUrl)
+	getReportService(ctx).addProxyUrl(proxyUrl)
+	return util.Retry(ctx, "The request to reconfigure the proxy", func() error {
+		resp, err := m.get(ctx, proxyUrl)
+		if err != nil {
+			return fmt.Errorf("The request to reconfigure the proxy failed\n%s\n", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			err := fmt.Errorf("The request to the proxy (%s) failed with status code %d\n", proxyUrl, resp.StatusCode)
+			if resp.StatusCode < 500 {
+				return util.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (m HaProxy) getSettingsQuery(req ReconfigureRequest) string {
+	query := url.Values{}
+	if len(req.ServiceDomain) > 0 {
+		query.Set("serviceDomain", strings.Join(req.ServiceDomain, ","))
+	}
+	switch req.PathType {
+	case ProxyPathTypePrefix:
+		query.Set("pathType", "path_beg")
+	case ProxyPathTypeRegex:
+		query.Set("pathType", "path_reg")
+	}
+	if req.HttpsOnly {
+		query.Set("httpsOnly", "true")
+	}
+	for _, param := range req.Params {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			query.Add(kv[0], kv[1])
+		} else {
+			query.Add(kv[0], "")
+		}
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "&" + query.Encode()
+}
+
+func (m HaProxy) sendCert(ctx context.Context, req ReconfigureRequest) error {
+	data, err := util.ReadFile(req.CertPath)
+	if err != nil {
+		return fmt.Errorf("Could not read the certificate %s\n%s", req.CertPath, err.Error())
+	}
+	certUrl := fmt.Sprintf(
+		"%s/v1/docker-flow-proxy/cert?certName=%s",
+		m.getAddress(req.Host, req.ReconfPort),
+		url.QueryEscape(filepath.Base(req.CertPath)),
+	)
+	logContextPrintf(ctx, "Sending the certificate %s to %s", req.CertPath, certUrl)
+	request, _ := http.NewRequest("PUT", certUrl, bytes.NewReader(data))
+	resp, err := httpDo(request.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("The request to send the certificate to the proxy failed\n%s\n", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("The request to the proxy (%s) failed with status code %d\n", certUrl, resp.StatusCode)
+	}
+	return nil
+}
+
+func (m HaProxy) Verify(req ReconfigureRequest) error {
+	if len(req.Host) == 0 {
+		return fmt.Errorf("Proxy host is mandatory for the proxy verification. Please set the proxy-host argument.")
+	}
+	// The verification has its own deadline, started once the proxy is reconfigured, so that a slow reconfiguration
+	// does not shorten it. Each attempt is limited by the timeout of the requests to the proxy.
+	ctx, cancel := getRequestContext(req.Context, req.VerifyTimeout+req.Timeout)
+	defer cancel()
+	fullServiceName := m.getFullServiceName(req.ServiceName, req.ServiceColor)
+	logContextPrintf(ctx, "Verifying that the proxy routes requests to %s...", fullServiceName)
+	attempts := int(req.VerifyTimeout / ProxyVerifyInterval)
+	var err error
+	for i := 0; i <= attempts; i++ {
+		attemptCtx, attemptCancel := getRequestContext(ctx, req.Timeout)
+		err = m.verify(attemptCtx, req)
+		attemptCancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if i < attempts {
+			logContextPrintf(ctx, "The proxy verification failed. Retrying...\n%s", err.Error())
+			if util.Sleep(ctx, ProxyVerifyInterval) != nil {
+				break
+			}
+		}
+	}
+	return fmt.Errorf("The proxy did not start routing requests to %s within %s\n%s", fullServiceName, req.VerifyTimeout.String(), err.Error())
+}
+
+func (m HaProxy) verify(ctx context.Context, req ReconfigureRequest) error {
+	// Requests redirected to HTTPS or matched by regular expressions cannot be sent directly
+	// so the proxy configuration is the only source of truth in those cases.
+	routable := !req.HttpsOnly && req.PathType != ProxyPathTypeRegex
+	if routable {
+		address := m.getAddress(req.Host, "")
+		for _, path := range req.ServicePath {
+			if err := m.verifyPath(ctx, fmt.Sprintf("%s%s", address, path), req); err != nil {
+				return err
+			}
 		}
-		proxyUrl = fmt.Sprintf("%s&servicePath=%s", proxyUrl, strings.Join(servicePath, ","))
 	}
-	logPrintf("Sending request to %s to reconfigure the proxy", proxyUrl)
-	resp, err := httpGet(proxyUrl)
+	if (len(req.VerifyHeader) == 0 || !routable) && len(req.ServiceColor) > 0 {
+		return m.verifyConfig(ctx, req)
+	}
+	return nil
+}
+
+func (m HaProxy) verifyPath(ctx context.Context, pathUrl string, req ReconfigureRequest) error {
+	request, _ := http.NewRequest("GET", pathUrl, nil)
+	if len(req.ServiceDomain) > 0 {
+		request.Host = req.ServiceDomain[0]
+	}
+	resp, err := httpDo(request.WithContext(ctx))
 	if err != nil {
-		return fmt.Errorf("The request to reconfigure the proxy failed\n%s\n", err.Error())
+		return fmt.Errorf("The request to %s failed\n%s", pathUrl, err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("The request to %s failed with status code %d", pathUrl, resp.StatusCode)
+	}
+	if len(req.VerifyHeader) > 0 && len(req.ServiceColor) > 0 {
+		if value := resp.Header.Get(req.VerifyHeader); !strings.Contains(value, req.ServiceColor) {
+			return fmt.Errorf("The response from %s has the header %s set to \"%s\" instead of the color %s", pathUrl, req.VerifyHeader, value, req.ServiceColor)
+		}
+	}
+	return nil
+}
+
+func (m HaProxy) verifyConfig(ctx context.Context, req ReconfigureRequest) error {
+	fullServiceName := m.getFullServiceName(req.ServiceName, req.ServiceColor)
+	configUrl := fmt.Sprintf("%s/v1/docker-flow-proxy/config", m.getAddress(req.Host, req.ReconfPort))
+	resp, err := m.get(ctx, configUrl)
+	if err != nil {
+		return fmt.Errorf("The request to %s failed\n%s", configUrl, err.Error())
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("The request to the proxy (%s) failed with status code %d\n", proxyUrl, resp.StatusCode)
+		return fmt.Errorf("The request to %s failed with status code %d", configUrl, resp.StatusCode)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(data), fullServiceName) {
+		return fmt.Errorf("The proxy configuration does not contain %s", fullServiceName)
 	}
 	return nil
 }
 
-func (m HaProxy) sendConsulTemplatesToTheProxy(dockerHost, dockerCertPath, consulTemplateFePath, consulTemplateBePath, serviceName, color string) error {
-	if err := m.sendConsulTemplateToTheProxy(dockerHost, dockerCertPath, consulTemplateFePath, serviceName, color, "fe"); err != nil {
+func (m HaProxy) get(ctx context.Context, url string) (*http.Response, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return httpDo(request.WithContext(ctx))
+}
+
+func (m HaProxy) getAddress(host, port string) string {
+	address := host
+	if len(port) > 0 {
+		address = fmt.Sprintf("%s:%s", host, port)
+	}
+	if !strings.HasPrefix(strings.ToLower(address), "http") {
+		address = fmt.Sprintf("http://%s", address)
+	}
+	return address
+}
+
+func (m HaProxy) getFullServiceName(serviceName, color string) string {
+	if len(color) == 0 {
+		return serviceName
+	}
+	return fmt.Sprintf("%s-%s", serviceName, color)
+}
+
+func (m HaProxy) sendConsulTemplatesToTheProxy(ctx context.Context, req ReconfigureRequest) error {
+	env := util.GetDockerEnv(req.DockerHost, req.DockerCertPath)
+	containerName := m.getContainerName(req.ContainerName)
+	if err := m.sendConsulTemplateToTheProxy(ctx, env, req.ConsulTemplateFePath, req.ServiceName, req.ServiceColor, "fe", containerName); err != nil {
 		return err
 	}
-	if err := m.sendConsulTemplateToTheProxy(dockerHost, dockerCertPath, consulTemplateBePath, serviceName, color, "be"); err != nil {
+	if err := m.sendConsulTemplateToTheProxy(ctx, env, req.ConsulTemplateBePath, req.ServiceName, req.ServiceColor, "be", containerName); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m HaProxy) sendConsulTemplateToTheProxy(dockerHost, dockerCertPath, consulTemplatePath, serviceName, color, templateType string) error {
+func (m HaProxy) sendConsulTemplateToTheProxy(ctx context.Context, env []string, consulTemplatePath, serviceName, color, templateType, containerName string) error {
+	defer m.lock(consulTemplatePath)()
 	if err := m.createTempConsulTemplate(consulTemplatePath, serviceName, color); err != nil {
 		return err
 	}
+	defer util.RemoveFile(fmt.Sprintf("%s.tmp", consulTemplatePath))
 	file := fmt.Sprintf("%s-%s.tmpl", serviceName, templateType)
-	if err := m.copyConsulTemplateToTheProxy(dockerHost, dockerCertPath, consulTemplatePath, file); err != nil {
-		return err
-	}
-	util.RemoveFile(fmt.Sprintf("%s.tmp", consulTemplatePath))
-	return nil
+	return m.copyConsulTemplateToTheProxy(ctx, env, consulTemplatePath, file, containerName)
 }
 
-func (m HaProxy) copyConsulTemplateToTheProxy(dockerHost, dockerCertPath, consulTemplatePath, templateName string) error {
-	util.SetDockerHost(dockerHost, dockerCertPath)
-	args := []string{"exec", "-i", "docker-flow-proxy", "mkdir", "-p", ConsulTemplatesDir}
-	execCmd := exec.Command("docker", args...)
-	execCmd.Stdout = os.Stdout
-	execCmd.Stderr = os.Stderr
+func (m HaProxy) copyConsulTemplateToTheProxy(ctx context.Context, env []string, consulTemplatePath, templateName, containerName string) error {
+	args := []string{"exec", "-i", containerName, "mkdir", "-p", ConsulTemplatesDir}
+	execCmd := exec.CommandContext(ctx, "docker", args...)
+	execCmd.Env = env
+	execCmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	execCmd.Stderr = util.OutputWriter(ctx, util.Stderr)
 	// TODO: Remove. Deprecated since Docker Flow: Proxy has that directory by default.
 	if err := runHaProxyExecCmd(execCmd); err != nil {
 		return err
@@ -157,11 +372,12 @@ func (m HaProxy) copyConsulTemplateToTheProxy(dockerHost, dockerCertPath, consul
 	args = []string{
 		"cp",
 		fmt.Sprintf("%s.tmp", consulTemplatePath),
-		fmt.Sprintf("docker-flow-proxy:%s/%s", ConsulTemplatesDir, templateName),
+		fmt.Sprintf("%s:%s/%s", containerName, ConsulTemplatesDir, templateName),
 	}
-	cpCmd := exec.Command("docker", args...)
-	cpCmd.Stdout = os.Stdout
-	cpCmd.Stderr = os.Stderr
+	cpCmd := exec.CommandContext(ctx, "docker", args...)
+	cpCmd.Env = env
+	cpCmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	cpCmd.Stderr = util.OutputWriter(ctx, util.Stderr)
 	if err := runHaProxyCpCmd(cpCmd); err != nil {
 		return err
 	}
@@ -185,35 +401,53 @@ func (m HaProxy) createTempConsulTemplate(consulTemplatePath, serviceName, color
 	return nil
 }
 
-func (m HaProxy) run(reconfPort, scAddress string) error {
-	logPrintln("Running the docker-flow-proxy container...")
+func (m HaProxy) run(ctx context.Context, env []string, reconfPort, scAddress string, container ProxyContainer) error {
+	name := m.getContainerName(container.Name)
+	logContextPrintf(ctx, "Running the %s container...", name)
 	args := []string{
 		"run", "-d",
-		"--name", "docker-flow-proxy",
+		"--name", name,
 		"-e", fmt.Sprintf("%s=%s", "CONSUL_ADDRESS", scAddress),
-		"-p", "80:80", "-p", fmt.Sprintf("%s:8080", reconfPort),
-		"vfarcic/docker-flow-proxy",
 	}
-	cmd := exec.Command("docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	for _, variable := range container.Env {
+		args = append(args, "-e", variable)
+	}
+	args = append(args, "-p", "80:80", "-p", fmt.Sprintf("%s:8080", reconfPort))
+	for _, port := range container.Ports {
+		args = append(args, "-p", port)
+	}
+	for _, volume := range container.Volumes {
+		args = append(args, "-v", volume)
+	}
+	if len(container.Restart) > 0 {
+		args = append(args, "--restart", container.Restart)
+	}
+	if len(container.Network) > 0 {
+		args = append(args, "--net", container.Network)
+	}
+	args = append(args, m.getImage(container.Image))
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = env
+	cmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
 	if err := runHaProxyRunCmd(cmd); err != nil {
 		return fmt.Errorf("Docker run command failed\n%s\n%s\n", strings.Join(cmd.Args, " "), err.Error())
 	}
 	return nil
 }
 
-func (m HaProxy) ps() (int, error) {
-	logPrintln("Checking status of the docker-flow-proxy container...")
+func (m HaProxy) ps(ctx context.Context, env []string, name string) (int, error) {
+	logContextPrintf(ctx, "Checking status of the %s container...", name)
 	args := []string{
 		"ps", "-a",
-		"--filter", "name=docker-flow-proxy",
+		"--filter", fmt.Sprintf("name=^/%s$", name),
 		"--format", "{{.Status}}",
 	}
-	cmd := exec.Command("docker", args...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = env
 	var out bytes.Buffer
 	cmd.Stdout = &out
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
 	if err := runHaProxyPsCmd(cmd); err != nil {
 		return 0, fmt.Errorf("Docker ps command failed\n%s\n%s\n", strings.Join(cmd.Args, " "), err.Error())
 	}
@@ -227,14 +461,81 @@ func (m HaProxy) ps() (int, error) {
 	return containerStatusRunning, nil
 }
 
-func (m HaProxy) start() error {
-	logPrintln("Starting the docker-flow-proxy container...")
-	args := []string{"start", "docker-flow-proxy"}
-	cmd := exec.Command("docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func (m HaProxy) start(ctx context.Context, env []string, name string) error {
+	logContextPrintf(ctx, "Starting the %s container...", name)
+	args := []string{"start", name}
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = env
+	cmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
 	if err := runHaProxyStartCmd(cmd); err != nil {
 		return fmt.Errorf("Docker start command failed\n%s\n%s\n", strings.Join(cmd.Args, " "), err.Error())
 	}
 	return nil
 }
+
+func (m HaProxy) isImageOutdated(ctx context.Context, env []string, name, image string) (bool, error) {
+	args := []string{"inspect", "--format", "{{.Config.Image}}", name}
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = env
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
+	if err := runHaProxyInspectCmd(cmd); err != nil {
+		return false, fmt.Errorf("Docker inspect command failed\n%s\n%s\n", strings.Join(cmd.Args, " "), err.Error())
+	}
+	actual := m.getImageWithTag(strings.TrimSpace(out.String()))
+	expected := m.getImageWithTag(image)
+	if actual != expected {
+		logContextPrintf(ctx, "The %s container uses the image %s instead of %s", name, actual, expected)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m HaProxy) rm(ctx context.Context, env []string, name string) error {
+	logContextPrintf(ctx, "Removing the %s container...", name)
+	args := []string{"rm", "-f", name}
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = env
+	cmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
+	if err := runHaProxyRmCmd(cmd); err != nil {
+		return fmt.Errorf("Docker rm command failed\n%s\n%s\n", strings.Join(cmd.Args, " "), err.Error())
+	}
+	return nil
+}
+
+// lock acquires the lock of the key and returns the function that releases it.
+func (m HaProxy) lock(key string) func() {
+	haProxyLocksMutex.Lock()
+	lock, ok := haProxyLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		haProxyLocks[key] = lock
+	}
+	haProxyLocksMutex.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (m HaProxy) getContainerName(name string) string {
+	if len(name) == 0 {
+		return ProxyDefaultName
+	}
+	return name
+}
+
+func (m HaProxy) getImage(image string) string {
+	if len(image) == 0 {
+		return ProxyDefaultImage
+	}
+	return image
+}
+
+func (m HaProxy) getImageWithTag(image string) string {
+	if strings.LastIndex(image, ":") <= strings.LastIndex(image, "/") {
+		return image + ":latest"
+	}
+	return image
+}
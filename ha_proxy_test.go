@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/suite"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 	"./util"
@@ -60,36 +63,70 @@ func (s *HaProxyTestSuite) SetupTest() {
 	runHaProxyStartCmd = func(cmd *exec.Cmd) error {
 		return nil
 	}
-	httpGetOrig := httpGet
-	defer func() { httpGet = httpGetOrig }()
-	httpGet = func(url string) (resp *http.Response, err error) {
-		return nil, nil
+	runHaProxyInspectCmd = func(cmd *exec.Cmd) error {
+		return nil
+	}
+	runHaProxyRmCmd = func(cmd *exec.Cmd) error {
+		return nil
 	}
 }
 
 // Provision
 
-func (s HaProxyTestSuite) Test_Provision_SetsDockerHost() {
-	actual := ""
-	SetDockerHostOrig := util.SetDockerHost
-	defer func() { util.SetDockerHost = SetDockerHostOrig }()
-	util.SetDockerHost = func(host, certPath string) {
-		actual = host
+func (s HaProxyTestSuite) Test_Provision_SetsDockerHostOfCommands() {
+	var actual []string
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		actual = cmd.Env
+		return nil
 	}
 
-	HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.DockerHost, ReconfPort: s.ReconfPort, DockerCertPath: s.DockerCertPath, ScAddress: s.ScAddress})
 
-	s.Equal(s.Host, actual)
+	s.Contains(actual, "DOCKER_HOST="+s.DockerHost)
+	s.Contains(actual, "DOCKER_CERT_PATH="+s.DockerCertPath)
+	s.NotEqual(s.DockerHost, os.Getenv("DOCKER_HOST"))
+}
+
+func (s HaProxyTestSuite) Test_Provision_ProvisionsProxiesOnDifferentHostsConcurrently() {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		started <- cmd.Env[len(cmd.Env)-1]
+		<-release
+		return nil
+	}
+	done := make(chan struct{}, 2)
+	for _, host := range []string{"tcp://proxy1:2376", "tcp://proxy2:2376"} {
+		go func(host string) {
+			HaProxy{}.Provision(ProvisionRequest{DockerHost: host, ReconfPort: s.ReconfPort, ScAddress: s.ScAddress})
+			done <- struct{}{}
+		}(host)
+	}
+
+	count := 0
+	timeout := time.After(time.Second)
+	for count < 2 {
+		select {
+		case <-started:
+			count++
+		case <-timeout:
+			s.Fail("Proxies on different hosts were not provisioned concurrently")
+			count = 2
+		}
+	}
+	close(release)
+	<-done
+	<-done
 }
 
 func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenProxyHostIsEmpty() {
-	err := HaProxy{}.Provision("", s.ReconfPort, s.CertPath, s.ScAddress)
+	err := HaProxy{}.Provision(ProvisionRequest{ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
 
 	s.Error(err)
 }
 
 func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenScAddressIsEmpty() {
-	err := HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, "")
+	err := HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath})
 
 	s.Error(err)
 }
@@ -108,7 +145,42 @@ func (s HaProxyTestSuite) Test_Provision_RunsDockerFlowProxyContainer() {
 		return nil
 	}
 
-	HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
+
+	s.Equal(expected, actual)
+}
+
+func (s HaProxyTestSuite) Test_Provision_RunsProxyContainerWithCustomSettings() {
+	var actual []string
+	container := ProxyContainer{
+		Name:    "my-proxy",
+		Image:   "my-org/my-proxy:1.2.3",
+		Ports:   []string{"443:443", "8081:8081"},
+		Env:     []string{"STATS_USER=admin", "STATS_PASS=secret"},
+		Volumes: []string{"/etc/certs:/certs"},
+		Restart: "always",
+		Network: "my-network",
+	}
+	expected := []string{
+		"docker", "run", "-d",
+		"--name", "my-proxy",
+		"-e", fmt.Sprintf("%s=%s", "CONSUL_ADDRESS", s.ScAddress),
+		"-e", "STATS_USER=admin",
+		"-e", "STATS_PASS=secret",
+		"-p", "80:80", "-p", fmt.Sprintf("%s:8080", s.ReconfPort),
+		"-p", "443:443",
+		"-p", "8081:8081",
+		"-v", "/etc/certs:/certs",
+		"--restart", "always",
+		"--net", "my-network",
+		"my-org/my-proxy:1.2.3",
+	}
+	runHaProxyRunCmd = func(cmd *exec.Cmd) error {
+		actual = cmd.Args
+		return nil
+	}
+
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress, Container: container})
 
 	s.Equal(expected, actual)
 }
@@ -118,7 +190,7 @@ func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenFailure() {
 		return fmt.Errorf("This is an error")
 	}
 
-	err := HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	err := HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
 
 	s.Error(err)
 }
@@ -127,7 +199,24 @@ func (s HaProxyTestSuite) Test_Provision_RunsDockerPs() {
 	var actual []string
 	expected := []string{
 		"docker", "ps", "-a",
-		"--filter", "name=docker-flow-proxy",
+		"--filter", "name=^/docker-flow-proxy$",
+		"--format", "{{.Status}}",
+	}
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		actual = cmd.Args
+		return nil
+	}
+
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
+
+	s.Equal(expected, actual)
+}
+
+func (s HaProxyTestSuite) Test_Provision_RunsDockerPsWithContainerName() {
+	var actual []string
+	expected := []string{
+		"docker", "ps", "-a",
+		"--filter", "name=^/my-proxy$",
 		"--format", "{{.Status}}",
 	}
 	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
@@ -135,7 +224,7 @@ func (s HaProxyTestSuite) Test_Provision_RunsDockerPs() {
 		return nil
 	}
 
-	HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress, Container: ProxyContainer{Name: "my-proxy"}})
 
 	s.Equal(expected, actual)
 }
@@ -145,7 +234,7 @@ func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenPsFailure() {
 		return fmt.Errorf("This is an docker ps error")
 	}
 
-	err := HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	err := HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
 
 	s.Error(err)
 }
@@ -155,7 +244,7 @@ func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenProxyFails() {
 		return fmt.Errorf("This is an docker ps error")
 	}
 
-	err := HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	err := HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
 
 	s.Error(err)
 }
@@ -170,7 +259,7 @@ func (s HaProxyTestSuite) Test_Provision_DoesNotRun_WhenProxyExists() {
 		cmd.Stdout.Write([]byte("Up 3 hours"))
 		return nil
 	}
-	HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
 
 	s.False(actual)
 }
@@ -191,7 +280,7 @@ func (s HaProxyTestSuite) Test_Provision_StartsAndDoesNotRun_WhenProxyIsExited()
 		return nil
 	}
 
-	HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
 
 	s.True(start)
 	s.False(run)
@@ -209,7 +298,7 @@ func (s HaProxyTestSuite) Test_Provision_StartsDockerFlowProxyContainer_WhenProx
 		return nil
 	}
 
-	HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
 
 	s.Equal(expected, actual)
 }
@@ -223,7 +312,141 @@ func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenStartFailure() {
 		return fmt.Errorf("This is an docker start error")
 	}
 
-	err := HaProxy{}.Provision(s.Host, s.ReconfPort, s.CertPath, s.ScAddress)
+	err := HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenWaitingForProxyIsInterrupted() {
+	sleepOrig := util.Sleep
+	defer func() { util.Sleep = sleepOrig }()
+	util.Sleep = func(ctx context.Context, d time.Duration) error {
+		return context.Canceled
+	}
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte(s.ExitedMessage))
+		return nil
+	}
+
+	err := HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Provision_DoesNotInspect_WhenImageIsNotSpecified() {
+	actual := false
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte("Up 3 hours"))
+		return nil
+	}
+	runHaProxyInspectCmd = func(cmd *exec.Cmd) error {
+		actual = true
+		return nil
+	}
+
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress})
+
+	s.False(actual)
+}
+
+func (s HaProxyTestSuite) Test_Provision_InspectsImage_WhenImageIsSpecified() {
+	var actual []string
+	expected := []string{"docker", "inspect", "--format", "{{.Config.Image}}", "docker-flow-proxy"}
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte("Up 3 hours"))
+		return nil
+	}
+	runHaProxyInspectCmd = func(cmd *exec.Cmd) error {
+		actual = cmd.Args
+		cmd.Stdout.Write([]byte("vfarcic/docker-flow-proxy"))
+		return nil
+	}
+
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress, Container: ProxyContainer{Image: "vfarcic/docker-flow-proxy:latest"}})
+
+	s.Equal(expected, actual)
+}
+
+func (s HaProxyTestSuite) Test_Provision_DoesNotUpgrade_WhenImageIsTheSame() {
+	rm := false
+	run := false
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte("Up 3 hours"))
+		return nil
+	}
+	runHaProxyInspectCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte("vfarcic/docker-flow-proxy\n"))
+		return nil
+	}
+	runHaProxyRmCmd = func(cmd *exec.Cmd) error {
+		rm = true
+		return nil
+	}
+	runHaProxyRunCmd = func(cmd *exec.Cmd) error {
+		run = true
+		return nil
+	}
+
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress, Container: ProxyContainer{Image: "vfarcic/docker-flow-proxy:latest"}})
+
+	s.False(rm)
+	s.False(run)
+}
+
+func (s HaProxyTestSuite) Test_Provision_RemovesAndRunsProxy_WhenImageIsDifferent() {
+	var rm []string
+	var run []string
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte(s.ExitedMessage))
+		return nil
+	}
+	runHaProxyInspectCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte("vfarcic/docker-flow-proxy:1.0"))
+		return nil
+	}
+	runHaProxyRmCmd = func(cmd *exec.Cmd) error {
+		rm = cmd.Args
+		return nil
+	}
+	runHaProxyRunCmd = func(cmd *exec.Cmd) error {
+		run = cmd.Args
+		return nil
+	}
+
+	HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress, Container: ProxyContainer{Image: "vfarcic/docker-flow-proxy:2.0"}})
+
+	s.Equal([]string{"docker", "rm", "-f", "docker-flow-proxy"}, rm)
+	s.Equal("vfarcic/docker-flow-proxy:2.0", run[len(run)-1])
+}
+
+func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenInspectFailure() {
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte("Up 3 hours"))
+		return nil
+	}
+	runHaProxyInspectCmd = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("This is an docker inspect error")
+	}
+
+	err := HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress, Container: ProxyContainer{Image: "my-proxy"}})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenRmFailure() {
+	runHaProxyPsCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte("Up 3 hours"))
+		return nil
+	}
+	runHaProxyInspectCmd = func(cmd *exec.Cmd) error {
+		cmd.Stdout.Write([]byte("vfarcic/docker-flow-proxy"))
+		return nil
+	}
+	runHaProxyRmCmd = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("This is an docker rm error")
+	}
+
+	err := HaProxy{}.Provision(ProvisionRequest{DockerHost: s.Host, ReconfPort: s.ReconfPort, DockerCertPath: s.CertPath, ScAddress: s.ScAddress, Container: ProxyContainer{Image: "my-proxy"}})
 
 	s.Error(err)
 }
@@ -231,25 +454,25 @@ func (s HaProxyTestSuite) Test_Provision_ReturnsError_WhenStartFailure() {
 // Reconfigure
 
 func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenProxyHostIsEmpty() {
-	err := HaProxy{}.Reconfigure("", "", "", s.ReconfPort, s.ServiceName, s.Color, s.ServicePath, "", "")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath})
 
 	s.Error(err)
 }
 
 func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenProjectIsEmpty() {
-	err := HaProxy{}.Reconfigure("", "", s.Host, s.ReconfPort, "", s.Color, s.ServicePath, "", "")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Host, ReconfPort: s.ReconfPort, ServiceColor: s.Color, ServicePath: s.ServicePath})
 
 	s.Error(err)
 }
 
 func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenServicePathAndConsulTemplatePathAreEmpty() {
-	err := HaProxy{}.Reconfigure("", "", s.Host, s.ReconfPort, s.ServiceName, s.Color, []string{""}, "", "")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Host, ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: []string{""}})
 
 	s.Error(err)
 }
 
 func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenReconfPortIsEmpty() {
-	err := HaProxy{}.Reconfigure("", "", s.Host, "", s.ServiceName, s.Color, s.ServicePath, "", "")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Host, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath})
 
 	s.Error(err)
 }
@@ -264,14 +487,14 @@ func (s HaProxyTestSuite) Test_Reconfigure_SendsHttpRequest() {
 		s.Color,
 		strings.Join(s.ServicePath, ","),
 	)
-	httpGetOrig := httpGet
-	defer func() { httpGet = httpGetOrig }()
-	httpGet = func(url string) (resp *http.Response, err error) {
-		actual = url
+	httpDoOrig := httpDo
+	defer func() { httpDo = httpDoOrig }()
+	httpDo = func(req *http.Request) (*http.Response, error) {
+		actual = req.URL.String()
 		return nil, fmt.Errorf("This is an HTTP error")
 	}
 
-	HaProxy{}.Reconfigure("", "", s.Host, s.ReconfPort, s.ServiceName, s.Color, s.ServicePath, "", "")
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Host, ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath})
 
 	s.Equal(expected, actual)
 }
@@ -285,14 +508,14 @@ func (s HaProxyTestSuite) Test_Reconfigure_SendsHttpRequestWithOutColor_WhenNotB
 		s.ServiceName,
 		strings.Join(s.ServicePath, ","),
 	)
-	httpGetOrig := httpGet
-	defer func() { httpGet = httpGetOrig }()
-	httpGet = func(url string) (resp *http.Response, err error) {
-		actual = url
+	httpDoOrig := httpDo
+	defer func() { httpDo = httpDoOrig }()
+	httpDo = func(req *http.Request) (*http.Response, error) {
+		actual = req.URL.String()
 		return nil, fmt.Errorf("This is an HTTP error")
 	}
 
-	HaProxy{}.Reconfigure("", "", s.Host, s.ReconfPort, s.ServiceName, "", s.ServicePath, "", "")
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Host, ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServicePath: s.ServicePath})
 
 	s.Equal(expected, actual)
 }
@@ -306,26 +529,26 @@ func (s HaProxyTestSuite) Test_Reconfigure_SendsHttpRequestWithPrependedHttp() {
 		s.ServiceName,
 		strings.Join(s.ServicePath, ","),
 	)
-	httpGetOrig := httpGet
-	defer func() { httpGet = httpGetOrig }()
-	httpGet = func(url string) (resp *http.Response, err error) {
-		actual = url
+	httpDoOrig := httpDo
+	defer func() { httpDo = httpDoOrig }()
+	httpDo = func(req *http.Request) (*http.Response, error) {
+		actual = req.URL.String()
 		return nil, fmt.Errorf("This is an HTTP error")
 	}
 
-	HaProxy{}.Reconfigure("", "", "my-docker-proxy-host.com", s.ReconfPort, s.ServiceName, "", s.ServicePath, "", "")
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: "my-docker-proxy-host.com", ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServicePath: s.ServicePath})
 
 	s.Equal(expected, actual)
 }
 
 func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenRequestFails() {
-	httpGetOrig := httpGet
-	defer func() { httpGet = httpGetOrig }()
-	httpGet = func(url string) (resp *http.Response, err error) {
+	httpDoOrig := httpDo
+	defer func() { httpDo = httpDoOrig }()
+	httpDo = func(req *http.Request) (*http.Response, error) {
 		return nil, fmt.Errorf("This is an HTTP error")
 	}
 
-	err := HaProxy{}.Reconfigure("", "", s.Host, s.ReconfPort, s.ServiceName, s.Color, s.ServicePath, "", "")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Host, ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath})
 
 	s.Error(err)
 }
@@ -335,19 +558,79 @@ func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenResponseCodeIsNot2xx
 		w.WriteHeader(http.StatusBadRequest)
 	}))
 
-	err := HaProxy{}.Reconfigure("", "", server.URL, "", s.ServiceName, s.Color, s.ServicePath, "", "")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath})
 
 	s.Error(err)
 }
 
-func (s HaProxyTestSuite) Test_Reconfigure_SetsDockerHost_WhenConsulTemplatePathIsPresent() {
+func (s HaProxyTestSuite) Test_Reconfigure_RetriesServerErrors() {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	ctx := util.WithRetryPolicy(context.Background(), util.RetryPolicy{Attempts: 3, Delay: time.Millisecond})
+
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Context: ctx, Host: server.URL, ServiceName: s.ServiceName, ServicePath: s.ServicePath})
+
+	s.NoError(err)
+	s.Equal(3, calls)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_DoesNotRetry_WhenResponseCodeIs4xx() {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	ctx := util.WithRetryPolicy(context.Background(), util.RetryPolicy{Attempts: 3, Delay: time.Millisecond})
+
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Context: ctx, Host: server.URL, ServiceName: s.ServiceName, ServicePath: s.ServicePath})
+
+	s.Error(err)
+	s.Equal(1, calls)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenTimeoutIsReached() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServicePath: s.ServicePath, Timeout: 10 * time.Millisecond})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenContextIsCanceled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Context: ctx, Host: s.Server.URL, ServiceName: s.ServiceName, ServicePath: s.ServicePath})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_SetsDockerHostOfCommands_WhenConsulTemplatePathIsPresent() {
+	var actual []string
+	runHaProxyCpCmdOrig := runHaProxyCpCmd
+	defer func() { runHaProxyCpCmd = runHaProxyCpCmdOrig }()
+	runHaProxyCpCmd = func(cmd *exec.Cmd) error {
+		actual = cmd.Env
+		return nil
+	}
 	os.Unsetenv("DOCKER_HOST")
 
-	err := HaProxy{}.Reconfigure(s.DockerHost, s.DockerCertPath, s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, "/path/to/consul/fe/template", "/path/to/consul/be/template")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{DockerHost: s.DockerHost, DockerCertPath: s.DockerCertPath, Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: "/path/to/consul/fe/template", ConsulTemplateBePath: "/path/to/consul/be/template"})
 
 	s.NoError(err)
-	s.Equal(s.DockerHost, os.Getenv("DOCKER_HOST"))
-	s.Equal(s.DockerCertPath, os.Getenv("DOCKER_CERT_PATH"))
+	s.Contains(actual, "DOCKER_HOST="+s.DockerHost)
+	s.Contains(actual, "DOCKER_CERT_PATH="+s.DockerCertPath)
+	s.Empty(os.Getenv("DOCKER_HOST"))
 }
 
 func (s HaProxyTestSuite) Test_Reconfigure_CreatesConsulTemplatesDirectory_WhenConsulTemplatePathIsPresent() {
@@ -358,7 +641,7 @@ func (s HaProxyTestSuite) Test_Reconfigure_CreatesConsulTemplatesDirectory_WhenC
 		return nil
 	}
 
-	HaProxy{}.Reconfigure("", "", s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, "/path/to/consul/fe/template", "/path/to/consul/be/template")
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: "/path/to/consul/fe/template", ConsulTemplateBePath: "/path/to/consul/be/template"})
 
 	s.Equal(expected, actual)
 }
@@ -370,7 +653,7 @@ func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenDirectoryCreationFai
 		return fmt.Errorf("This is an docker exec error")
 	}
 
-	actual := HaProxy{}.Reconfigure("", "", s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, "/path/to/consul/fe/template", "/path/to/consul/be/template")
+	actual := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: "/path/to/consul/fe/template", ConsulTemplateBePath: "/path/to/consul/be/template"})
 
 	s.Error(actual)
 }
@@ -398,12 +681,26 @@ func (s HaProxyTestSuite) Test_Reconfigure_CopiesTemplates_WhenConsulTemplatePat
 		return nil
 	}
 
-	HaProxy{}.Reconfigure("", "", s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, fePath, bePath)
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: fePath, ConsulTemplateBePath: bePath})
 
 	s.Equal(feExpected, actual[0])
 	s.Equal(beExpected, actual[1])
 }
 
+func (s HaProxyTestSuite) Test_Reconfigure_CopiesTemplatesToContainerName_WhenSpecified() {
+	var actual []string
+	runHaProxyCpCmdOrig := runHaProxyCpCmd
+	defer func() { runHaProxyCpCmd = runHaProxyCpCmdOrig }()
+	runHaProxyCpCmd = func(cmd *exec.Cmd) error {
+		actual = cmd.Args
+		return nil
+	}
+
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: "/path/to/consul/fe/template", ConsulTemplateBePath: "/path/to/consul/be/template", ContainerName: "my-proxy"})
+
+	s.Equal(fmt.Sprintf("my-proxy:/consul_templates/%s-be.tmpl", s.ServiceName), actual[3])
+}
+
 func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenTemplateCopyFails() {
 	runHaProxyCpCmdOrig := runHaProxyCpCmd
 	defer func() { runHaProxyCpCmd = runHaProxyCpCmdOrig }()
@@ -411,7 +708,7 @@ func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenTemplateCopyFails()
 		return fmt.Errorf("This is an docker cp error")
 	}
 
-	actual := HaProxy{}.Reconfigure("", "", s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, "/path/to/consul/fe/template", "/path/to/consul/be/template")
+	actual := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: "/path/to/consul/fe/template", ConsulTemplateBePath: "/path/to/consul/be/template"})
 
 	s.Error(actual)
 }
@@ -428,7 +725,7 @@ func (s HaProxyTestSuite) Test_Reconfigure_SendsHttpRequestWithConsulTemplatePat
 		s.ServiceName,
 	)
 
-	HaProxy{}.Reconfigure("", "", server.URL, "", s.ServiceName, s.Color, s.ServicePath, "/path/to/consul/fe/template", "/path/to/consul/be/template")
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: "/path/to/consul/fe/template", ConsulTemplateBePath: "/path/to/consul/be/template"})
 
 	s.Equal(expected, actual)
 }
@@ -453,7 +750,7 @@ func (s HaProxyTestSuite) Test_Reconfigure_CreatesTempTemplateFile() {
 		return []byte(fmt.Sprintf(data, "SERVICE_NAME")), nil
 	}
 
-	HaProxy{}.Reconfigure("", "", s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, fePath, bePath)
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: fePath, ConsulTemplateBePath: bePath})
 
 	s.Equal(fePath+".tmp", actualFilenames[0])
 	s.Equal(bePath+".tmp", actualFilenames[1])
@@ -467,7 +764,7 @@ func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenTemplateFileReadFail
 		return []byte(""), fmt.Errorf("This is an read file error")
 	}
 
-	err := HaProxy{}.Reconfigure("", "", s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, "/path/to/consul/fe/template", "/path/to/consul/be/template")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: "/path/to/consul/fe/template", ConsulTemplateBePath: "/path/to/consul/be/template"})
 
 	s.Error(err)
 }
@@ -479,7 +776,7 @@ func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenTempTemplateFileCrea
 		return fmt.Errorf("This is an write file error")
 	}
 
-	err := HaProxy{}.Reconfigure("", "", s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, "/path/to/consul/fe/template", "/path/to/consul/be/template")
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: "/path/to/consul/fe/template", ConsulTemplateBePath: "/path/to/consul/be/template"})
 
 	s.Error(err)
 }
@@ -499,17 +796,330 @@ func (s HaProxyTestSuite) Test_Reconfigure_RemovesTempTemplateFile() {
 		return nil
 	}
 
-	HaProxy{}.Reconfigure("", "", s.Server.URL, "", s.ServiceName, s.Color, s.ServicePath, fePath, bePath)
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ConsulTemplateFePath: fePath, ConsulTemplateBePath: bePath})
+
+	s.Equal(expected, actual)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_RemovesTempTemplateFile_WhenCopyFails() {
+	fePath := "/path/to/consul/fe/template"
+	var actual []string
+	removeFileOrig := util.RemoveFile
+	defer func() { util.RemoveFile = removeFileOrig }()
+	util.RemoveFile = func(name string) error {
+		actual = append(actual, name)
+		return nil
+	}
+	runHaProxyCpCmdOrig := runHaProxyCpCmd
+	defer func() { runHaProxyCpCmd = runHaProxyCpCmdOrig }()
+	runHaProxyCpCmd = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("This is an error")
+	}
+
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ConsulTemplateFePath: fePath, ConsulTemplateBePath: "/path/to/consul/be/template"})
+
+	s.Equal([]string{fmt.Sprintf("%s.tmp", fePath)}, actual)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_SendsHttpRequestWithServiceSettings() {
+	actual := ""
+	expected := fmt.Sprintf(
+		"%s:%s/v1/docker-flow-proxy/reconfigure?serviceName=%s&serviceColor=%s&servicePath=%s&distribute=&httpsOnly=true&pathType=path_beg&serviceDomain=%s&skipCheck=true",
+		s.Host,
+		s.ReconfPort,
+		s.ServiceName,
+		s.Color,
+		strings.Join(s.ServicePath, ","),
+		"my-domain.com%2Cmy-other-domain.com",
+	)
+	req := ReconfigureRequest{
+		Host:          s.Host,
+		ReconfPort:    s.ReconfPort,
+		ServiceName:   s.ServiceName,
+		ServiceColor:  s.Color,
+		ServicePath:   s.ServicePath,
+		ServiceDomain: []string{"my-domain.com", "my-other-domain.com"},
+		PathType:      ProxyPathTypePrefix,
+		HttpsOnly:     true,
+		Params:        []string{"skipCheck=true", "distribute"},
+	}
+	httpDoOrig := httpDo
+	defer func() { httpDo = httpDoOrig }()
+	httpDo = func(req *http.Request) (*http.Response, error) {
+		actual = req.URL.String()
+		return nil, fmt.Errorf("This is an HTTP error")
+	}
+
+	HaProxy{}.Reconfigure(req)
+
+	s.Equal(expected, actual)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_SendsHttpRequestWithEscapedPath_WhenPathTypeIsRegex() {
+	actual := ""
+	expected := fmt.Sprintf(
+		"%s:%s/v1/docker-flow-proxy/reconfigure?serviceName=%s&servicePath=%s&pathType=path_reg",
+		s.Host,
+		s.ReconfPort,
+		s.ServiceName,
+		"%5E%2Fapi%2Fv%5B0-9%5D%2B",
+	)
+	httpDoOrig := httpDo
+	defer func() { httpDo = httpDoOrig }()
+	httpDo = func(req *http.Request) (*http.Response, error) {
+		actual = req.URL.String()
+		return nil, fmt.Errorf("This is an HTTP error")
+	}
+
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Host, ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServicePath: []string{"^/api/v[0-9]+"}, PathType: ProxyPathTypeRegex})
 
 	s.Equal(expected, actual)
 }
 
+func (s HaProxyTestSuite) Test_Reconfigure_SendsHttpRequestWithEscapedParams() {
+	actual := ""
+	expected := fmt.Sprintf(
+		"%s:%s/v1/docker-flow-proxy/reconfigure?serviceName=%s&servicePath=%s&my+param%%26serviceName=other%%3Dmy+value%%26other",
+		s.Host,
+		s.ReconfPort,
+		s.ServiceName,
+		strings.Join(s.ServicePath, ","),
+	)
+	httpDoOrig := httpDo
+	defer func() { httpDo = httpDoOrig }()
+	httpDo = func(req *http.Request) (*http.Response, error) {
+		actual = req.URL.String()
+		return nil, fmt.Errorf("This is an HTTP error")
+	}
+
+	HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Host, ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServicePath: s.ServicePath, Params: []string{"my param&serviceName=other=my value&other"}})
+
+	s.Equal(expected, actual)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_SendsCert_WhenCertPathIsSpecified() {
+	actualMethod := ""
+	actualUrl := ""
+	actualBody := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/docker-flow-proxy/cert" {
+			actualMethod = r.Method
+			actualUrl = fmt.Sprintf("%s?%s", r.URL.Path, r.URL.RawQuery)
+			body, _ := ioutil.ReadAll(r.Body)
+			actualBody = string(body)
+		}
+	}))
+	defer server.Close()
+	readFileOrig := util.ReadFile
+	defer func() { util.ReadFile = readFileOrig }()
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte("This is a certificate"), nil
+	}
+
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, CertPath: "/path/to/my-domain.pem"})
+
+	s.NoError(err)
+	s.Equal("PUT", actualMethod)
+	s.Equal("/v1/docker-flow-proxy/cert?certName=my-domain.pem", actualUrl)
+	s.Equal("This is a certificate", actualBody)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenCertCannotBeRead() {
+	readFileOrig := util.ReadFile
+	defer func() { util.ReadFile = readFileOrig }()
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(""), fmt.Errorf("This is an read file error")
+	}
+
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: s.Server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, CertPath: "/path/to/my-domain.pem"})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Reconfigure_ReturnsError_WhenCertRequestFails() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Reconfigure(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, CertPath: "/path/to/my-domain.pem"})
+
+	s.Error(err)
+}
+
+// Verify
+
+func (s HaProxyTestSuite) Test_Verify_ReturnsError_WhenHostIsEmpty() {
+	err := HaProxy{}.Verify(ReconfigureRequest{ReconfPort: s.ReconfPort, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, VerifyHeader: "X-Color", VerifyTimeout: time.Second})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Verify_RequestsEachServicePath() {
+	var actual []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual = append(actual, r.URL.Path)
+		w.Header().Set("X-Color", s.Color)
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, VerifyHeader: "X-Color", VerifyTimeout: time.Second})
+
+	s.NoError(err)
+	s.Equal(s.ServicePath, actual)
+}
+
+func (s HaProxyTestSuite) Test_Verify_ReturnsError_WhenStatusCodeIsNotSuccessful() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Color", s.Color)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, VerifyHeader: "X-Color", VerifyTimeout: time.Second})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Verify_ReturnsError_WhenHeaderDoesNotContainColor() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Color", "some-other-color")
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, VerifyHeader: "X-Color", VerifyTimeout: time.Second})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Verify_RetriesUntilResponseComesFromColor() {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 3 {
+			w.Header().Set("X-Color", s.Color)
+		}
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: []string{"/my/path"}, VerifyHeader: "X-Color", VerifyTimeout: 5 * time.Second})
+
+	s.NoError(err)
+	s.Equal(4, requests)
+}
+
+func (s HaProxyTestSuite) Test_Verify_StopsRetrying_WhenTimeoutIsReached() {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: []string{"/my/path"}, VerifyTimeout: 3 * time.Second})
+
+	s.Error(err)
+	s.Equal(4, requests)
+}
+
+func (s HaProxyTestSuite) Test_Verify_RetriesAfterRequestTimeout_WhenVerifyTimeoutIsNotReached() {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if first {
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServicePath: []string{"/my/path"}, Timeout: 100 * time.Millisecond, VerifyTimeout: 3 * time.Second})
+
+	mu.Lock()
+	defer mu.Unlock()
+	s.NoError(err)
+	s.Equal(2, requests)
+}
+
+func (s HaProxyTestSuite) Test_Verify_StopsRetrying_WhenContextIsCanceled() {
+	requests := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Context: ctx, Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: []string{"/my/path"}, VerifyTimeout: 3 * time.Second})
+
+	s.Error(err)
+	s.Equal(1, requests)
+}
+
+func (s HaProxyTestSuite) Test_Verify_ChecksProxyConfig_WhenHeaderIsEmpty() {
+	actual := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/docker-flow-proxy/config" {
+			actual = r.URL.Path
+			fmt.Fprintf(w, "backend %s-%s-be", s.ServiceName, s.Color)
+		}
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, VerifyTimeout: time.Second})
+
+	s.NoError(err)
+	s.Equal("/v1/docker-flow-proxy/config", actual)
+}
+
+func (s HaProxyTestSuite) Test_Verify_ReturnsError_WhenProxyConfigDoesNotContainColor() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "backend %s-some-other-color-be", s.ServiceName)
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, VerifyTimeout: time.Second})
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_Verify_SendsRequestsWithServiceDomain() {
+	actual := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual = r.Host
+		w.Header().Set("X-Color", s.Color)
+	}))
+	defer server.Close()
+
+	HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, ServiceDomain: []string{"my-domain.com", "my-other-domain.com"}, VerifyHeader: "X-Color", VerifyTimeout: time.Second})
+
+	s.Equal("my-domain.com", actual)
+}
+
+func (s HaProxyTestSuite) Test_Verify_ChecksOnlyProxyConfig_WhenHttpsOnly() {
+	var actual []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual = append(actual, r.URL.Path)
+		fmt.Fprintf(w, "backend %s-%s-be", s.ServiceName, s.Color)
+	}))
+	defer server.Close()
+
+	err := HaProxy{}.Verify(ReconfigureRequest{Host: server.URL, ServiceName: s.ServiceName, ServiceColor: s.Color, ServicePath: s.ServicePath, HttpsOnly: true, VerifyHeader: "X-Color", VerifyTimeout: time.Second})
+
+	s.NoError(err)
+	s.Equal([]string{"/v1/docker-flow-proxy/config"}, actual)
+}
+
 // Suite
 
 func TestHaProxyTestSuite(t *testing.T) {
 	logPrintln = func(v ...interface{}) {}
 	logPrintf = func(format string, v ...interface{}) {}
-	util.Sleep = func(d time.Duration) {}
+	util.Sleep = func(ctx context.Context, d time.Duration) error { return nil }
 	dockerHost := os.Getenv("DOCKER_HOST")
 	dockerCertPath := os.Getenv("DOCKER_CERT_PATH")
 	runHaProxyExecCmd = func(cmd *exec.Cmd) error {
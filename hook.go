@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"./util"
+)
+
+const HookBefore = "before_"
+const HookAfter = "after_"
+const HookOnFailure = "on_failure"
+
+type Hook struct {
+	Name          string `yaml:"name"`
+	Command       string `yaml:"command"`
+	Service       string `yaml:"service"`
+	ComposePath   string `yaml:"compose_path"`
+	IgnoreFailure bool   `yaml:"ignore_failure"`
+}
+
+func (h Hook) Run(ctx context.Context, state *RunState) error {
+	env := getStepEnv(state)
+	if len(h.Service) > 0 {
+		composePath := h.ComposePath
+		if len(composePath) == 0 {
+			composePath = state.Opts.ComposePath
+		}
+		return state.Dc.RunService(ctx, state.Opts.Host, state.Opts.CertPath, state.Opts.Project, composePath, h.Service, env)
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Env = append(util.GetDockerEnv(state.Opts.Host, state.Opts.CertPath), env...)
+	cmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
+	if err := runStepCmd(cmd); err != nil {
+		return fmt.Errorf("Shell command: %s\n%s", h.Command, err.Error())
+	}
+	return nil
+}
+
+func (h Hook) getName() string {
+	if len(h.Name) > 0 {
+		return h.Name
+	}
+	if len(h.Service) > 0 {
+		return h.Service
+	}
+	return h.Command
+}
+
+func RunHooks(ctx context.Context, state *RunState, key string) error {
+	ctx = withLogFields(ctx, state, key)
+	for _, hook := range state.Opts.Hooks[key] {
+		logContextPrintln(ctx, fmt.Sprintf("Running the %s hook (%s)...", key, hook.getName()))
+		if err := hook.Run(ctx, state); err != nil {
+			if hook.IgnoreFailure {
+				logContextPrintf(ctx, "The %s hook (%s) failed and is ignored\n%s", key, hook.getName(), err.Error())
+				continue
+			}
+			return fmt.Errorf("The %s hook (%s) failed\n%s", key, hook.getName(), err.Error())
+		}
+	}
+	return nil
+}
+
+func ValidateHooks(hooks map[string][]Hook) error {
+	for key, list := range hooks {
+		if !isHookKeyValid(key) {
+			return fmt.Errorf("hook %s must be %s<step>, %s<step> or %s", key, HookBefore, HookAfter, HookOnFailure)
+		}
+		for _, hook := range list {
+			if (len(hook.Command) == 0) == (len(hook.Service) == 0) {
+				return fmt.Errorf("each %s hook must specify either command or service", key)
+			}
+		}
+	}
+	return nil
+}
+
+func getHookKey(prefix, stepType string) string {
+	return prefix + strings.Replace(strings.ToLower(stepType), "-", "_", -1)
+}
+
+func isHookKeyValid(key string) bool {
+	if key == HookOnFailure {
+		return true
+	}
+	for _, prefix := range []string{HookBefore, HookAfter} {
+		if strings.HasPrefix(key, prefix) {
+			stepType := key[len(prefix):]
+			if _, ok := getStep(stepType); ok {
+				return true
+			}
+			if _, ok := getStep(strings.Replace(stepType, "_", "-", -1)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
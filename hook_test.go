@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"os/exec"
+	"testing"
+)
+
+type HookTestSuite struct {
+	suite.Suite
+	opts Opts
+	dc   *DockerComposeMock
+}
+
+func (s *HookTestSuite) SetupTest() {
+	s.opts = Opts{
+		ComposePath:   "myComposePath",
+		Host:          "myHost",
+		CertPath:      "myCertPath",
+		Project:       "myProject",
+		ServiceName:   "myServiceName",
+		NextColor:     "orange",
+		CurrentColor:  "pink",
+		NextTarget:    "myNextTarget",
+		CurrentTarget: "myCurrentTarget",
+	}
+	s.dc = getDockerComposeMock(s.opts, "")
+	runStepCmd = func(cmd *exec.Cmd) error {
+		return nil
+	}
+	logPrintln = func(v ...interface{}) {}
+	logPrintf = func(format string, v ...interface{}) {}
+}
+
+// Run
+
+func (s HookTestSuite) Test_Run_RunsCommandWithColorsAndTargets() {
+	var actual *exec.Cmd
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = cmd
+		return nil
+	}
+
+	err := Hook{Command: "./migrate.sh"}.Run(context.Background(), NewRunState(s.opts, s.dc))
+
+	s.NoError(err)
+	s.Equal([]string{"sh", "-c", "./migrate.sh"}, actual.Args)
+	s.Contains(actual.Env, "FLOW_CURRENT_COLOR=pink")
+	s.Contains(actual.Env, "FLOW_NEXT_COLOR=orange")
+	s.Contains(actual.Env, "FLOW_CURRENT_TARGET=myCurrentTarget")
+	s.Contains(actual.Env, "FLOW_NEXT_TARGET=myNextTarget")
+}
+
+func (s HookTestSuite) Test_Run_ReturnsError_WhenCommandFails() {
+	runStepCmd = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("This is an error")
+	}
+
+	err := Hook{Command: "./migrate.sh"}.Run(context.Background(), NewRunState(s.opts, s.dc))
+
+	s.Error(err)
+}
+
+func (s HookTestSuite) Test_Run_InvokesDockerComposeRunService_WhenServiceIsSpecified() {
+	state := NewRunState(s.opts, s.dc)
+
+	Hook{Service: "migrations"}.Run(context.Background(), state)
+
+	s.dc.AssertCalled(s.T(), "RunService", mock.Anything, s.opts.Host, s.opts.CertPath, s.opts.Project, s.opts.ComposePath, "migrations", getStepEnv(state))
+}
+
+func (s HookTestSuite) Test_Run_InvokesDockerComposeRunServiceWithComposePath() {
+	Hook{Service: "migrations", ComposePath: "docker-compose-hooks.yml"}.Run(context.Background(), NewRunState(s.opts, s.dc))
+
+	s.dc.AssertCalled(s.T(), "RunService", mock.Anything, mock.Anything, mock.Anything, mock.Anything, "docker-compose-hooks.yml", "migrations", mock.Anything)
+}
+
+// RunHooks
+
+func (s HookTestSuite) Test_RunHooks_RunsAllHooksOfTheKey() {
+	actual := []string{}
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = append(actual, cmd.Args[2])
+		return nil
+	}
+	s.opts.Hooks = map[string][]Hook{
+		"before_deploy": {{Command: "first"}, {Command: "second"}},
+		"after_deploy":  {{Command: "third"}},
+	}
+
+	err := RunHooks(context.Background(), NewRunState(s.opts, s.dc), "before_deploy")
+
+	s.NoError(err)
+	s.Equal([]string{"first", "second"}, actual)
+}
+
+func (s HookTestSuite) Test_RunHooks_ReturnsError_WhenHookFails() {
+	s.opts.Hooks = map[string][]Hook{"before_deploy": {{Service: "migrations"}}}
+	dc := getDockerComposeMock(s.opts, "RunService")
+	dc.On("RunService", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+
+	err := RunHooks(context.Background(), NewRunState(s.opts, dc), "before_deploy")
+
+	s.Error(err)
+}
+
+func (s HookTestSuite) Test_RunHooks_ContinuesWhenFailureIsIgnored() {
+	actual := []string{}
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = append(actual, cmd.Args[2])
+		return fmt.Errorf("This is an error")
+	}
+	s.opts.Hooks = map[string][]Hook{
+		"after_proxy": {{Command: "purge-cdn", IgnoreFailure: true}, {Command: "warm-cache", IgnoreFailure: true}},
+	}
+
+	err := RunHooks(context.Background(), NewRunState(s.opts, s.dc), "after_proxy")
+
+	s.NoError(err)
+	s.Equal([]string{"purge-cdn", "warm-cache"}, actual)
+}
+
+// ValidateHooks
+
+func (s HookTestSuite) Test_ValidateHooks_ReturnsNil() {
+	hooks := map[string][]Hook{
+		"before_deploy":  {{Service: "migrations"}},
+		"after_deploy":   {{Command: "./warm-cache.sh"}},
+		"before_proxy":   {{Command: "./check.sh"}},
+		"after_stop_old": {{Command: "./cleanup.sh"}},
+		"on_failure":     {{Command: "./rollback.sh"}},
+	}
+
+	s.NoError(ValidateHooks(hooks))
+}
+
+func (s HookTestSuite) Test_ValidateHooks_ReturnsError_WhenKeyIsUnknown() {
+	hooks := map[string][]Hook{"during_deploy": {{Command: "./migrate.sh"}}}
+
+	s.Error(ValidateHooks(hooks))
+}
+
+func (s HookTestSuite) Test_ValidateHooks_ReturnsError_WhenStepIsUnknown() {
+	hooks := map[string][]Hook{"before_unknown": {{Command: "./migrate.sh"}}}
+
+	s.Error(ValidateHooks(hooks))
+}
+
+func (s HookTestSuite) Test_ValidateHooks_ReturnsError_WhenCommandAndServiceAreEmpty() {
+	hooks := map[string][]Hook{"before_deploy": {{Name: "migrations"}}}
+
+	s.Error(ValidateHooks(hooks))
+}
+
+func (s HookTestSuite) Test_ValidateHooks_ReturnsError_WhenCommandAndServiceAreSpecified() {
+	hooks := map[string][]Hook{"before_deploy": {{Command: "./migrate.sh", Service: "migrations"}}}
+
+	s.Error(ValidateHooks(hooks))
+}
+
+// Suite
+
+func TestHookTestSuite(t *testing.T) {
+	runStepCmdOrig := runStepCmd
+	defer func() { runStepCmd = runStepCmdOrig }()
+	suite.Run(t, new(HookTestSuite))
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"./util"
+)
+
+const initTemplatesDir = "tmpl"
+
+var initInput io.Reader = os.Stdin
+var initOutput io.Writer = os.Stdout
+
+type InitOpts struct {
+	BlueGreen       bool     `short:"b" long:"blue-green" description:"Perform blue-green deployment."`
+	ComposePath     string   `short:"f" long:"compose-path" value-name:"docker-compose.yml" description:"Path to the Docker Compose configuration file." default:"docker-compose.yml"`
+	ConsulTemplates bool     `long:"consul-templates" description:"Write Consul Template stubs of the proxy configuration and reference them from the Docker Flow file."`
+	Force           bool     `long:"force" description:"Overwrite the Docker Flow file and the Consul Templates if they exist."`
+	NoInput         bool     `short:"y" long:"no-input" description:"Do not ask questions. Values that are not specified as arguments are set to their defaults."`
+	Output          string   `short:"o" long:"output" description:"Path of the Docker Flow file." default:"docker-flow.yml"`
+	Project         string   `short:"p" long:"project" description:"Docker Compose project. If not specified, the current directory will be used instead."`
+	ServicePath     []string `long:"service-path" description:"Path that should be configured in the proxy (e.g. /api/v1/my-service). Multiple values are allowed."`
+	SideTargets     []string `short:"T" long:"side-target" description:"Side or auxiliary Docker Compose targets. Multiple values are allowed."`
+	Target          string   `short:"t" long:"target" description:"Docker Compose target."`
+}
+
+type composeService struct {
+	Name  string
+	Ports []string
+}
+
+func RunInitCommand() error {
+	opts := InitOpts{}
+	if _, err := flags.NewParser(&opts, flags.Default).ParseArgs(os.Args[2:]); err != nil {
+		return fmt.Errorf("Could not parse command line arguments\n%s", err.Error())
+	}
+	return Init(opts)
+}
+
+// Init writes a Docker Flow file for the services of the Docker Compose file.
+// Values that are not specified are asked for unless NoInput is set.
+func Init(opts InitOpts) error {
+	if _, err := util.ReadFile(opts.Output); err == nil && !opts.Force {
+		return fmt.Errorf("%s already exists. Use --force to overwrite it", opts.Output)
+	}
+	data, err := util.ReadFile(opts.ComposePath)
+	if err != nil {
+		return fmt.Errorf("Could not read the Docker Compose file %s\n%s", opts.ComposePath, err.Error())
+	}
+	services, err := getComposeServices(data)
+	if err != nil {
+		return fmt.Errorf("Could not parse the Docker Compose file %s\n%s", opts.ComposePath, err.Error())
+	}
+	if len(services) == 0 {
+		return fmt.Errorf("The Docker Compose file %s does not define any service", opts.ComposePath)
+	}
+	fmt.Fprintf(initOutput, "Services in %s:\n", opts.ComposePath)
+	for _, service := range services {
+		if len(service.Ports) > 0 {
+			fmt.Fprintf(initOutput, "  %s (ports: %s)\n", service.Name, strings.Join(service.Ports, ", "))
+		} else {
+			fmt.Fprintf(initOutput, "  %s\n", service.Name)
+		}
+	}
+	prompt := initPrompt{reader: bufio.NewReader(initInput), noInput: opts.NoInput}
+	if len(opts.Target) == 0 {
+		opts.Target = prompt.ask("Target", getDefaultInitTarget(services))
+	}
+	target, ok := findComposeService(services, opts.Target)
+	if !ok {
+		return fmt.Errorf("The service %s is not defined in %s", opts.Target, opts.ComposePath)
+	}
+	if len(opts.SideTargets) == 0 {
+		sideTargets := []string{}
+		for _, service := range services {
+			if service.Name != target.Name {
+				sideTargets = append(sideTargets, service.Name)
+			}
+		}
+		opts.SideTargets = prompt.askList("Side targets", sideTargets)
+	}
+	for _, sideTarget := range opts.SideTargets {
+		if _, ok := findComposeService(services, sideTarget); !ok {
+			return fmt.Errorf("The service %s is not defined in %s", sideTarget, opts.ComposePath)
+		}
+	}
+	if !opts.BlueGreen {
+		opts.BlueGreen = prompt.askBool("Blue-green deployment", false)
+	}
+	if len(opts.ServicePath) == 0 {
+		opts.ServicePath = prompt.askList("Service paths", []string{"/" + target.Name})
+	}
+	if len(opts.Project) == 0 {
+		dir, _ := getWd()
+		opts.Project = filepath.Base(dir)
+	}
+	if len(target.Ports) == 0 {
+		fmt.Fprintf(initOutput, "WARNING: The service %s does not expose any port so the proxy will not be able to reach it\n", target.Name)
+	}
+	if opts.ConsulTemplates {
+		if err := writeInitTemplates(opts); err != nil {
+			return err
+		}
+	}
+	if err := util.WriteFile(opts.Output, []byte(getInitConfig(opts, target)), 0644); err != nil {
+		return fmt.Errorf("Could not write %s\n%s", opts.Output, err.Error())
+	}
+	fmt.Fprintf(initOutput, "%s was created\n", opts.Output)
+	return nil
+}
+
+func getComposeServices(data []byte) ([]composeService, error) {
+	config := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	definitions := config
+	for _, item := range config {
+		if item.Key == "services" {
+			// Docker Compose files with a version define services under their own key
+			definitions, _ = item.Value.(yaml.MapSlice)
+		}
+	}
+	services := []composeService{}
+	for _, item := range definitions {
+		name, _ := item.Key.(string)
+		definition, ok := item.Value.(yaml.MapSlice)
+		if !ok || name == "version" || name == "networks" || name == "volumes" {
+			continue
+		}
+		service := composeService{Name: name, Ports: []string{}}
+		for _, field := range definition {
+			if field.Key == "ports" || field.Key == "expose" {
+				service.Ports = append(service.Ports, getComposePorts(field.Value)...)
+			}
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// getComposePorts returns the container ports of the short (e.g. 80:8080) and long (target: 8080) port syntax.
+func getComposePorts(value interface{}) []string {
+	ports := []string{}
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		switch v := item.(type) {
+		case yaml.MapSlice:
+			for _, field := range v {
+				if field.Key == "target" {
+					ports = append(ports, fmt.Sprint(field.Value))
+				}
+			}
+		default:
+			port := fmt.Sprint(v)
+			if i := strings.LastIndex(port, ":"); i >= 0 {
+				port = port[i+1:]
+			}
+			ports = append(ports, strings.Split(port, "/")[0])
+		}
+	}
+	return ports
+}
+
+func getDefaultInitTarget(services []composeService) string {
+	for _, service := range services {
+		if len(service.Ports) > 0 {
+			return service.Name
+		}
+	}
+	return services[0].Name
+}
+
+func findComposeService(services []composeService, name string) (composeService, bool) {
+	for _, service := range services {
+		if service.Name == name {
+			return service, true
+		}
+	}
+	return composeService{}, false
+}
+
+func getInitServiceName(opts InitOpts) string {
+	return fmt.Sprintf("%s-%s", opts.Project, opts.Target)
+}
+
+func getInitTemplatePaths(opts InitOpts) (string, string) {
+	serviceName := getInitServiceName(opts)
+	return filepath.Join(initTemplatesDir, serviceName+"-fe.tmpl"), filepath.Join(initTemplatesDir, serviceName+"-be.tmpl")
+}
+
+func writeInitTemplates(opts InitOpts) error {
+	serviceName := getInitServiceName(opts)
+	fePath, bePath := getInitTemplatePaths(opts)
+	fe := ""
+	for i, path := range opts.ServicePath {
+		acl := fmt.Sprintf("url_%s_%d", serviceName, i)
+		fe += fmt.Sprintf("\tacl %s path_beg %s\n\tuse_backend %s-be if %s\n", acl, path, serviceName, acl)
+	}
+	be := fmt.Sprintf(`backend %s-be
+	{{ range $i, $e := service "SERVICE_NAME" "any" }}
+	server {{$e.Node}}_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}} check
+	{{end}}
+`, serviceName)
+	templates := []struct {
+		path string
+		data string
+	}{{fePath, fe}, {bePath, be}}
+	for _, template := range templates {
+		path, data := template.path, template.data
+		if _, err := util.ReadFile(path); err == nil && !opts.Force {
+			return fmt.Errorf("%s already exists. Use --force to overwrite it", path)
+		}
+		if err := util.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("Could not create the directory %s\n%s", filepath.Dir(path), err.Error())
+		}
+		if err := util.WriteFile(path, []byte(data), 0644); err != nil {
+			return fmt.Errorf("Could not write %s\n%s", path, err.Error())
+		}
+		fmt.Fprintf(initOutput, "%s was created\n", path)
+	}
+	return nil
+}
+
+func getInitConfig(opts InitOpts, target composeService) string {
+	ports := "does not expose any port"
+	if len(target.Ports) > 0 {
+		ports = fmt.Sprintf("exposes the port(s) %s", strings.Join(target.Ports, ", "))
+	}
+	flow := []string{FLOW_DEPLOY, FLOW_PROXY}
+	if opts.BlueGreen {
+		flow = append(flow, FLOW_STOP_OLD)
+	}
+	type initSection struct {
+		comment string
+		key     string
+		value   interface{}
+	}
+	sections := []initSection{}
+	if filepath.Clean(opts.ComposePath) != dockerComposePath {
+		sections = append(sections, initSection{"Docker Compose file the services are defined in.", "compose_path", opts.ComposePath})
+	}
+	sections = append(
+		sections,
+		initSection{fmt.Sprintf("Docker Compose service deployed by the flow. It %s.", ports), "target", opts.Target},
+		initSection{"Docker Compose services deployed together with the target.", "side_targets", opts.SideTargets},
+		initSection{"Deploy new releases next to the old ones and stop the old ones once the proxy is reconfigured.", "blue_green", opts.BlueGreen},
+		initSection{"Paths the proxy forwards to the service.", "service_path", opts.ServicePath},
+		initSection{"Steps performed when no flow is specified as an argument.", "flow", flow},
+	)
+	lines := []string{fmt.Sprintf("# Docker Flow configuration generated from %s.", opts.ComposePath)}
+	for _, section := range sections {
+		data, _ := yaml.Marshal(map[string]interface{}{section.key: section.value})
+		lines = append(lines, "", "# "+section.comment, strings.TrimRight(string(data), "\n"))
+	}
+	if opts.ConsulTemplates {
+		fePath, bePath := getInitTemplatePaths(opts)
+		data, _ := yaml.Marshal(map[string]string{"consul_template_fe_path": fePath, "consul_template_be_path": bePath})
+		lines = append(lines, "", "# Consul Templates used to configure the proxy instead of the service paths.", strings.TrimRight(string(data), "\n"))
+	}
+	lines = append(
+		lines,
+		"",
+		"# Address of the Consul server. It can also be specified with --consul-address or FLOW_CONSUL_ADDRESS.",
+		"# consul_address: http://consul.example.com:8500",
+		"",
+		"# Host of the proxy. It can also be specified with --proxy-host or FLOW_PROXY_HOST.",
+		"# proxy_host: proxy.example.com",
+	)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+type initPrompt struct {
+	reader  *bufio.Reader
+	noInput bool
+}
+
+func (p initPrompt) ask(question, defaultValue string) string {
+	if p.noInput {
+		return defaultValue
+	}
+	fmt.Fprintf(initOutput, "%s [%s]: ", question, defaultValue)
+	answer, _ := p.reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if len(answer) == 0 {
+		return defaultValue
+	}
+	return answer
+}
+
+func (p initPrompt) askList(question string, defaultValue []string) []string {
+	answer := p.ask(question+" (comma separated, - for none)", strings.Join(defaultValue, ","))
+	values := []string{}
+	if answer == "-" {
+		return values
+	}
+	for _, value := range strings.Split(answer, ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (p initPrompt) askBool(question string, defaultValue bool) bool {
+	options := "y/N"
+	if defaultValue {
+		options = "Y/n"
+	}
+	answer := strings.ToLower(p.ask(question+" ("+options+")", ""))
+	if len(answer) == 0 {
+		return defaultValue
+	}
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v2"
+	"os"
+	"strings"
+	"testing"
+	"./util"
+)
+
+type InitTestSuite struct {
+	suite.Suite
+	opts      InitOpts
+	files     map[string]string
+	written   map[string]string
+	output    *bytes.Buffer
+	readFile  func(string) ([]byte, error)
+	writeFile func(string, []byte, os.FileMode) error
+	mkdirAll  func(string, os.FileMode) error
+	args      []string
+}
+
+func (s *InitTestSuite) SetupTest() {
+	s.opts = InitOpts{
+		ComposePath: "docker-compose.yml",
+		Output:      "docker-flow.yml",
+		Project:     "go-demo",
+		NoInput:     true,
+	}
+	s.files = map[string]string{
+		"docker-compose.yml": `version: '2'
+
+services:
+
+  app:
+    image: vfarcic/go-demo
+    ports:
+      - 8080
+
+  db:
+    image: mongo
+`,
+	}
+	s.written = map[string]string{}
+	s.output = new(bytes.Buffer)
+	s.readFile = util.ReadFile
+	s.writeFile = util.WriteFile
+	s.mkdirAll = util.MkdirAll
+	s.args = os.Args
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		if data, ok := s.files[fileName]; ok {
+			return []byte(data), nil
+		}
+		return nil, fmt.Errorf("%s does not exist", fileName)
+	}
+	util.WriteFile = func(fileName string, data []byte, perm os.FileMode) error {
+		s.written[fileName] = string(data)
+		return nil
+	}
+	util.MkdirAll = func(path string, perm os.FileMode) error {
+		return nil
+	}
+	initInput = strings.NewReader("")
+	initOutput = s.output
+}
+
+func (s *InitTestSuite) TearDownTest() {
+	util.ReadFile = s.readFile
+	util.WriteFile = s.writeFile
+	util.MkdirAll = s.mkdirAll
+	os.Args = s.args
+	initInput = os.Stdin
+	initOutput = os.Stdout
+}
+
+// Init
+
+func (s InitTestSuite) Test_Init_WritesDockerFlowFile() {
+	err := Init(s.opts)
+
+	s.NoError(err)
+	opts := Opts{}
+	s.NoError(yaml.Unmarshal([]byte(s.written["docker-flow.yml"]), &opts))
+	s.Equal("app", opts.Target)
+	s.Equal([]string{"db"}, opts.SideTargets)
+	s.False(opts.BlueGreen)
+	s.Equal([]string{"/app"}, opts.ServicePath)
+	s.Equal([]string{"deploy", "proxy"}, opts.Flow)
+}
+
+func (s InitTestSuite) Test_Init_WritesComposePath_WhenItIsNotDefault() {
+	s.files["other-compose.yml"] = s.files["docker-compose.yml"]
+	s.opts.ComposePath = "other-compose.yml"
+
+	err := Init(s.opts)
+
+	s.NoError(err)
+	opts := Opts{}
+	s.NoError(yaml.Unmarshal([]byte(s.written["docker-flow.yml"]), &opts))
+	s.Equal("other-compose.yml", opts.ComposePath)
+}
+
+func (s InitTestSuite) Test_Init_DoesNotWriteComposePath_WhenItIsDefault() {
+	Init(s.opts)
+
+	s.NotContains(s.written["docker-flow.yml"], "compose_path")
+}
+
+func (s InitTestSuite) Test_Init_WritesComments() {
+	Init(s.opts)
+
+	s.Contains(s.written["docker-flow.yml"], "# Docker Compose service deployed by the flow. It exposes the port(s) 8080.")
+	s.Contains(s.written["docker-flow.yml"], "# consul_address:")
+}
+
+func (s InitTestSuite) Test_Init_UsesSpecifiedValues() {
+	s.opts.Target = "app"
+	s.opts.SideTargets = []string{"db"}
+	s.opts.BlueGreen = true
+	s.opts.ServicePath = []string{"/demo"}
+
+	Init(s.opts)
+
+	opts := Opts{}
+	yaml.Unmarshal([]byte(s.written["docker-flow.yml"]), &opts)
+	s.True(opts.BlueGreen)
+	s.Equal([]string{"/demo"}, opts.ServicePath)
+	s.Equal([]string{"deploy", "proxy", "stop-old"}, opts.Flow)
+}
+
+func (s InitTestSuite) Test_Init_AsksForValues() {
+	s.opts.NoInput = false
+	initInput = strings.NewReader("db\n-\ny\n/db,/mongo\n")
+
+	Init(s.opts)
+
+	opts := Opts{}
+	yaml.Unmarshal([]byte(s.written["docker-flow.yml"]), &opts)
+	s.Equal("db", opts.Target)
+	s.Empty(opts.SideTargets)
+	s.True(opts.BlueGreen)
+	s.Equal([]string{"/db", "/mongo"}, opts.ServicePath)
+}
+
+func (s InitTestSuite) Test_Init_UsesDefaults_WhenAnswersAreEmpty() {
+	s.opts.NoInput = false
+	initInput = strings.NewReader("\n\n\n\n")
+
+	Init(s.opts)
+
+	opts := Opts{}
+	yaml.Unmarshal([]byte(s.written["docker-flow.yml"]), &opts)
+	s.Equal("app", opts.Target)
+	s.Equal([]string{"db"}, opts.SideTargets)
+	s.False(opts.BlueGreen)
+	s.Equal([]string{"/app"}, opts.ServicePath)
+}
+
+func (s InitTestSuite) Test_Init_ListsServicesAndPorts() {
+	Init(s.opts)
+
+	s.Contains(s.output.String(), "app (ports: 8080)")
+	s.Contains(s.output.String(), "  db\n")
+}
+
+func (s InitTestSuite) Test_Init_WarnsWhenTargetDoesNotExposePorts() {
+	s.opts.Target = "db"
+
+	Init(s.opts)
+
+	s.Contains(s.output.String(), "WARNING: The service db does not expose any port")
+}
+
+func (s InitTestSuite) Test_Init_ReturnsError_WhenTargetIsNotDefined() {
+	s.opts.Target = "unknown"
+
+	err := Init(s.opts)
+
+	s.Error(err)
+	s.Empty(s.written)
+}
+
+func (s InitTestSuite) Test_Init_ReturnsError_WhenSideTargetIsNotDefined() {
+	s.opts.SideTargets = []string{"unknown"}
+
+	err := Init(s.opts)
+
+	s.Error(err)
+}
+
+func (s InitTestSuite) Test_Init_ReturnsError_WhenComposeFileCannotBeRead() {
+	s.opts.ComposePath = "unknown.yml"
+
+	err := Init(s.opts)
+
+	s.Error(err)
+}
+
+func (s InitTestSuite) Test_Init_ReturnsError_WhenOutputExists() {
+	s.files["docker-flow.yml"] = "target: app"
+
+	err := Init(s.opts)
+
+	s.Error(err)
+	s.Empty(s.written)
+}
+
+func (s InitTestSuite) Test_Init_OverwritesOutput_WhenForce() {
+	s.files["docker-flow.yml"] = "target: app"
+	s.opts.Force = true
+
+	err := Init(s.opts)
+
+	s.NoError(err)
+	s.Contains(s.written, "docker-flow.yml")
+}
+
+func (s InitTestSuite) Test_Init_WritesConsulTemplates() {
+	s.opts.ConsulTemplates = true
+	s.opts.ServicePath = []string{"/demo"}
+
+	Init(s.opts)
+
+	s.Equal(`backend go-demo-app-be
+	{{ range $i, $e := service "SERVICE_NAME" "any" }}
+	server {{$e.Node}}_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}} check
+	{{end}}
+`, s.written["tmpl/go-demo-app-be.tmpl"])
+	s.Equal(`	acl url_go-demo-app_0 path_beg /demo
+	use_backend go-demo-app-be if url_go-demo-app_0
+`, s.written["tmpl/go-demo-app-fe.tmpl"])
+	opts := Opts{}
+	yaml.Unmarshal([]byte(s.written["docker-flow.yml"]), &opts)
+	s.Equal("tmpl/go-demo-app-fe.tmpl", opts.ConsulTemplateFePath)
+	s.Equal("tmpl/go-demo-app-be.tmpl", opts.ConsulTemplateBePath)
+}
+
+// getComposeServices
+
+func (s InitTestSuite) Test_GetComposeServices_ReturnsServicesOfVersion1() {
+	actual, err := getComposeServices([]byte(`
+app:
+  image: vfarcic/go-demo
+  expose:
+    - "8080"
+db:
+  image: mongo
+`))
+
+	s.NoError(err)
+	s.Equal([]composeService{{Name: "app", Ports: []string{"8080"}}, {Name: "db", Ports: []string{}}}, actual)
+}
+
+func (s InitTestSuite) Test_GetComposeServices_ReturnsContainerPorts() {
+	actual, _ := getComposeServices([]byte(`
+version: '3'
+services:
+  app:
+    ports:
+      - 8080
+      - "80:8081"
+      - "127.0.0.1:443:8082/tcp"
+      - target: 8083
+        published: 8000
+`))
+
+	s.Equal([]string{"8080", "8081", "8082", "8083"}, actual[0].Ports)
+}
+
+// RunInitCommand
+
+func (s InitTestSuite) Test_RunInitCommand_ParsesArgs() {
+	os.Args = []string{"myProgram", "init", "--target", "db", "--no-input", "--project", "go-demo"}
+
+	err := RunInitCommand()
+
+	s.NoError(err)
+	s.Contains(s.written["docker-flow.yml"], "target: db")
+}
+
+func TestInitTestSuite(t *testing.T) {
+	suite.Run(t, new(InitTestSuite))
+}
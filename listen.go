@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const ListenSourceDockerHub = "dockerhub"
+const ListenSourceRegistry = "registry"
+const ListenSourceGit = "git"
+const ListenDefaultTagVariable = "TAG"
+const listenSignatureHeader = "X-Hub-Signature-256"
+
+type ListenOpts struct {
+	Address              string `short:"a" long:"address" description:"Address the webhooks are received on." default:":8080" env:"FLOW_LISTEN_ADDRESS"`
+	AllowUnauthenticated bool   `long:"allow-unauthenticated" description:"Allows receiving webhooks without a secret or a token on an address that is not a loopback one. Anyone who can reach the address can then deploy any allowed tag." env:"FLOW_LISTEN_ALLOW_UNAUTHENTICATED"`
+	Config               string `long:"config" description:"Path to the Docker Flow file with the services and the repositories they are deployed from. If not specified, docker-flow.yml from the current directory is used when it exists."`
+	History              int    `long:"history" description:"Number of finished flows kept in memory. If not specified, the last 100 flows are kept."`
+	Profile              string `long:"profile" description:"Name of the profile from the Docker Flow file merged over its base configuration."`
+	Secret               string `long:"secret" description:"Secret the webhooks are signed with. Requests must send the HMAC-SHA256 signature of the body in the X-Hub-Signature-256 header (sha256=<hex>). Docker Hub does not sign its webhooks so they can only be received with a token." env:"FLOW_LISTEN_SECRET"`
+	Token                string `long:"token" description:"Token that requests must send in the Authorization header (Bearer <token>). Webhooks, which are checked with it only when there is no secret, can send it in the token query parameter instead since Docker Hub cannot set headers." env:"FLOW_LISTEN_TOKEN"`
+}
+
+// Listener runs the flows of the services whose repository received a new tag through the queue of a server.
+type Listener struct {
+	Opts   ListenOpts
+	server *Server
+}
+
+// queuedFlow is a flow of a webhook that is validated and waits to be started.
+type queuedFlow struct {
+	flow *ServerFlow
+	opts Opts
+	env  []string
+}
+
+// imagePush is a tag pushed to a repository. The repository can be configured under any of its names.
+type imagePush struct {
+	Repositories []string
+	Tag          string
+}
+
+type dockerHubPayload struct {
+	PushData struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+type registryPayload struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+type gitPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+func RunListenCommand() error {
+	opts := ListenOpts{}
+	if _, err := flags.NewParser(&opts, flags.Default).ParseArgs(os.Args[2:]); err != nil {
+		return fmt.Errorf("Could not parse command line arguments\n%s", err.Error())
+	}
+	if err := ValidateListenerOpts(opts); err != nil {
+		return err
+	}
+	flowOpts, err := loadServerOpts()
+	if err != nil {
+		return err
+	}
+	if err := ValidateListenOpts(flowOpts); err != nil {
+		return err
+	}
+	if len(opts.Secret) == 0 && len(opts.Token) == 0 {
+		logPrintln("No secret or token was specified. Webhooks are not authenticated.")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, stop := handleSignals(cancel)
+	defer stop()
+	return NewListener(ctx, opts).ListenAndServe()
+}
+
+// ValidateListenOpts checks that there is a service to deploy when a repository receives a new tag.
+func ValidateListenOpts(opts Opts) error {
+	for _, service := range opts.Services {
+		if len(service.Repository) > 0 {
+			return ValidateServices(opts.Services)
+		}
+	}
+	return fmt.Errorf("none of the services specifies the repository it is deployed from")
+}
+
+// ValidateListenerOpts checks that webhooks cannot deploy services without a secret or a token from other hosts.
+func ValidateListenerOpts(opts ListenOpts) error {
+	if len(opts.Secret) == 0 && len(opts.Token) == 0 && !opts.AllowUnauthenticated && !isLoopbackAddress(opts.Address) {
+		return fmt.Errorf("secret or token is required unless the address is a loopback address (e.g. 127.0.0.1:8080) or --allow-unauthenticated is set")
+	}
+	return nil
+}
+
+func NewListener(ctx context.Context, opts ListenOpts) *Listener {
+	return &Listener{
+		Opts:   opts,
+		server: NewServer(ctx, ServerOpts{Address: opts.Address, History: opts.History, Token: opts.Token}),
+	}
+}
+
+func (l *Listener) ListenAndServe() error {
+	return l.server.listenAndServe(l)
+}
+
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := strings.TrimRight(r.URL.Path, "/")
+	switch {
+	case strings.HasPrefix(route, "/v1/hooks/") && r.Method == "POST":
+		l.receive(w, r, strings.TrimPrefix(route, "/v1/hooks/"))
+	case strings.HasPrefix(route, "/v1/flows/"):
+		l.server.ServeHTTP(w, r)
+	case strings.HasPrefix(route, "/v1/hooks/"):
+		writeServerError(w, http.StatusMethodNotAllowed, fmt.Errorf("The method %s is not allowed", r.Method))
+	default:
+		writeServerError(w, http.StatusNotFound, fmt.Errorf("%s was not found", r.URL.Path))
+	}
+}
+
+// receive queues the flow of each service whose repository and allowed tags match the pushed tag.
+// The tag is passed to the flow as the tag variable of the service so that Docker Compose can use it.
+func (l *Listener) receive(w http.ResponseWriter, r *http.Request, source string) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, serverMaxBodySize))
+	if err != nil {
+		writeServerError(w, http.StatusBadRequest, fmt.Errorf("Could not read the request\n%s", err.Error()))
+		return
+	}
+	if !l.isAuthenticated(r, body) {
+		writeServerError(w, http.StatusUnauthorized, fmt.Errorf("The signature or the token is missing or invalid"))
+		return
+	}
+	var pushes []imagePush
+	switch source {
+	case ListenSourceDockerHub:
+		pushes, err = getDockerHubPushes(body)
+	case ListenSourceRegistry:
+		pushes, err = getRegistryPushes(body)
+	case ListenSourceGit:
+		pushes, err = getGitPushes(body)
+	default:
+		writeServerError(w, http.StatusNotFound, fmt.Errorf("%s webhooks are not supported", source))
+		return
+	}
+	if err != nil {
+		writeServerError(w, http.StatusBadRequest, fmt.Errorf("Could not parse the %s webhook\n%s", source, err.Error()))
+		return
+	}
+	opts, err := loadServerOpts()
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// All flows are validated before any of them is queued so that a webhook either deploys all its services or none
+	queued := []queuedFlow{}
+	for _, push := range pushes {
+		for _, service := range opts.Services {
+			if !service.isDeployedFrom(push.Repositories) {
+				continue
+			}
+			if !service.isTagAllowed(push.Tag) {
+				logPrintf("The tag %s of %s is not allowed for the service %s", push.Tag, service.Repository, service.GetName())
+				continue
+			}
+			flowOpts := getListenFlowOpts(opts, service)
+			flow, err := l.server.newFlow(flowOpts)
+			if err != nil {
+				writeServerError(w, http.StatusInternalServerError, err)
+				return
+			}
+			queued = append(queued, queuedFlow{flow: flow, opts: flowOpts, env: []string{service.getTagVariable() + "=" + push.Tag}})
+		}
+	}
+	flows := []ServerFlow{}
+	for _, q := range queued {
+		l.server.startFlow(q.flow, q.opts, q.env)
+		flows = append(flows, l.server.getFlowCopy(q.flow))
+	}
+	status := http.StatusAccepted
+	if len(flows) == 0 {
+		status = http.StatusOK
+	}
+	writeServerJson(w, status, map[string][]ServerFlow{"flows": flows})
+}
+
+// getListenFlowOpts returns the options that run the flow of the service alone.
+func getListenFlowOpts(opts Opts, service Service) Opts {
+	service.DependsOn = nil
+	opts.Services = []Service{service}
+	return opts
+}
+
+func (s Service) isDeployedFrom(repositories []string) bool {
+	if len(s.Repository) == 0 {
+		return false
+	}
+	for _, repository := range repositories {
+		if strings.EqualFold(s.Repository, repository) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Service) isTagAllowed(tag string) bool {
+	for _, pattern := range s.Tags {
+		if matched, _ := path.Match(pattern, tag); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Service) getTagVariable() string {
+	if len(s.TagVariable) > 0 {
+		return s.TagVariable
+	}
+	return ListenDefaultTagVariable
+}
+
+// isAuthenticated checks the signature of the webhook when there is a secret and its token otherwise.
+// Docker Hub cannot sign webhooks or set headers so the token can also be sent as the token query parameter.
+func (l *Listener) isAuthenticated(r *http.Request, body []byte) bool {
+	if len(l.Opts.Secret) > 0 {
+		return isValidListenSignature(l.Opts.Secret, body, r.Header.Get(listenSignatureHeader))
+	}
+	if len(l.Opts.Token) > 0 {
+		token := r.URL.Query().Get("token")
+		if len(token) == 0 {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		return subtle.ConstantTimeCompare([]byte(token), []byte(l.Opts.Token)) == 1
+	}
+	return true
+}
+
+func isValidListenSignature(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func getDockerHubPushes(body []byte) ([]imagePush, error) {
+	payload := dockerHubPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if len(payload.Repository.RepoName) == 0 || len(payload.PushData.Tag) == 0 {
+		return nil, fmt.Errorf("The repository and the tag are required")
+	}
+	return []imagePush{{Repositories: []string{payload.Repository.RepoName}, Tag: payload.PushData.Tag}}, nil
+}
+
+// getRegistryPushes returns the tags from the notifications of a Docker registry.
+// Pushes of layers are ignored since they have no tag.
+func getRegistryPushes(body []byte) ([]imagePush, error) {
+	payload := registryPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	pushes := []imagePush{}
+	for _, event := range payload.Events {
+		if event.Action != "push" || len(event.Target.Tag) == 0 {
+			continue
+		}
+		repositories := []string{event.Target.Repository}
+		if len(event.Request.Host) > 0 {
+			repositories = append(repositories, event.Request.Host+"/"+event.Target.Repository)
+		}
+		pushes = append(pushes, imagePush{Repositories: repositories, Tag: event.Target.Tag})
+	}
+	return pushes, nil
+}
+
+// getGitPushes returns the branch or tag of a GitHub, Gitea or GitLab push as the tag.
+// Deleted references and other events, like pings, are ignored.
+func getGitPushes(body []byte) ([]imagePush, error) {
+	payload := gitPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if len(payload.Ref) == 0 || payload.Deleted || (len(payload.After) > 0 && strings.Trim(payload.After, "0") == "") {
+		return []imagePush{}, nil
+	}
+	repositories := []string{}
+	for _, name := range []string{payload.Repository.FullName, payload.Project.PathWithNamespace} {
+		if len(name) > 0 {
+			repositories = append(repositories, name)
+		}
+	}
+	tag := strings.TrimPrefix(strings.TrimPrefix(payload.Ref, "refs/heads/"), "refs/tags/")
+	return []imagePush{{Repositories: repositories, Tag: tag}}, nil
+}
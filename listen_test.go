@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type ListenTestSuite struct {
+	suite.Suite
+	opts     *Opts
+	listener *Listener
+	runs     *listenRuns
+}
+
+type listenRuns struct {
+	opts []Opts
+	env  [][]string
+	ids  int
+	mu   sync.Mutex
+}
+
+func (s *ListenTestSuite) SetupTest() {
+	s.opts = &Opts{
+		Project:                 "myProject",
+		ServiceDiscoveryAddress: "http://consul",
+		Services: []Service{
+			{Target: "db"},
+			{Target: "api", Repository: "vfarcic/go-demo", Tags: []string{"1.*", "latest"}, DependsOn: []string{"db"}},
+			{Target: "ui", Repository: "registry.acme.com:5000/ui", Tags: []string{"*"}, TagVariable: "UI_TAG"},
+			{Target: "docs", Repository: "acme/docs", Tags: []string{"master", "v*"}},
+		},
+	}
+	loadServerOpts = func() (Opts, error) {
+		return *s.opts, nil
+	}
+	processOpts = func(opts *Opts) error {
+		return ValidateServices(opts.Services)
+	}
+	runs := &listenRuns{}
+	s.runs = runs
+	getFlowRunId = func() string {
+		runs.mu.Lock()
+		defer runs.mu.Unlock()
+		runs.ids++
+		return fmt.Sprintf("flow-%d", runs.ids)
+	}
+	runServerFlow = func(ctx context.Context, opts Opts, env []string, output *serverFlowLog) (*Report, error) {
+		runs.mu.Lock()
+		defer runs.mu.Unlock()
+		runs.opts = append(runs.opts, opts)
+		runs.env = append(runs.env, env)
+		return &Report{Status: ReportStatusSuccess}, nil
+	}
+	s.listener = NewListener(context.Background(), ListenOpts{})
+	logPrintln = func(v ...interface{}) {}
+	logPrintf = func(format string, v ...interface{}) {}
+}
+
+func (s *ListenTestSuite) TearDownTest() {
+	s.listener.server.wg.Wait()
+}
+
+// Docker Hub
+
+func (s ListenTestSuite) Test_Receive_DeploysServiceOfDockerHubRepository() {
+	resp := s.request("dockerhub", `{"push_data": {"tag": "1.2"}, "repository": {"repo_name": "vfarcic/go-demo"}}`, "")
+
+	s.Equal(http.StatusAccepted, resp.Code)
+	s.Equal([]string{"myProject-api"}, s.getFlows(resp)[0].Services)
+	s.listener.server.wg.Wait()
+	s.Len(s.runs.opts, 1)
+	s.Equal([]Service{{Target: "api", Repository: "vfarcic/go-demo", Tags: []string{"1.*", "latest"}}}, s.runs.opts[0].Services)
+	s.Equal([]string{"TAG=1.2"}, s.runs.env[0])
+}
+
+func (s ListenTestSuite) Test_Receive_IgnoresTagsThatAreNotAllowed() {
+	resp := s.request("dockerhub", `{"push_data": {"tag": "2.0"}, "repository": {"repo_name": "vfarcic/go-demo"}}`, "")
+
+	s.Equal(http.StatusOK, resp.Code)
+	s.Empty(s.getFlows(resp))
+}
+
+func (s ListenTestSuite) Test_Receive_IgnoresRepositoriesThatAreNotConfigured() {
+	resp := s.request("dockerhub", `{"push_data": {"tag": "latest"}, "repository": {"repo_name": "vfarcic/books-ms"}}`, "")
+
+	s.Equal(http.StatusOK, resp.Code)
+	s.Empty(s.getFlows(resp))
+}
+
+func (s ListenTestSuite) Test_Receive_ReturnsBadRequest_WhenDockerHubPayloadIsInvalid() {
+	s.Equal(http.StatusBadRequest, s.request("dockerhub", `{"repository": {"repo_name": "vfarcic/go-demo"}}`, "").Code)
+	s.Equal(http.StatusBadRequest, s.request("dockerhub", `not json`, "").Code)
+}
+
+// Registry
+
+func (s ListenTestSuite) Test_Receive_DeploysServiceOfRegistryRepository() {
+	payload := `{"events": [
+		{"action": "push", "target": {"repository": "ui"}, "request": {"host": "registry.acme.com:5000"}},
+		{"action": "pull", "target": {"repository": "ui", "tag": "3.1"}, "request": {"host": "registry.acme.com:5000"}},
+		{"action": "push", "target": {"repository": "ui", "tag": "3.2"}, "request": {"host": "registry.acme.com:5000"}}
+	]}`
+
+	resp := s.request("registry", payload, "")
+
+	s.Equal(http.StatusAccepted, resp.Code)
+	s.Len(s.getFlows(resp), 1)
+	s.listener.server.wg.Wait()
+	s.Equal([]string{"UI_TAG=3.2"}, s.runs.env[0])
+}
+
+// Git
+
+func (s ListenTestSuite) Test_Receive_DeploysServiceOfGitHubBranch() {
+	resp := s.request("git", `{"ref": "refs/heads/master", "after": "a1b2c3", "repository": {"full_name": "acme/docs"}}`, "")
+
+	s.Equal(http.StatusAccepted, resp.Code)
+	s.listener.server.wg.Wait()
+	s.Equal([]string{"TAG=master"}, s.runs.env[0])
+}
+
+func (s ListenTestSuite) Test_Receive_DeploysServiceOfGitLabTag() {
+	resp := s.request("git", `{"ref": "refs/tags/v1.0", "after": "a1b2c3", "project": {"path_with_namespace": "acme/docs"}}`, "")
+
+	s.Equal(http.StatusAccepted, resp.Code)
+	s.listener.server.wg.Wait()
+	s.Equal([]string{"TAG=v1.0"}, s.runs.env[0])
+}
+
+func (s ListenTestSuite) Test_Receive_IgnoresDeletedReferencesAndPings() {
+	s.Equal(http.StatusOK, s.request("git", `{"ref": "refs/heads/master", "deleted": true, "repository": {"full_name": "acme/docs"}}`, "").Code)
+	s.Equal(http.StatusOK, s.request("git", `{"ref": "refs/heads/master", "after": "0000000000000000000000000000000000000000", "project": {"path_with_namespace": "acme/docs"}}`, "").Code)
+	s.Equal(http.StatusOK, s.request("git", `{"zen": "Keep it logically awesome.", "repository": {"full_name": "acme/docs"}}`, "").Code)
+	s.Empty(s.runs.opts)
+}
+
+func (s ListenTestSuite) Test_Receive_DoesNotQueueAnyFlow_WhenOneOfThemIsInvalid() {
+	processOpts = func(opts *Opts) error {
+		if opts.Services[0].Target == "docs" {
+			return fmt.Errorf("This is an error")
+		}
+		return nil
+	}
+	payload := `{"events": [
+		{"action": "push", "target": {"repository": "ui", "tag": "3.1"}, "request": {"host": "registry.acme.com:5000"}},
+		{"action": "push", "target": {"repository": "acme/docs", "tag": "master"}}
+	]}`
+
+	resp := s.request("registry", payload, "")
+
+	s.Equal(http.StatusInternalServerError, resp.Code)
+	s.listener.server.wg.Wait()
+	s.Empty(s.runs.opts)
+	s.Empty(s.listener.server.flows)
+}
+
+// Signature
+
+func (s ListenTestSuite) Test_Receive_ReturnsUnauthorized_WhenSignatureIsInvalid() {
+	s.listener.Opts.Secret = "mySecret"
+	body := `{"ref": "refs/heads/master", "repository": {"full_name": "acme/docs"}}`
+
+	s.Equal(http.StatusUnauthorized, s.request("git", body, "").Code)
+	s.Equal(http.StatusUnauthorized, s.request("git", body, s.sign("otherSecret", body)).Code)
+	s.Empty(s.runs.opts)
+}
+
+func (s ListenTestSuite) Test_Receive_AcceptsValidSignature() {
+	s.listener.Opts.Secret = "mySecret"
+	body := `{"ref": "refs/heads/master", "repository": {"full_name": "acme/docs"}}`
+
+	resp := s.request("git", body, s.sign("mySecret", body))
+
+	s.Equal(http.StatusAccepted, resp.Code)
+}
+
+func (s ListenTestSuite) Test_Receive_ReturnsUnauthorized_WhenTokenIsInvalid() {
+	s.listener.Opts.Token = "myToken"
+	body := `{"push_data": {"tag": "latest"}, "repository": {"repo_name": "vfarcic/go-demo"}}`
+
+	s.Equal(http.StatusUnauthorized, s.requestPath("/v1/hooks/dockerhub", body, "").Code)
+	s.Equal(http.StatusUnauthorized, s.requestPath("/v1/hooks/dockerhub?token=otherToken", body, "").Code)
+	s.Equal(http.StatusUnauthorized, s.requestPath("/v1/hooks/dockerhub", body, "Bearer otherToken").Code)
+	s.Empty(s.runs.opts)
+}
+
+func (s ListenTestSuite) Test_Receive_AcceptsValidToken() {
+	s.listener.Opts.Token = "myToken"
+	body := `{"push_data": {"tag": "latest"}, "repository": {"repo_name": "vfarcic/go-demo"}}`
+
+	s.Equal(http.StatusAccepted, s.requestPath("/v1/hooks/dockerhub?token=myToken", body, "").Code)
+	s.Equal(http.StatusAccepted, s.requestPath("/v1/hooks/dockerhub", body, "Bearer myToken").Code)
+}
+
+// Routing
+
+func (s ListenTestSuite) Test_ServeHTTP_ReturnsNotFound_WhenSourceIsUnknown() {
+	s.Equal(http.StatusNotFound, s.request("bitbucket", `{}`, "").Code)
+}
+
+func (s ListenTestSuite) Test_ServeHTTP_ReturnsStatusOfFlow() {
+	s.request("dockerhub", `{"push_data": {"tag": "latest"}, "repository": {"repo_name": "vfarcic/go-demo"}}`, "")
+	s.listener.server.wg.Wait()
+	req := httptest.NewRequest("GET", "/v1/flows/flow-1", nil)
+	resp := httptest.NewRecorder()
+
+	s.listener.ServeHTTP(resp, req)
+
+	s.Equal(http.StatusOK, resp.Code)
+	s.Contains(resp.Body.String(), ReportStatusSuccess)
+}
+
+func (s ListenTestSuite) Test_ServeHTTP_DoesNotAcceptFlowsWithOptions() {
+	req := httptest.NewRequest("POST", "/v1/flows", strings.NewReader(`{"target": "api"}`))
+	resp := httptest.NewRecorder()
+
+	s.listener.ServeHTTP(resp, req)
+
+	s.Equal(http.StatusNotFound, resp.Code)
+}
+
+// ValidateListenOpts
+
+func (s ListenTestSuite) Test_ValidateListenOpts_ReturnsNil() {
+	s.NoError(ValidateListenOpts(*s.opts))
+}
+
+func (s ListenTestSuite) Test_ValidateListenOpts_ReturnsError_WhenNoServiceHasRepository() {
+	s.Error(ValidateListenOpts(Opts{Target: "api"}))
+	s.Error(ValidateListenOpts(Opts{Services: []Service{{Target: "api"}}}))
+}
+
+func (s ListenTestSuite) Test_ValidateListenOpts_ReturnsError_WhenServicesAreInvalid() {
+	s.Error(ValidateListenOpts(Opts{Services: []Service{{Target: "api", Repository: "vfarcic/go-demo"}}}))
+}
+
+// ValidateListenerOpts
+
+func (s ListenTestSuite) Test_ValidateListenerOpts_ReturnsNil_WhenWebhooksAreAuthenticatedOrAddressIsLoopback() {
+	s.NoError(ValidateListenerOpts(ListenOpts{Address: ":8080", Secret: "mySecret"}))
+	s.NoError(ValidateListenerOpts(ListenOpts{Address: ":8080", Token: "myToken"}))
+	s.NoError(ValidateListenerOpts(ListenOpts{Address: ":8080", AllowUnauthenticated: true}))
+	s.NoError(ValidateListenerOpts(ListenOpts{Address: "127.0.0.1:8080"}))
+}
+
+func (s ListenTestSuite) Test_ValidateListenerOpts_ReturnsError_WhenSecretAndTokenAreEmptyAndAddressIsNotLoopback() {
+	s.Error(ValidateListenerOpts(ListenOpts{Address: ":8080"}))
+}
+
+func (s ListenTestSuite) request(source, body, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v1/hooks/"+source, strings.NewReader(body))
+	if len(signature) > 0 {
+		req.Header.Set("X-Hub-Signature-256", signature)
+	}
+	resp := httptest.NewRecorder()
+	s.listener.ServeHTTP(resp, req)
+	return resp
+}
+
+func (s ListenTestSuite) requestPath(path, body, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp := httptest.NewRecorder()
+	s.listener.ServeHTTP(resp, req)
+	return resp
+}
+
+func (s ListenTestSuite) getFlows(resp *httptest.ResponseRecorder) []ServerFlow {
+	actual := map[string][]ServerFlow{}
+	s.NoError(json.Unmarshal(resp.Body.Bytes(), &actual))
+	return actual["flows"]
+}
+
+func (s ListenTestSuite) sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestListenTestSuite(t *testing.T) {
+	loadServerOptsOrig := loadServerOpts
+	processOptsOrig := processOpts
+	runServerFlowOrig := runServerFlow
+	getFlowRunIdOrig := getFlowRunId
+	defer func() {
+		loadServerOpts = loadServerOptsOrig
+		processOpts = processOptsOrig
+		runServerFlow = runServerFlowOrig
+		getFlowRunId = getFlowRunIdOrig
+	}()
+	suite.Run(t, new(ListenTestSuite))
+}
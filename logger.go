@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"./util"
+)
+
+const LogFormatText = "text"
+const LogFormatJson = "json"
+const LogLevelInfo = "info"
+const LogLevelError = "error"
+
+var logFormat = LogFormatText
+var logWriter io.Writer = os.Stderr
+var logNow = time.Now
+var logMutex = &sync.Mutex{}
+
+type LogEvent struct {
+	Time     string       `json:"time"`
+	Level    string       `json:"level"`
+	Message  string       `json:"message"`
+	RunId    string       `json:"run_id,omitempty"`
+	Service  string       `json:"service,omitempty"`
+	Step     string       `json:"step,omitempty"`
+	Color    string       `json:"color,omitempty"`
+	Status   string       `json:"status,omitempty"`
+	Stream   string       `json:"stream,omitempty"`
+	Duration float64      `json:"duration,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Steps    []LogStepSum `json:"steps,omitempty"`
+}
+
+type logFieldsKey struct{}
+
+type LogStepSum struct {
+	Service  string  `json:"service,omitempty"`
+	Step     string  `json:"step"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// SetLogFormat switches the output to the given format.
+// With the json format, messages and the output of commands are written as one JSON event per line.
+func SetLogFormat(format string) {
+	logFormat = format
+	if format != LogFormatJson {
+		return
+	}
+	logPrintln = func(v ...interface{}) {
+		writeLogEvent(context.Background(), LogEvent{Level: LogLevelInfo, Message: strings.TrimSuffix(fmt.Sprintln(v...), "\n")})
+	}
+	logPrintf = func(format string, v ...interface{}) {
+		writeLogEvent(context.Background(), LogEvent{Level: LogLevelInfo, Message: fmt.Sprintf(format, v...)})
+	}
+	logFatal = func(v ...interface{}) {
+		writeLogEvent(context.Background(), LogEvent{Level: LogLevelError, Message: "The flow failed", Error: fmt.Sprint(v...)})
+		osExit(1)
+	}
+	util.RetryPrintf = logContextPrintf
+	util.Stdout = &logStreamWriter{ctx: context.Background(), stream: "stdout"}
+	util.Stderr = &logStreamWriter{ctx: context.Background(), stream: "stderr"}
+	util.OutputWriter = func(ctx context.Context, w io.Writer) io.Writer {
+		if streamWriter, ok := w.(*logStreamWriter); ok {
+			return &logStreamWriter{ctx: ctx, stream: streamWriter.stream}
+		}
+		return w
+	}
+}
+
+// withLogFields stores the run, the service, the step and the color of a flow in the context
+// so that the JSON events written with the context tell which flow they belong to.
+func withLogFields(ctx context.Context, state *RunState, step string) context.Context {
+	fields := LogEvent{Service: state.Opts.ServiceName, Step: step, Color: state.NextColor}
+	if state.Run != nil {
+		fields.RunId = state.Run.Id
+	}
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+// logContextPrintln is logPrintln for messages about the flow the context belongs to.
+func logContextPrintln(ctx context.Context, v ...interface{}) {
+	if logFormat != LogFormatJson {
+		logPrintln(v...)
+		return
+	}
+	writeLogEvent(ctx, LogEvent{Level: LogLevelInfo, Message: strings.TrimSuffix(fmt.Sprintln(v...), "\n")})
+}
+
+// logContextPrintf is logPrintf for messages about the flow the context belongs to.
+func logContextPrintf(ctx context.Context, format string, v ...interface{}) {
+	if logFormat != LogFormatJson {
+		logPrintf(format, v...)
+		return
+	}
+	writeLogEvent(ctx, LogEvent{Level: LogLevelInfo, Message: fmt.Sprintf(format, v...)})
+}
+
+func writeLogEvent(ctx context.Context, event LogEvent) {
+	if fields, ok := ctx.Value(logFieldsKey{}).(LogEvent); ok && len(event.Service) == 0 {
+		event.RunId = fields.RunId
+		event.Service = fields.Service
+		event.Step = fields.Step
+		event.Color = fields.Color
+	}
+	event.Time = logNow().UTC().Format(time.RFC3339Nano)
+	data, _ := json.Marshal(event)
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logWriter.Write(append(data, '\n'))
+}
+
+// logStepResult reports a step that was run. The text format prints only the message.
+func logStepResult(state *RunState, result StepResult) {
+	event := LogEvent{
+		Level:    LogLevelInfo,
+		Message:  fmt.Sprintf("The %s step finished with the status %s in %s", result.Name, result.Status, result.Duration),
+		Service:  state.Opts.ServiceName,
+		Step:     result.Name,
+		Color:    state.NextColor,
+		Status:   result.Status,
+		Duration: result.Duration.Seconds(),
+	}
+	if state.Run != nil {
+		event.RunId = state.Run.Id
+	}
+	if result.Error != nil {
+		event.Level = LogLevelError
+		event.Error = result.Error.Error()
+	}
+	if logFormat == LogFormatJson {
+		writeLogEvent(context.Background(), event)
+		return
+	}
+	logPrintln(event.Message)
+}
+
+// logSummary reports the status and the duration of each step once the flow is finished.
+func logSummary(results []StepResult, duration time.Duration) {
+	event := LogEvent{
+		Level:    LogLevelInfo,
+		Message:  "Summary of the flow",
+		Duration: duration.Seconds(),
+		Steps:    []LogStepSum{},
+	}
+	lines := []string{fmt.Sprintf("Summary of the flow (%s):", duration)}
+	for _, result := range results {
+		step := LogStepSum{
+			Service:  result.Service,
+			Step:     result.Name,
+			Status:   result.Status,
+			Duration: result.Duration.Seconds(),
+		}
+		if result.Error != nil {
+			step.Error = result.Error.Error()
+			event.Level = LogLevelError
+		}
+		event.Steps = append(event.Steps, step)
+		name := result.Name
+		if len(result.Service) > 0 {
+			name = fmt.Sprintf("%s/%s", result.Service, result.Name)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s (%s)", name, result.Status, result.Duration))
+	}
+	if logFormat == LogFormatJson {
+		writeLogEvent(context.Background(), event)
+		return
+	}
+	logPrintln(strings.Join(lines, "\n"))
+}
+
+// logStreamWriter turns each line written by a command into a log event of the flow the context belongs to.
+type logStreamWriter struct {
+	ctx    context.Context
+	stream string
+	mu     sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (w *logStreamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buf.Next(i+1)), "\r\n")
+		if len(line) > 0 {
+			writeLogEvent(w.ctx, LogEvent{Level: LogLevelInfo, Message: line, Stream: w.stream})
+		}
+	}
+	return len(p), nil
+}
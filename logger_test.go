@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"./util"
+)
+
+type LoggerTestSuite struct {
+	suite.Suite
+	output       *bytes.Buffer
+	logPrintln   func(v ...interface{})
+	logPrintf    func(format string, v ...interface{})
+	logFatal     func(v ...interface{})
+	retryPrintf  func(ctx context.Context, format string, v ...interface{})
+	outputWriter func(ctx context.Context, w io.Writer) io.Writer
+	stdout       io.Writer
+	stderr       io.Writer
+}
+
+func (s *LoggerTestSuite) SetupTest() {
+	s.output = new(bytes.Buffer)
+	s.logPrintln = logPrintln
+	s.logPrintf = logPrintf
+	s.logFatal = logFatal
+	s.retryPrintf = util.RetryPrintf
+	s.outputWriter = util.OutputWriter
+	s.stdout = util.Stdout
+	s.stderr = util.Stderr
+	logWriter = s.output
+	logNow = func() time.Time {
+		return time.Date(2016, 5, 4, 3, 2, 1, 0, time.UTC)
+	}
+}
+
+func (s *LoggerTestSuite) TearDownTest() {
+	logPrintln = s.logPrintln
+	logPrintf = s.logPrintf
+	logFatal = s.logFatal
+	util.RetryPrintf = s.retryPrintf
+	util.OutputWriter = s.outputWriter
+	util.Stdout = s.stdout
+	util.Stderr = s.stderr
+	logFormat = LogFormatText
+	logNow = time.Now
+}
+
+// SetLogFormat
+
+func (s LoggerTestSuite) Test_SetLogFormat_WritesMessagesAsJson() {
+	SetLogFormat(LogFormatJson)
+
+	logPrintln("Deploying (app-blue)...")
+	logPrintf("Sending request to %s", "http://proxy")
+
+	events := s.getEvents()
+	s.Equal([]LogEvent{
+		{Time: "2016-05-04T03:02:01Z", Level: LogLevelInfo, Message: "Deploying (app-blue)..."},
+		{Time: "2016-05-04T03:02:01Z", Level: LogLevelInfo, Message: "Sending request to http://proxy"},
+	}, events)
+}
+
+func (s LoggerTestSuite) Test_SetLogFormat_WritesCommandOutputAsJson() {
+	SetLogFormat(LogFormatJson)
+
+	fmt.Fprint(util.Stdout, "Pulling app (vfarcic/go-demo:latest)...\nlatest: Pulling")
+	fmt.Fprint(util.Stdout, " from vfarcic/go-demo\n")
+	fmt.Fprint(util.Stderr, "Creating app_1\n")
+
+	events := s.getEvents()
+	s.Len(events, 3)
+	s.Equal("Pulling app (vfarcic/go-demo:latest)...", events[0].Message)
+	s.Equal("latest: Pulling from vfarcic/go-demo", events[1].Message)
+	s.Equal("stdout", events[1].Stream)
+	s.Equal("stderr", events[2].Stream)
+}
+
+func (s LoggerTestSuite) Test_SetLogFormat_WritesFieldsOfFlowToMessagesAndCommandOutput() {
+	SetLogFormat(LogFormatJson)
+	state := &RunState{Opts: Opts{ServiceName: "go-demo-app"}, NextColor: GreenColor, Run: &FlowRun{Id: "myRunId"}}
+	ctx := withLogFields(context.Background(), state, "deploy")
+
+	logContextPrintf(ctx, "Deploying (%s)...", "app-green")
+	fmt.Fprint(util.OutputWriter(ctx, util.Stdout), "Creating app_1\n")
+	util.RetryPrintf(ctx, "Pulling failed, retrying")
+
+	events := s.getEvents()
+	s.Len(events, 3)
+	for _, event := range events {
+		s.Equal("myRunId", event.RunId)
+		s.Equal("go-demo-app", event.Service)
+		s.Equal("deploy", event.Step)
+		s.Equal(GreenColor, event.Color)
+	}
+	s.Equal("stdout", events[1].Stream)
+}
+
+func (s LoggerTestSuite) Test_SetLogFormat_WritesFatalErrorsAsJson() {
+	SetLogFormat(LogFormatJson)
+	exitCode := 0
+	osExitOrig := osExit
+	defer func() { osExit = osExitOrig }()
+	osExit = func(code int) { exitCode = code }
+
+	logFatal(fmt.Errorf("This is an error"))
+
+	events := s.getEvents()
+	s.Equal(LogLevelError, events[0].Level)
+	s.Equal("This is an error", events[0].Error)
+	s.Equal(1, exitCode)
+}
+
+func (s LoggerTestSuite) Test_SetLogFormat_DoesNotChangeLoggers_WhenText() {
+	actual := ""
+	logPrintln = func(v ...interface{}) { actual = fmt.Sprint(v...) }
+
+	SetLogFormat(LogFormatText)
+	logPrintln("Deploying...")
+
+	s.Equal("Deploying...", actual)
+	s.Empty(s.output.String())
+}
+
+// logStepResult
+
+func (s LoggerTestSuite) Test_LogStepResult_WritesStepEvent_WhenJson() {
+	logFormat = LogFormatJson
+	state := &RunState{Opts: Opts{ServiceName: "go-demo-app"}, NextColor: GreenColor, Run: &FlowRun{Id: "myRunId"}}
+
+	logStepResult(state, StepResult{Name: "deploy", Status: StepStatusFailure, Duration: 1500 * time.Millisecond, Error: fmt.Errorf("This is an error")})
+
+	events := s.getEvents()
+	s.Equal("myRunId", events[0].RunId)
+	s.Equal("go-demo-app", events[0].Service)
+	s.Equal("deploy", events[0].Step)
+	s.Equal(GreenColor, events[0].Color)
+	s.Equal(StepStatusFailure, events[0].Status)
+	s.Equal(LogLevelError, events[0].Level)
+	s.Equal(1.5, events[0].Duration)
+	s.Equal("This is an error", events[0].Error)
+}
+
+func (s LoggerTestSuite) Test_LogStepResult_PrintsMessage_WhenText() {
+	actual := ""
+	logPrintln = func(v ...interface{}) { actual = fmt.Sprint(v...) }
+
+	logStepResult(&RunState{}, StepResult{Name: "deploy", Status: StepStatusSuccess, Duration: time.Second})
+
+	s.Equal("The deploy step finished with the status success in 1s", actual)
+}
+
+// logSummary
+
+func (s LoggerTestSuite) Test_LogSummary_WritesTimingOfEachStep_WhenJson() {
+	logFormat = LogFormatJson
+
+	logSummary([]StepResult{
+		{Service: "db", Name: "deploy", Status: StepStatusSuccess, Duration: 2 * time.Second},
+		{Service: "api", Name: "deploy", Status: StepStatusFailure, Duration: time.Second, Error: fmt.Errorf("This is an error")},
+		{Service: "api", Name: "proxy", Status: StepStatusSkipped},
+	}, 3*time.Second)
+
+	events := s.getEvents()
+	s.Equal(LogLevelError, events[0].Level)
+	s.Equal(3.0, events[0].Duration)
+	s.Equal([]LogStepSum{
+		{Service: "db", Step: "deploy", Status: StepStatusSuccess, Duration: 2},
+		{Service: "api", Step: "deploy", Status: StepStatusFailure, Duration: 1, Error: "This is an error"},
+		{Service: "api", Step: "proxy", Status: StepStatusSkipped},
+	}, events[0].Steps)
+}
+
+func (s LoggerTestSuite) Test_LogSummary_PrintsTimingOfEachStep_WhenText() {
+	actual := ""
+	logPrintln = func(v ...interface{}) { actual = fmt.Sprint(v...) }
+
+	logSummary([]StepResult{
+		{Name: "deploy", Status: StepStatusSuccess, Duration: 2 * time.Second},
+		{Service: "api", Name: "proxy", Status: StepStatusSkipped},
+	}, 3*time.Second)
+
+	s.Equal("Summary of the flow (3s):\ndeploy: success (2s)\napi/proxy: skipped (0s)", actual)
+}
+
+func (s LoggerTestSuite) getEvents() []LogEvent {
+	events := []LogEvent{}
+	for _, line := range strings.Split(strings.TrimSpace(s.output.String()), "\n") {
+		event := LogEvent{}
+		s.NoError(json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestLoggerTestSuite(t *testing.T) {
+	suite.Run(t, new(LoggerTestSuite))
+}
@@ -1,14 +1,21 @@
 package main
 
-// TODO: Test
-
 import (
-	"fmt"
+	"context"
 	"log"
-	"strings"
-	"./compose"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+const ExitCodeInterrupted = 130
+const CommandConfig = "config"
+const CommandInit = "init"
+const CommandListen = "listen"
+const CommandServer = "server"
+
 func init() {
 	log.SetPrefix(">> Docker Flow: ")
 	log.SetFlags(0)
@@ -17,76 +24,95 @@ func init() {
 var logFatal = log.Fatal
 var logPrintln = log.Println
 var logPrintf = log.Printf
-var deployed = false
+var osExit = os.Exit
+var notifySignal = signal.Notify
+var stopSignal = signal.Stop
+var commands = map[string]func() error{
+	CommandConfig: RunConfigCommand,
+	CommandInit:   RunInitCommand,
+	CommandListen: RunListenCommand,
+	CommandServer: RunServerCommand,
+}
 
 func main() {
-	//	createdFlow := false
-	flow := getFlow()
-	sc := getServiceDiscovery()
-
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(); err != nil {
+				logFatal(err)
+			}
+			return
+		}
+	}
 	opts, err := GetOpts()
 	if err != nil {
 		logFatal(err)
+		return
 	}
-	dc := compose.GetDockerCompose()
-
-	for _, step := range opts.Flow {
-		switch strings.ToLower(step) {
-		case FLOW_DEPLOY:
-			if err := flow.Deploy(opts, dc); err != nil {
-				logFatal(err)
-			}
-			deployed = true
-			// TODO: Move to flow
-			logPrintln("Cleaning...")
-			if _, err := sc.PutColor(
-				opts.ServiceDiscoveryAddress,
-				opts.ServiceName,
-				opts.NextColor,
-			); err != nil {
-				logFatal(err)
-			}
-			// TODO: End Move to flow
-		case FLOW_SCALE:
-			if !deployed {
-				logPrintln(fmt.Sprintf("Scaling (%s)...", opts.CurrentTarget))
-				if err := flow.Scale(opts, dc, opts.CurrentTarget, true); err != nil {
-					logFatal(err)
-				}
+	SetLogFormat(opts.LogFormat)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted, stop := handleSignals(cancel)
+	var report *Report
+	if len(opts.Report) > 0 || len(opts.ReportJunit) > 0 || len(opts.MetricsPushgateway) > 0 || len(opts.MetricsTextfile) > 0 {
+		report = NewReport(opts)
+		ctx = WithReport(ctx, report)
+	}
+	start := time.Now()
+	results, err := getFlow().Run(ctx, opts)
+	stop()
+	logSummary(results, time.Since(start))
+	if report != nil {
+		report.Finish(err, time.Since(start))
+		// The error of the flow takes precedence and the report error is only logged next to it
+		if reportErr := WriteReport(opts, report); reportErr != nil {
+			if err != nil {
+				logPrintln(reportErr.Error())
+			} else {
+				err = reportErr
 			}
-		case FLOW_STOP_OLD:
-			// TODO: Move to flow
-			if opts.BlueGreen {
-				target := opts.CurrentTarget
-				color := opts.CurrentColor
-				if !deployed {
-					target = opts.NextTarget
-					color = opts.NextColor
-				}
-				logPrintln(fmt.Sprintf("Stopping old (%s)...", target))
-				if err := dc.CreateFlowFile(
-					opts.ComposePath,
-					opts.ServiceName,
-					opts.Target,
-					opts.SideTargets,
-					color,
-					opts.BlueGreen,
-				); err != nil {
-					logFatal(err)
-				}
-				if err := dc.StopTargets(opts.Host, opts.CertPath, opts.Project, []string{target}); err != nil {
-					logFatal(err)
-				}
-				if err := dc.RemoveFlow(); err != nil {
-					logFatal(err)
+		}
+		// Metrics are not part of the deployment so failing to record them does not fail the flow
+		if metricsErr := WriteMetrics(opts, report); metricsErr != nil {
+			logPrintln(metricsErr.Error())
+		}
+	}
+	if err != nil {
+		if interrupted() {
+			logPrintln("The flow was interrupted")
+			osExit(ExitCodeInterrupted)
+			return
+		}
+		logFatal(err)
+	}
+}
+
+func handleSignals(cancel context.CancelFunc) (func() bool, func()) {
+	var interrupted int32
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	notifySignal(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if atomic.SwapInt32(&interrupted, 1) == 1 {
+					logPrintf("Received %s again, exiting without cleanup", sig)
+					osExit(ExitCodeInterrupted)
+					return
 				}
-			}
-			// TODO: End Move to flow
-		case FLOW_PROXY:
-			if err := flow.Proxy(opts, haProxy); err != nil {
-				logFatal(err)
+				logPrintf("Received %s, stopping the flow and cleaning up...", sig)
+				cancel()
+			case <-done:
+				return
 			}
 		}
-
+	}()
+	isInterrupted := func() bool {
+		return atomic.LoadInt32(&interrupted) == 1
+	}
+	stop := func() {
+		stopSignal(signals)
+		close(done)
 	}
+	return isInterrupted, stop
 }
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"os"
+	"syscall"
 	"testing"
 	"./compose"
+	"./util"
 )
 
 type MainTestSuite struct {
@@ -17,14 +20,14 @@ type MainTestSuite struct {
 
 func (s *MainTestSuite) SetupTest() {
 	s.opts = Opts{
-		ComposePath:   "myComposePath",
-		Target:        "myTarget",
-		NextColor:     "orange",
-		CurrentColor:  "pink",
-		NextTarget:    "myNextTarget",
-		CurrentTarget: "myCurrentTarget",
-		BlueGreen:     true,
-		Flow:          []string{"deploy", "scale"},
+		ComposePath:             "myComposePath",
+		Target:                  "myTarget",
+		NextColor:               "orange",
+		CurrentColor:            "pink",
+		NextTarget:              "myNextTarget",
+		CurrentTarget:           "myCurrentTarget",
+		BlueGreen:               true,
+		Flow:                    []string{"deploy", "scale"},
 		ServiceDiscoveryAddress: "myServiceDiscoveryAddress",
 		ServiceName:             "myServiceName",
 	}
@@ -38,7 +41,9 @@ func (s *MainTestSuite) SetupTest() {
 	serviceDiscovery = getServiceDiscoveryMock(s.opts, "")
 	logFatal = func(v ...interface{}) {}
 	logPrintln = func(v ...interface{}) {}
-	deployed = false
+	logPrintf = func(format string, v ...interface{}) {}
+	osExit = func(code int) {}
+	notifySignal = func(c chan<- os.Signal, sig ...os.Signal) {}
 }
 
 // main
@@ -57,111 +62,34 @@ func (s MainTestSuite) Test_Main_Exits_WhenGetOptsFails() {
 	s.True(actual)
 }
 
-// main > deploy
-
-func (s MainTestSuite) Test_Main_InvokesFlowDeploy_WhenDeploy() {
-	mockObj := getFlowMock("")
-	flow = mockObj
-
-	main()
-
-	mockObj.AssertCalled(
-		s.T(),
-		"Deploy",
-		s.opts,
-		s.dc,
-	)
-}
-
-func (s MainTestSuite) Test_Main_LogsError_WhenDeployAndFlowDeployFails() {
-	mockObj := getFlowMock("Deploy")
-	mockObj.On(
-		"Deploy",
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-	).Return(fmt.Errorf("This is an error"))
-	flow = mockObj
-	actual := false
-	logFatal = func(v ...interface{}) {
-		actual = true
+func (s MainTestSuite) Test_Main_WritesReport_WhenReportIsSpecified() {
+	writeFileOrig := util.WriteFile
+	defer func() { util.WriteFile = writeFileOrig }()
+	actual := map[string]string{}
+	util.WriteFile = func(fileName string, data []byte, perm os.FileMode) error {
+		actual[fileName] = string(data)
+		return nil
 	}
-
-	main()
-
-	s.True(actual)
-}
-
-func (s MainTestSuite) Test_Main_InvokesServiceDiscoveryPutColor_WhenDeploy() {
-	mockObj := getServiceDiscoveryMock(s.opts, "")
-	serviceDiscovery = mockObj
-
-	main()
-
-	mockObj.AssertCalled(
-		s.T(),
-		"PutColor",
-		s.opts.ServiceDiscoveryAddress,
-		s.opts.ServiceName,
-		s.opts.NextColor,
-	)
-}
-
-func (s MainTestSuite) Test_Main_LogsFatal_WhenDeployAndServiceDiscoveryPutColorFails() {
-	mockObj := getServiceDiscoveryMock(s.opts, "PutColor")
-	mockObj.On(
-		"PutColor",
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-	).Return("", fmt.Errorf("This is an error"))
-	serviceDiscovery = mockObj
-	actual := false
-	logFatal = func(v ...interface{}) {
-		actual = true
-	}
-
-	main()
-
-	s.True(actual)
-}
-
-// main > scale
-
-func (s MainTestSuite) Test_Main_InvokesFlowScale_WhenScaleAndNotDeploy() {
-	mockObj := getFlowMock("")
+	s.opts.Report = "report.json"
 	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"scale"}
 		return s.opts, nil
 	}
-	flow = mockObj
 
 	main()
 
-	mockObj.AssertCalled(
-		s.T(),
-		"Scale",
-		s.opts,
-		s.dc,
-		s.opts.CurrentTarget,
-		true,
-	)
+	s.Contains(actual["report.json"], `"status": "success"`)
 }
 
-func (s MainTestSuite) Test_Main_LogsFatal_WhenScaleAndNotDeployAndScaleFails() {
-	mockObj := getFlowMock("Scale")
-	mockObj.On(
-		"Scale",
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-	).Return(fmt.Errorf("This is an error"))
+func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenReportCannotBeWritten() {
+	writeFileOrig := util.WriteFile
+	defer func() { util.WriteFile = writeFileOrig }()
+	util.WriteFile = func(fileName string, data []byte, perm os.FileMode) error {
+		return fmt.Errorf("This is an error")
+	}
+	s.opts.Report = "report.json"
 	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"scale"}
 		return s.opts, nil
 	}
-	flow = mockObj
 	actual := false
 	logFatal = func(v ...interface{}) {
 		actual = true
@@ -172,67 +100,39 @@ func (s MainTestSuite) Test_Main_LogsFatal_WhenScaleAndNotDeployAndScaleFails()
 	s.True(actual)
 }
 
-// main > stop-old
-
-func (s MainTestSuite) Test_Main_InvokesDockerComposeCreateFlowFileWithCurrentColor_WhenStopOldAndDeployed() {
-	mockObj := getDockerComposeMock(s.opts, "")
-	compose.GetDockerCompose = func() compose.DockerComposer{ return mockObj }
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
-		return s.opts, nil
+func (s MainTestSuite) Test_Main_WritesMetrics_WhenMetricsTextfileIsSpecified() {
+	writeFileOrig := util.WriteFile
+	renameFileOrig := util.RenameFile
+	defer func() {
+		util.WriteFile = writeFileOrig
+		util.RenameFile = renameFileOrig
+	}()
+	actual := map[string]string{}
+	util.WriteFile = func(fileName string, data []byte, perm os.FileMode) error {
+		actual[fileName] = string(data)
+		return nil
 	}
-	deployed = true
-
-	main()
-
-	mockObj.AssertCalled(
-		s.T(),
-		"CreateFlowFile",
-		s.opts.ComposePath,
-		s.opts.ServiceName,
-		s.opts.Target,
-		s.opts.SideTargets,
-		s.opts.CurrentColor,
-		s.opts.BlueGreen,
-	)
-}
-
-func (s MainTestSuite) Test_Main_InvokesDockerComposeCreateFlowFileWithNextColor_WhenStopOldAndNotDeployed() {
-	mockObj := getDockerComposeMock(s.opts, "")
-	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+	util.RenameFile = func(oldPath, newPath string) error {
+		return nil
+	}
+	s.opts.MetricsTextfile = "docker_flow.prom"
 	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
 		return s.opts, nil
 	}
 
 	main()
 
-	mockObj.AssertCalled(
-		s.T(),
-		"CreateFlowFile",
-		s.opts.ComposePath,
-		s.opts.ServiceName,
-		s.opts.Target,
-		s.opts.SideTargets,
-		s.opts.NextColor,
-		s.opts.BlueGreen,
-	)
+	s.Contains(actual, "docker_flow.prom.tmp")
 }
 
-func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenStopOldAndDockerComposeCreateFlowFileFails() {
-	mockObj := getDockerComposeMock(s.opts, "CreateFlowFile")
-	mockObj.On(
-		"CreateFlowFile",
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-	).Return(fmt.Errorf("This is an error"))
-	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
+func (s MainTestSuite) Test_Main_DoesNotInvokeLogFatal_WhenMetricsCannotBeWritten() {
+	writeFileOrig := util.WriteFile
+	defer func() { util.WriteFile = writeFileOrig }()
+	util.WriteFile = func(fileName string, data []byte, perm os.FileMode) error {
+		return fmt.Errorf("This is an error")
+	}
+	s.opts.MetricsTextfile = "docker_flow.prom"
 	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
 		return s.opts, nil
 	}
 	actual := false
@@ -242,64 +142,46 @@ func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenStopOldAndDockerComposeCrea
 
 	main()
 
-	s.True(actual)
-}
-
-func (s MainTestSuite) Test_Main_InvokesDockerComposeStopTargetWithCurrentTarget_WhenStopOldAndDeployed() {
-	mockObj := getDockerComposeMock(s.opts, "")
-	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
-		return s.opts, nil
-	}
-	deployed = true
-
-	main()
-
-	mockObj.AssertCalled(
-		s.T(),
-		"StopTargets",
-		s.opts.Host,
-		s.opts.CertPath,
-		s.opts.Project,
-		[]string{s.opts.CurrentTarget},
-	)
+	s.False(actual)
 }
 
-func (s MainTestSuite) Test_Main_InvokesDockerComposeStopTargetWithNextColor_WhenStopOldAndNotDeployed() {
-	mockObj := getDockerComposeMock(s.opts, "")
-	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
-		return s.opts, nil
+func (s MainTestSuite) Test_Main_RunsCommand_WhenFirstArgIsCommand() {
+	argsOrig := os.Args
+	commandsOrig := commands
+	defer func() {
+		os.Args = argsOrig
+		commands = commandsOrig
+	}()
+	actual := false
+	commands = map[string]func() error{
+		CommandConfig: func() error {
+			actual = true
+			return nil
+		},
 	}
+	os.Args = []string{"myProgram", CommandConfig}
+	mockObj := getFlowMock("")
+	flow = mockObj
 
 	main()
 
-	mockObj.AssertCalled(
-		s.T(),
-		"StopTargets",
-		s.opts.Host,
-		s.opts.CertPath,
-		s.opts.Project,
-		[]string{s.opts.NextTarget},
-	)
+	s.True(actual)
+	mockObj.AssertNotCalled(s.T(), "Run", mock.Anything, mock.Anything)
 }
 
-func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenStopOldAndDockerComposeStopTargetsFails() {
-	mockObj := getDockerComposeMock(s.opts, "StopTargets")
-	mockObj.On(
-		"StopTargets",
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-	).Return(fmt.Errorf("This is an error"))
-	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
-		return s.opts, nil
+func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenCommandFails() {
+	argsOrig := os.Args
+	commandsOrig := commands
+	defer func() {
+		os.Args = argsOrig
+		commands = commandsOrig
+	}()
+	commands = map[string]func() error{
+		CommandConfig: func() error {
+			return fmt.Errorf("This is an error")
+		},
 	}
+	os.Args = []string{"myProgram", CommandConfig}
 	actual := false
 	logFatal = func(v ...interface{}) {
 		actual = true
@@ -310,87 +192,69 @@ func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenStopOldAndDockerComposeStop
 	s.True(actual)
 }
 
-func (s MainTestSuite) Test_Main_DoesNotRunStopOld_WhenStopOldAndNotBlueGreen() {
-	mockObj := getDockerComposeMock(s.opts, "")
-	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
-		s.opts.BlueGreen = false
-		return s.opts, nil
-	}
+func (s MainTestSuite) Test_Main_InvokesFlowRun() {
+	mockObj := getFlowMock("")
+	flow = mockObj
 
 	main()
 
-	mockObj.AssertNotCalled(
-		s.T(),
-		"StopTargets",
-		mock.Anything,
-		mock.Anything,
-		mock.Anything,
-	)
+	mockObj.AssertCalled(s.T(), "Run", mock.Anything, s.opts)
 }
 
-func (s MainTestSuite) Test_Main_InvokesDockerComposeRemoveFlow_WhenStopOld() {
-	mockObj := getDockerComposeMock(s.opts, "")
-	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
-		return s.opts, nil
+func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenFlowRunFails() {
+	mockObj := getFlowMock("Run")
+	mockObj.On("Run", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	flow = mockObj
+	actual := false
+	logFatal = func(v ...interface{}) {
+		actual = true
 	}
 
 	main()
 
-	mockObj.AssertCalled(s.T(), "RemoveFlow")
+	s.True(actual)
 }
 
-func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenStopOldAndDockerComposeRemoveFlowFails() {
-	mockObj := getDockerComposeMock(s.opts, "RemoveFlow")
-	mockObj.On("RemoveFlow").Return(fmt.Errorf("This is an error"))
-	compose.GetDockerCompose = func() compose.DockerComposer { return mockObj }
-	actual := false
-	logFatal = func(v ...interface{}) {
-		actual = true
+func (s MainTestSuite) Test_Main_CancelsFlowAndExitsWithInterruptedCode_WhenSignalIsReceived() {
+	notifySignal = func(c chan<- os.Signal, sig ...os.Signal) {
+		c <- syscall.SIGTERM
 	}
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"stop-old"}
-		return s.opts, nil
+	mockObj := getFlowMock("Run")
+	mockObj.On("Run", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error")).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	})
+	flow = mockObj
+	actual := 0
+	osExit = func(code int) {
+		actual = code
+	}
+	fatal := false
+	logFatal = func(v ...interface{}) {
+		fatal = true
 	}
 
 	main()
 
-	s.True(actual)
+	s.Equal(ExitCodeInterrupted, actual)
+	s.False(fatal)
 }
 
-// main > proxy
-
-func (s MainTestSuite) Test_Main_InvokesFlawProxy() {
-	mockObj := getFlowMock("")
-	flow = mockObj
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"proxy"}
-		return s.opts, nil
+func (s MainTestSuite) Test_Main_ListensToInterruptAndTerminateSignals() {
+	actual := []os.Signal{}
+	notifySignal = func(c chan<- os.Signal, sig ...os.Signal) {
+		actual = sig
 	}
 
 	main()
 
-	mockObj.AssertCalled(
-		s.T(),
-		"Proxy",
-		s.opts,
-		haProxy,
-	)
+	s.Equal([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, actual)
 }
 
-func (s MainTestSuite) Test_Main_InvokesLogFatal_WhenFlawProxyFails() {
-	mockObj := getFlowMock("Proxy")
-	mockObj.On("Proxy", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
-	flow = mockObj
-	GetOpts = func() (Opts, error) {
-		s.opts.Flow = []string{"proxy"}
-		return s.opts, nil
-	}
+func (s MainTestSuite) Test_Main_StopsListeningToSignals_WhenFlowFinishes() {
 	actual := false
-	logFatal = func(v ...interface{}) {
+	stopSignalOrig := stopSignal
+	defer func() { stopSignal = stopSignalOrig }()
+	stopSignal = func(c chan<- os.Signal) {
 		actual = true
 	}
 
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"./util"
+)
+
+const MetricsJob = "docker_flow"
+const metricRunsTotal = "docker_flow_runs_total"
+const metricLastRunTimestamp = "docker_flow_last_run_timestamp_seconds"
+const metricLastRunDuration = "docker_flow_last_run_duration_seconds"
+const metricLastRunSuccess = "docker_flow_last_run_success"
+const metricStepDuration = "docker_flow_step_duration_seconds"
+const metricScale = "docker_flow_scale"
+const metricColor = "docker_flow_color"
+
+var metricsNow = time.Now
+
+var metricSampleRegexp = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(.*)\})?\s+(\S+)`)
+var metricLabelRegexp = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"`)
+
+// metricDefinitions lists the metrics in the order they are written together with their help and type.
+var metricDefinitions = []struct {
+	name string
+	help string
+	kind string
+}{
+	{metricRunsTotal, "Number of flows run for the service by their outcome.", "counter"},
+	{metricLastRunTimestamp, "Time the last flow of the service finished.", "gauge"},
+	{metricLastRunDuration, "Duration of the last flow of the service.", "gauge"},
+	{metricLastRunSuccess, "Whether the last flow of the service succeeded.", "gauge"},
+	{metricStepDuration, "Duration of the steps of the last flow of the service.", "gauge"},
+	{metricScale, "Number of instances of the service after the last flow.", "gauge"},
+	{metricColor, "Color of the release of the service that is running after the last flow.", "gauge"},
+}
+
+type metricSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+func (m metricSample) getLabel(name string) string {
+	return m.Labels[name]
+}
+
+// WriteMetrics pushes the metrics of the flow to the Pushgateway and writes them to the textfile.
+// The runs counters continue from the values already stored there.
+func WriteMetrics(opts Opts, report *Report) error {
+	if len(opts.MetricsTextfile) > 0 {
+		if err := writeMetricsTextfile(opts.MetricsTextfile, report); err != nil {
+			return err
+		}
+	}
+	if len(opts.MetricsPushgateway) > 0 {
+		if err := pushMetrics(opts, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMetricsTextfile(path string, report *Report) error {
+	previous := []metricSample{}
+	if data, err := util.ReadFile(path); err == nil {
+		previous = parseMetrics(string(data))
+	}
+	services := map[string]bool{}
+	for _, service := range report.Services {
+		services[service.Name] = true
+	}
+	samples := []metricSample{}
+	for _, sample := range previous {
+		if !services[sample.getLabel("service")] {
+			samples = append(samples, sample)
+		}
+	}
+	samples = append(samples, getFlowMetrics(report, previous)...)
+	// The file is replaced at once so that the textfile collector never reads it partially written
+	tmpPath := path + ".tmp"
+	if err := util.WriteFile(tmpPath, []byte(formatMetrics(samples)), 0644); err != nil {
+		return fmt.Errorf("Could not write the metrics to %s\n%s", path, err.Error())
+	}
+	if err := util.RenameFile(tmpPath, path); err != nil {
+		return fmt.Errorf("Could not write the metrics to %s\n%s", path, err.Error())
+	}
+	return nil
+}
+
+func pushMetrics(opts Opts, report *Report) error {
+	address := strings.TrimRight(opts.MetricsPushgateway, "/")
+	ctx := util.WithRetryPolicy(context.Background(), getRetryPolicy(opts))
+	previous := []metricSample{}
+	if err := util.Retry(ctx, "The request for the metrics of the Pushgateway", func() error {
+		data, err := sendMetricsRequest(ctx, "GET", address+"/metrics", nil)
+		if err == nil {
+			previous = parseMetrics(string(data))
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	metrics := getFlowMetrics(report, previous)
+	for _, service := range report.Services {
+		// Each service is pushed to its own group so that pushing one service keeps the metrics of the others
+		samples := []metricSample{}
+		for _, sample := range metrics {
+			if sample.getLabel("service") == service.Name {
+				samples = append(samples, sample)
+			}
+		}
+		groupUrl := fmt.Sprintf("%s/metrics/job/%s/service/%s", address, MetricsJob, url.PathEscape(service.Name))
+		body := []byte(formatMetrics(samples))
+		if err := util.Retry(ctx, "The request to push the metrics", func() error {
+			_, err := sendMetricsRequest(ctx, "PUT", groupUrl, body)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendMetricsRequest(ctx context.Context, method, address string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, address, bytes.NewReader(body))
+	if err != nil {
+		return nil, util.Permanent(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	}
+	resp, err := httpDo(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("The request to %s failed\n%s", address, err.Error())
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("The request to %s failed with status code %d\n%s", address, resp.StatusCode, string(data))
+		if resp.StatusCode < 500 {
+			return nil, util.Permanent(err)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func getFlowMetrics(report *Report, previous []metricSample) []metricSample {
+	samples := []metricSample{}
+	now := float64(metricsNow().UnixNano()) / float64(time.Second)
+	for _, service := range report.Services {
+		status := StepStatusSuccess
+		success := 1.0
+		if len(service.Error) > 0 {
+			status = StepStatusFailure
+			success = 0
+		}
+		labels := map[string]string{"service": service.Name}
+		for _, s := range []string{StepStatusSuccess, StepStatusFailure} {
+			runsLabels := map[string]string{"service": service.Name, "status": s}
+			runs := getMetricValue(previous, metricRunsTotal, runsLabels)
+			if s == status {
+				runs++
+			}
+			samples = append(samples, metricSample{metricRunsTotal, runsLabels, runs})
+		}
+		duration := 0.0
+		color := service.CurrentColor
+		for _, step := range service.Steps {
+			if step.Status == StepStatusSkipped {
+				continue
+			}
+			duration += step.Duration
+			if step.Type == FLOW_DEPLOY && step.Status == StepStatusSuccess {
+				color = service.NextColor
+			}
+			samples = append(samples, metricSample{
+				metricStepDuration,
+				map[string]string{"service": service.Name, "step": step.Name, "status": step.Status},
+				step.Duration,
+			})
+		}
+		samples = append(
+			samples,
+			metricSample{metricLastRunTimestamp, labels, now},
+			metricSample{metricLastRunDuration, labels, duration},
+			metricSample{metricLastRunSuccess, labels, success},
+		)
+		if service.getScale() > 0 {
+			samples = append(samples, metricSample{metricScale, labels, float64(service.getScale())})
+		}
+		if len(color) > 0 {
+			samples = append(samples, metricSample{metricColor, map[string]string{"service": service.Name, "color": color}, 1})
+		}
+	}
+	return samples
+}
+
+func getMetricValue(samples []metricSample, name string, labels map[string]string) float64 {
+	for _, sample := range samples {
+		if sample.Name != name {
+			continue
+		}
+		matches := true
+		for key, value := range labels {
+			matches = matches && sample.getLabel(key) == value
+		}
+		if matches {
+			return sample.Value
+		}
+	}
+	return 0
+}
+
+// parseMetrics reads the samples of the Docker Flow metrics from the Prometheus text format.
+func parseMetrics(data string) []metricSample {
+	known := map[string]bool{}
+	for _, definition := range metricDefinitions {
+		known[definition.name] = true
+	}
+	samples := []metricSample{}
+	for _, line := range strings.Split(data, "\n") {
+		groups := metricSampleRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if groups == nil || !known[groups[1]] {
+			continue
+		}
+		value, err := strconv.ParseFloat(groups[4], 64)
+		if err != nil {
+			continue
+		}
+		labels := map[string]string{}
+		for _, label := range metricLabelRegexp.FindAllStringSubmatch(groups[3], -1) {
+			labels[label[1]] = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n").Replace(label[2])
+		}
+		samples = append(samples, metricSample{groups[1], labels, value})
+	}
+	return samples
+}
+
+func formatMetrics(samples []metricSample) string {
+	lines := []string{}
+	for _, definition := range metricDefinitions {
+		metricLines := []string{}
+		for _, sample := range samples {
+			if sample.Name == definition.name {
+				metricLines = append(metricLines, formatMetricSample(sample))
+			}
+		}
+		if len(metricLines) == 0 {
+			continue
+		}
+		sort.Strings(metricLines)
+		lines = append(lines, fmt.Sprintf("# HELP %s %s", definition.name, definition.help))
+		lines = append(lines, fmt.Sprintf("# TYPE %s %s", definition.name, definition.kind))
+		lines = append(lines, metricLines...)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func formatMetricSample(sample metricSample) string {
+	keys := []string{}
+	for key := range sample.Labels {
+		// Labels added by the Pushgateway are not part of the pushed metrics
+		if key != "job" && key != "instance" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	labels := []string{}
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(sample.Labels[key])
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, key, value))
+	}
+	return fmt.Sprintf("%s{%s} %s", sample.Name, strings.Join(labels, ","), strconv.FormatFloat(sample.Value, 'f', -1, 64))
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"./util"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+	opts     Opts
+	report   *Report
+	files    *metricsFiles
+	server   *httptest.Server
+	received *metricsRequests
+	httpDo   func(req *http.Request) (*http.Response, error)
+}
+
+type metricsFiles struct {
+	data    map[string]string
+	renamed map[string]string
+}
+
+type metricsRequests struct {
+	methods  []string
+	paths    []string
+	bodies   []string
+	existing string
+	status   int
+	mu       sync.Mutex
+}
+
+func (s *MetricsTestSuite) SetupTest() {
+	s.opts = Opts{RetryDelay: 1, RetryMaxDelay: 1}
+	s.report = &Report{Services: []*ReportService{{
+		Name:         "go-demo-app",
+		CurrentColor: BlueColor,
+		NextColor:    GreenColor,
+		Scale:        3,
+		Steps: []ReportStep{
+			{Name: "deploy", Type: FLOW_DEPLOY, Status: StepStatusSuccess, Duration: 3},
+			{Name: "unit", Type: FLOW_TEST, Status: StepStatusSuccess, Duration: 1.5},
+			{Name: "stop-old", Type: FLOW_STOP_OLD, Status: StepStatusSkipped},
+		},
+	}}}
+	files := &metricsFiles{data: map[string]string{}, renamed: map[string]string{}}
+	s.files = files
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		if data, ok := files.data[fileName]; ok {
+			return []byte(data), nil
+		}
+		return nil, fmt.Errorf("The file %s does not exist", fileName)
+	}
+	util.WriteFile = func(fileName string, data []byte, perm os.FileMode) error {
+		files.data[fileName] = string(data)
+		return nil
+	}
+	util.RenameFile = func(oldPath, newPath string) error {
+		files.data[newPath] = files.data[oldPath]
+		delete(files.data, oldPath)
+		files.renamed[oldPath] = newPath
+		return nil
+	}
+	metricsNow = func() time.Time {
+		return time.Unix(1500000000, 0)
+	}
+	received := &metricsRequests{status: http.StatusOK}
+	s.received = received
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.mu.Lock()
+		defer received.mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		received.methods = append(received.methods, r.Method)
+		received.paths = append(received.paths, r.URL.EscapedPath())
+		received.bodies = append(received.bodies, string(body))
+		w.WriteHeader(received.status)
+		if r.Method == "GET" {
+			w.Write([]byte(received.existing))
+		}
+	}))
+	s.httpDo = httpDo
+	httpDo = http.DefaultClient.Do
+	util.RetryPrintf = func(ctx context.Context, format string, v ...interface{}) {}
+}
+
+func (s *MetricsTestSuite) TearDownTest() {
+	s.server.Close()
+	httpDo = s.httpDo
+}
+
+// WriteMetrics
+
+func (s MetricsTestSuite) Test_WriteMetrics_WritesTextfile() {
+	s.opts.MetricsTextfile = "/metrics/docker_flow.prom"
+
+	err := WriteMetrics(s.opts, s.report)
+
+	s.NoError(err)
+	expected := `# HELP docker_flow_runs_total Number of flows run for the service by their outcome.
+# TYPE docker_flow_runs_total counter
+docker_flow_runs_total{service="go-demo-app",status="failure"} 0
+docker_flow_runs_total{service="go-demo-app",status="success"} 1
+# HELP docker_flow_last_run_timestamp_seconds Time the last flow of the service finished.
+# TYPE docker_flow_last_run_timestamp_seconds gauge
+docker_flow_last_run_timestamp_seconds{service="go-demo-app"} 1500000000
+# HELP docker_flow_last_run_duration_seconds Duration of the last flow of the service.
+# TYPE docker_flow_last_run_duration_seconds gauge
+docker_flow_last_run_duration_seconds{service="go-demo-app"} 4.5
+# HELP docker_flow_last_run_success Whether the last flow of the service succeeded.
+# TYPE docker_flow_last_run_success gauge
+docker_flow_last_run_success{service="go-demo-app"} 1
+# HELP docker_flow_step_duration_seconds Duration of the steps of the last flow of the service.
+# TYPE docker_flow_step_duration_seconds gauge
+docker_flow_step_duration_seconds{service="go-demo-app",status="success",step="deploy"} 3
+docker_flow_step_duration_seconds{service="go-demo-app",status="success",step="unit"} 1.5
+# HELP docker_flow_scale Number of instances of the service after the last flow.
+# TYPE docker_flow_scale gauge
+docker_flow_scale{service="go-demo-app"} 3
+# HELP docker_flow_color Color of the release of the service that is running after the last flow.
+# TYPE docker_flow_color gauge
+docker_flow_color{color="green",service="go-demo-app"} 1
+`
+	s.Equal(expected, s.files.data["/metrics/docker_flow.prom"])
+	s.Equal("/metrics/docker_flow.prom", s.files.renamed["/metrics/docker_flow.prom.tmp"])
+}
+
+func (s MetricsTestSuite) Test_WriteMetrics_IncrementsRunsFromTextfile() {
+	s.opts.MetricsTextfile = "docker_flow.prom"
+	s.files.data["docker_flow.prom"] = `# TYPE docker_flow_runs_total counter
+docker_flow_runs_total{service="go-demo-app",status="failure"} 2
+docker_flow_runs_total{service="go-demo-app",status="success"} 7
+`
+	s.report.Services[0].Error = "This is an error"
+
+	WriteMetrics(s.opts, s.report)
+
+	actual := parseMetrics(s.files.data["docker_flow.prom"])
+	s.Equal(3.0, getMetricValue(actual, metricRunsTotal, map[string]string{"status": StepStatusFailure}))
+	s.Equal(7.0, getMetricValue(actual, metricRunsTotal, map[string]string{"status": StepStatusSuccess}))
+	s.Equal(0.0, getMetricValue(actual, metricLastRunSuccess, map[string]string{}))
+}
+
+func (s MetricsTestSuite) Test_WriteMetrics_KeepsOtherServicesInTextfile() {
+	s.opts.MetricsTextfile = "docker_flow.prom"
+	s.files.data["docker_flow.prom"] = `docker_flow_scale{service="books-ms"} 2
+docker_flow_scale{service="go-demo-app"} 1
+`
+
+	WriteMetrics(s.opts, s.report)
+
+	actual := parseMetrics(s.files.data["docker_flow.prom"])
+	s.Equal(2.0, getMetricValue(actual, metricScale, map[string]string{"service": "books-ms"}))
+	s.Equal(3.0, getMetricValue(actual, metricScale, map[string]string{"service": "go-demo-app"}))
+}
+
+func (s MetricsTestSuite) Test_WriteMetrics_UsesCurrentColor_WhenDeployDidNotSucceed() {
+	s.opts.MetricsTextfile = "docker_flow.prom"
+	s.report.Services[0].Steps[0].Status = StepStatusFailure
+
+	WriteMetrics(s.opts, s.report)
+
+	s.Contains(s.files.data["docker_flow.prom"], `docker_flow_color{color="blue",service="go-demo-app"} 1`)
+}
+
+func (s MetricsTestSuite) Test_WriteMetrics_ReturnsError_WhenWriteFails() {
+	s.opts.MetricsTextfile = "docker_flow.prom"
+	util.WriteFile = func(fileName string, data []byte, perm os.FileMode) error {
+		return fmt.Errorf("This is an error")
+	}
+
+	err := WriteMetrics(s.opts, s.report)
+
+	s.Error(err)
+}
+
+func (s MetricsTestSuite) Test_WriteMetrics_PushesEachServiceToPushgateway() {
+	s.opts.MetricsPushgateway = s.server.URL + "/"
+	s.report.Services = append(s.report.Services, &ReportService{Name: "books ms"})
+	s.received.existing = `# TYPE docker_flow_runs_total counter
+docker_flow_runs_total{instance="",job="docker_flow",service="go-demo-app",status="success"} 4
+push_time_seconds{instance="",job="docker_flow",service="go-demo-app"} 1.4e+09
+`
+
+	err := WriteMetrics(s.opts, s.report)
+
+	s.NoError(err)
+	s.Equal([]string{"GET", "PUT", "PUT"}, s.received.methods)
+	s.Equal(
+		[]string{"/metrics", "/metrics/job/docker_flow/service/go-demo-app", "/metrics/job/docker_flow/service/books%20ms"},
+		s.received.paths,
+	)
+	s.Contains(s.received.bodies[1], `docker_flow_runs_total{service="go-demo-app",status="success"} 5`)
+	s.NotContains(s.received.bodies[1], "books ms")
+	s.Contains(s.received.bodies[2], `docker_flow_runs_total{service="books ms",status="success"} 1`)
+}
+
+func (s MetricsTestSuite) Test_WriteMetrics_ReturnsError_WhenPushgatewayFails() {
+	s.opts.MetricsPushgateway = s.server.URL
+	s.received.status = http.StatusBadRequest
+
+	err := WriteMetrics(s.opts, s.report)
+
+	s.Error(err)
+	s.Len(s.received.methods, 1)
+}
+
+func (s MetricsTestSuite) Test_WriteMetrics_DoesNothing_WhenMetricsAreNotEnabled() {
+	err := WriteMetrics(s.opts, s.report)
+
+	s.NoError(err)
+	s.Empty(s.files.data)
+	s.Empty(s.received.methods)
+}
+
+// parseMetrics
+
+func (s MetricsTestSuite) Test_ParseMetrics_UnescapesLabels() {
+	actual := parseMetrics(`docker_flow_color{color="green",service="my \"app\"\\1"} 1`)
+
+	s.Equal([]metricSample{{metricColor, map[string]string{"color": GreenColor, "service": `my "app"\1`}, 1}}, actual)
+}
+
+func (s MetricsTestSuite) Test_ParseMetrics_IgnoresOtherMetrics() {
+	actual := parseMetrics("# HELP go_goroutines Number of goroutines.\ngo_goroutines 12\n")
+
+	s.Empty(actual)
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	readFileOrig := util.ReadFile
+	writeFileOrig := util.WriteFile
+	renameFileOrig := util.RenameFile
+	retryPrintfOrig := util.RetryPrintf
+	metricsNowOrig := metricsNow
+	defer func() {
+		util.ReadFile = readFileOrig
+		util.WriteFile = writeFileOrig
+		util.RenameFile = renameFileOrig
+		util.RetryPrintf = retryPrintfOrig
+		metricsNow = metricsNowOrig
+	}()
+	suite.Run(t, new(MetricsTestSuite))
+}
@@ -1,12 +1,21 @@
 package main
 
-import "github.com/stretchr/testify/mock"
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+)
 
 type DockerComposeMock struct {
 	mock.Mock
 }
 
+func (m *DockerComposeMock) NewFlowPath() (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+
 func (m *DockerComposeMock) CreateFlowFile(
+flowPath,
 dcPath,
 serviceName,
 target string,
@@ -14,53 +23,66 @@ sideTargets []string,
 color string,
 blueGreen bool,
 ) error {
-	args := m.Called(dcPath, serviceName, target, sideTargets, color, blueGreen)
+	args := m.Called(flowPath, dcPath, serviceName, target, sideTargets, color, blueGreen)
 	return args.Error(0)
 }
 
-func (m *DockerComposeMock) RemoveFlow() error {
-	args := m.Called()
+func (m *DockerComposeMock) RemoveFlow(flowPath string) error {
+	args := m.Called(flowPath)
+	return args.Error(0)
+}
+
+func (m *DockerComposeMock) Cleanup(flowPath string) error {
+	args := m.Called(flowPath)
 	return args.Error(0)
 }
 
-func (m *DockerComposeMock) PullTargets(host, certPath, project string, targets []string) error {
-	args := m.Called(host, certPath, project, targets)
+func (m *DockerComposeMock) PullTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error {
+	args := m.Called(ctx, flowPath, dcPath, host, certPath, project, targets)
 	return args.Error(0)
 }
 
-func (m *DockerComposeMock) UpTargets(host, certPath, project string, targets []string) error {
-	args := m.Called(host, certPath, project, targets)
+func (m *DockerComposeMock) UpTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error {
+	args := m.Called(ctx, flowPath, dcPath, host, certPath, project, targets)
 	return args.Error(0)
 }
 
-func (m *DockerComposeMock) ScaleTargets(host, certPath, project, target string, scale int) error {
-	args := m.Called(host, certPath, project, target, scale)
+func (m *DockerComposeMock) ScaleTargets(ctx context.Context, flowPath, dcPath, host, certPath, project, target string, scale int) error {
+	args := m.Called(ctx, flowPath, dcPath, host, certPath, project, target, scale)
 	return args.Error(0)
 }
 
-func (m *DockerComposeMock) RmTargets(host, certPath, project string, targets []string) error {
-	args := m.Called(host, certPath, project, targets)
+func (m *DockerComposeMock) RmTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error {
+	args := m.Called(ctx, flowPath, dcPath, host, certPath, project, targets)
 	return args.Error(0)
 }
 
-func (m *DockerComposeMock) StopTargets(host, certPath, project string, targets []string) error {
-	args := m.Called(host, certPath, project, targets)
+func (m *DockerComposeMock) StopTargets(ctx context.Context, flowPath, dcPath, host, certPath, project string, targets []string) error {
+	args := m.Called(ctx, flowPath, dcPath, host, certPath, project, targets)
+	return args.Error(0)
+}
+
+func (m *DockerComposeMock) RunService(ctx context.Context, host, certPath, project, composePath, service string, env []string) error {
+	args := m.Called(ctx, host, certPath, project, composePath, service, env)
 	return args.Error(0)
 }
 
 func getDockerComposeMock(opts Opts, skipMethod string) *DockerComposeMock {
 	mockObj := new(DockerComposeMock)
+	if skipMethod != "NewFlowPath" {
+		mockObj.On("NewFlowPath").Return(opts.FlowPath, nil)
+	}
 	if skipMethod != "PullTargets" {
-		mockObj.On("PullTargets", opts.Host, opts.CertPath, opts.Project, Flow{}.GetPullTargets(opts)).Return(nil)
+		mockObj.On("PullTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.CertPath, opts.Project, Flow{}.GetPullTargets(opts)).Return(nil)
 	}
 	if skipMethod != "UpTargets" {
-		mockObj.On("UpTargets", opts.Host, opts.CertPath, opts.Project, append(opts.SideTargets, opts.NextTarget)).Return(nil)
+		mockObj.On("UpTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.CertPath, opts.Project, append(opts.SideTargets, opts.NextTarget)).Return(nil)
 	}
 	if skipMethod != "RmTargets" {
-		mockObj.On("RmTargets", opts.Host, opts.CertPath, opts.Project, []string{opts.NextTarget}).Return(nil)
+		mockObj.On("RmTargets", mock.Anything, mock.Anything, mock.Anything, opts.Host, opts.CertPath, opts.Project, []string{opts.NextTarget}).Return(nil)
 	}
 	if skipMethod != "ScaleTargets" {
-		mockObj.On("ScaleTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockObj.On("ScaleTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	}
 	if skipMethod != "CreateFlowFile" {
 		mockObj.On(
@@ -76,10 +98,16 @@ func getDockerComposeMock(opts Opts, skipMethod string) *DockerComposeMock {
 		).Return(nil)
 	}
 	if skipMethod != "StopTargets" {
-		mockObj.On("StopTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockObj.On("StopTargets", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	}
 	if skipMethod != "RemoveFlow" {
-		mockObj.On("RemoveFlow").Return(nil)
+		mockObj.On("RemoveFlow", mock.Anything).Return(nil)
+	}
+	if skipMethod != "Cleanup" {
+		mockObj.On("Cleanup", mock.Anything).Return(nil)
+	}
+	if skipMethod != "RunService" {
+		mockObj.On("RunService", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	}
 	return mockObj
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/jessevdk/go-flags"
 	"github.com/kelseyhightower/envconfig"
@@ -8,13 +9,17 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 	"./util"
 )
 
 const dockerFlowPath = "docker-flow.yml"
 const dockerComposePath = "docker-compose.yml"
+const RetryDefaultDelay = 1000
+const RetryDefaultMaxDelay = 30000
 
 var getWd = os.Getwd
+var getConfigArgs = GetConfigArgs
 var parseYml = ParseYml
 var parseEnvVars = ParseEnvVars
 var parseArgs = ParseArgs
@@ -24,36 +29,89 @@ type Opts struct {
 	BlueGreen               bool     `short:"b" long:"blue-green" description:"Perform blue-green deployment." yaml:"blue_green" envconfig:"blue_green"`
 	CertPath                string   `long:"cert-path" description:"Docker certification path. If not specified, DOCKER_CERT_PATH environment variable will be used instead." yaml:"cert_path" envconfig:"cert_path"`
 	ComposePath             string   `short:"f" long:"compose-path" value-name:"docker-compose.yml" description:"Path to the Docker Compose configuration file. If not specified, the default docker-compose.yml files will be used." yaml:"compose_path" envconfig:"compose_path"`
+	Config                  string   `long:"config" description:"Path to the Docker Flow file. YAML, JSON (.json) and TOML (.toml) files are supported. If not specified, docker-flow.yml from the current directory is used when it exists." yaml:"-" envconfig:"config"`
 	ServiceDiscoveryAddress string   `short:"c" long:"consul-address" description:"The address of the Consul server." yaml:"consul_address" envconfig:"consul_address"`
 	ConsulTemplateBePath    string   `long:"consul-template-be-path" description:"The path to the Consul Template representing snippet of the frontend configuration. If specified, proxy template will be loaded from the specified file." yaml:"consul_template_be_path" envconfig:"consul_template_be_path"`
 	ConsulTemplateFePath    string   `long:"consul-template-fe-path" description:"The path to the Consul Template representing snippet of the frontend configuration. If specified, proxy template will be loaded from the specified file." yaml:"consul_template_fe_path" envconfig:"consul_template_fe_path"`
 	Flow                    []string `short:"F" long:"flow" description:"The actions that should be performed as the flow. Multiple values are allowed.\ndeploy: Deploys a new release\nscale: Scales currently running release\nstop-old: Stops the old release\nproxy: Reconfigures the proxy\ntest:[TARGET]: Runs a test target specified through the test-docker-compose argument.\n" yaml:"flow" envconfig:"flow"`
-	Host                    string   `short:"H" long:"host" description:"Docker daemon socket to connect to. If not specified, DOCKER_HOST environment variable will be used instead."`
-	Project                 string   `short:"p" long:"project" description:"Docker Compose project. If not specified, the current directory will be used instead."`
+	HttpsOnly               bool     `long:"https-only" description:"Redirect HTTP requests to the service to HTTPS." yaml:"https_only" envconfig:"https_only"`
+	Host                    string   `short:"H" long:"host" description:"Docker daemon socket to connect to. If not specified, DOCKER_HOST environment variable will be used instead." yaml:"host" envconfig:"host"`
+	LogFormat               string   `long:"log-format" description:"Format of the output.\ntext: Human readable messages\njson: One JSON event per line with the run ID, service, step, color, level, duration and error\n" yaml:"log_format" envconfig:"log_format"`
+	MetricsPushgateway      string   `long:"metrics-pushgateway" description:"Address of the Prometheus Pushgateway (e.g. http://pushgateway:9091) the metrics of the flow are pushed to once it finishes. The metrics of each service are pushed to the docker_flow job grouped by the service label." yaml:"metrics_pushgateway" envconfig:"metrics_pushgateway"`
+	MetricsTextfile         string   `long:"metrics-textfile" description:"Path of the file (e.g. /var/lib/node_exporter/docker_flow.prom) the metrics of the flow are written to in the Prometheus text format for the node exporter textfile collector." yaml:"metrics_textfile" envconfig:"metrics_textfile"`
+	Profile                 string   `long:"profile" description:"Name of the profile from the Docker Flow file (e.g. staging) merged over its base configuration before environment variables and command line arguments are applied." yaml:"-" envconfig:"profile"`
+	Project                 string   `short:"p" long:"project" description:"Docker Compose project. If not specified, the current directory will be used instead." yaml:"project" envconfig:"project"`
 	ProxyDockerCertPath     string   `long:"proxy-docker-cert-path" description:"Docker certification path for the proxy host." yaml:"proxy_docker_cert_path" envconfig:"proxy_docker_cert_path"`
 	ProxyDockerHost         string   `long:"proxy-docker-host" description:"Docker daemon socket of the proxy host. This argument is required only if the proxy flow step is used." yaml:"proxy_docker_host" envconfig:"proxy_docker_host"`
+	ProxyEnv                []string `long:"proxy-env" description:"Additional environment variable (e.g. STATS_USER=admin) set to the proxy container when it is created. Multiple values are allowed." yaml:"proxy_env" envconfig:"proxy_env"`
+	ProxyFailPolicy         string   `long:"proxy-fail-policy" description:"Defines when the proxy step fails if multiple proxies are specified.\nany: Fails if any of the proxies could not be reconfigured\nquorum: Fails only if the majority of the proxies could not be reconfigured\n" yaml:"proxy_fail_policy" envconfig:"proxy_fail_policy"`
 	ProxyHost               string   `long:"proxy-host" description:"The host of the proxy. Visitors should request services from this domain. Docker Flow uses it to request reconfiguration when a new service is deployed or an existing one is scaled. This argument is required only if the proxy flow step is used." yaml:"proxy_host" envconfig:"proxy_host"`
+	ProxyImage              string   `long:"proxy-image" description:"Docker image (optionally with a tag) of the proxy. If the running proxy uses a different image, it will be replaced. If not specified, vfarcic/docker-flow-proxy will be used." yaml:"proxy_image" envconfig:"proxy_image"`
+	ProxyName               string   `long:"proxy-name" description:"Name of the proxy container. If not specified, docker-flow-proxy will be used." yaml:"proxy_name" envconfig:"proxy_name"`
+	ProxyNetwork            string   `long:"proxy-network" description:"Network the proxy container should be connected to when it is created." yaml:"proxy_network" envconfig:"proxy_network"`
+	ProxyParams             []string `long:"proxy-param" description:"Additional parameter (e.g. skipCheck=true) forwarded to the proxy reconfigure request. Multiple values are allowed." yaml:"proxy_params" envconfig:"proxy_params"`
+	ProxyPorts              []string `long:"proxy-port" description:"Additional port (e.g. 443:443) published by the proxy container when it is created. Ports 80 and the reconfigure port are always published. Multiple values are allowed." yaml:"proxy_ports" envconfig:"proxy_ports"`
 	ProxyReconfPort         string   `long:"proxy-reconf-port" description:"The port used by the proxy to reconfigure its configuration" yaml:"proxy_reconf_port" envconfig:"proxy_reconf_port"`
+	ProxyRestart            string   `long:"proxy-restart" description:"Restart policy (e.g. always) of the proxy container when it is created." yaml:"proxy_restart" envconfig:"proxy_restart"`
+	ProxyTimeout            int      `long:"proxy-timeout" description:"Number of seconds each proxy provisioning, reconfiguration or verification is allowed to take. If not specified, there is no timeout." yaml:"proxy_timeout" envconfig:"proxy_timeout"`
+	ProxyVerify             bool     `long:"proxy-verify" description:"Verify that the proxy routes requests to the new release after it is reconfigured. Each service path is requested through the proxy host until the response comes from the new color or the timeout is reached." yaml:"proxy_verify" envconfig:"proxy_verify"`
+	ProxyVerifyHeader       string   `long:"proxy-verify-header" description:"Response header that contains the color of the release that served the request. If not specified, the proxy configuration is checked instead." yaml:"proxy_verify_header" envconfig:"proxy_verify_header"`
+	ProxyVerifyTimeout      int      `long:"proxy-verify-timeout" description:"Number of seconds to wait for the proxy to route requests to the new release. Defaults to 30." yaml:"proxy_verify_timeout" envconfig:"proxy_verify_timeout"`
+	ProxyVolumes            []string `long:"proxy-volume" description:"Volume (e.g. /etc/ssl/certs:/certs) mounted to the proxy container when it is created. Multiple values are allowed." yaml:"proxy_volumes" envconfig:"proxy_volumes"`
 	PullSideTargets         bool     `short:"S" long:"pull-side-targets" description:"Pull side or auxiliary targets." yaml:"pull_side_targets" envconfig:"pull_side_targets"`
+	Report                  string   `long:"report" description:"Path of the JSON file the report of the flow is written to once it finishes. It contains the resolved options, colors, targets, final scale, steps with their status and duration, proxy URLs called and errors." yaml:"report" envconfig:"report"`
+	ReportJunit             string   `long:"report-junit" description:"Path of the JUnit XML file the results of the test steps are written to once the flow finishes." yaml:"report_junit" envconfig:"report_junit"`
+	Resume                  bool     `long:"resume" description:"Continue the last unfinished flow of the service from the step that failed. The colors and targets of that flow are reused instead of being calculated again." yaml:"-" envconfig:"resume"`
+	RetryAttempts           int      `long:"retry-attempts" description:"Maximum number of attempts of image pulls, Consul requests and proxy reconfigure requests. Defaults to 1 (no retries)." yaml:"retry_attempts" envconfig:"retry_attempts"`
+	RetryDelay              int      `long:"retry-delay" description:"Number of milliseconds to wait before the first retry. The delay doubles with each following retry. Defaults to 1000." yaml:"retry_delay" envconfig:"retry_delay"`
+	RetryMaxDelay           int      `long:"retry-max-delay" description:"Maximum number of milliseconds to wait between retries. Defaults to 30000." yaml:"retry_max_delay" envconfig:"retry_max_delay"`
+	RetryOn                 []string `long:"retry-on" description:"Retry only errors which contain the given text (e.g. timeout). Multiple values are allowed. If not specified, all errors except cancellations and client errors returned by the proxy are retried." yaml:"retry_on" envconfig:"retry_on"`
 	Scale                   string   `short:"s" long:"scale" description:"Number of instances to deploy. If the value starts with the plus sign (+), the number of instances will be increased by the given number. If the value begins with the minus sign (-), the number of instances will be decreased by the given number." yaml:"scale" envconfig:"scale"`
-	ServicePath             []string `long:"service-path" description:"Path that should be configured in the proxy (e.g. /api/v1/my-service). This argument is required only if the proxy flow step is used." yaml:"service_path"`
-	SideTargets             []string `short:"T" long:"side-target" description:"Side or auxiliary Docker Compose targets. Multiple values are allowed." yaml:"side_targets"`
-	Target                  string   `short:"t" long:"target" description:"Docker Compose target."`
+	ServiceCertPath         string   `long:"service-cert-path" description:"Path to the PEM certificate (including the private key) that should be uploaded to the proxy before it is reconfigured." yaml:"service_cert_path" envconfig:"service_cert_path"`
+	ServiceDomain           []string `long:"service-domain" description:"Domain of the service (e.g. my-service.com). If specified, the proxy will route only requests with that host to the service. Multiple values are allowed." yaml:"service_domain" envconfig:"service_domain"`
+	ServicePath             []string `long:"service-path" description:"Path that should be configured in the proxy (e.g. /api/v1/my-service). This argument is required only if the proxy flow step is used." yaml:"service_path" envconfig:"service_path"`
+	ServicePathType         string   `long:"service-path-type" description:"Defines how the proxy matches the service path.\nprefix: Requests starting with the service path are forwarded to the service\nregex: Requests matching the service path regular expression are forwarded to the service\n" yaml:"service_path_type" envconfig:"service_path_type"`
+	SideTargets             []string `short:"T" long:"side-target" description:"Side or auxiliary Docker Compose targets. Multiple values are allowed." yaml:"side_targets" envconfig:"side_targets"`
+	StepTimeout             int      `long:"step-timeout" description:"Number of seconds each step is allowed to take unless the pipeline stage specifies its own timeout. If not specified, there is no timeout." yaml:"step_timeout" envconfig:"step_timeout"`
+	StrictConfig            bool     `long:"strict-config" description:"Fail if the Docker Flow file contains unknown options." yaml:"-" envconfig:"strict_config"`
+	Target                  string   `short:"t" long:"target" description:"Docker Compose target." yaml:"target" envconfig:"target"`
 	TestComposePath         string   `long:"test-compose-path" description:"Path to the Docker Compose configuration file used for tests. If not specified, the default docker-compose.yml files will be used." yaml:"test_compose_path" envconfig:"test_compose_path"`
+	Timeout                 int      `long:"timeout" description:"Number of seconds the whole flow is allowed to take. Running commands and requests are stopped once it is reached. If not specified, there is no timeout." yaml:"timeout" envconfig:"timeout"`
 	ServiceName             string
 	CurrentColor            string
 	NextColor               string
 	CurrentTarget           string
 	NextTarget              string
+	FlowPath                string
 	ConsulTemplateFe        string
 	ConsulTemplateBe        string
+	Proxies                 []ProxyInstance   `yaml:"proxies" ignored:"true"`
+	Pipeline                []Stage           `yaml:"pipeline" ignored:"true"`
+	Hooks                   map[string][]Hook `yaml:"hooks" ignored:"true"`
+	Services                []Service         `yaml:"services" ignored:"true"`
+	Webhooks                []Webhook         `yaml:"webhooks" ignored:"true"`
+	Sources                 map[string]string `yaml:"-" ignored:"true"`
 }
 
 var GetOpts = func() (Opts, error) {
+	opts, err := LoadOpts()
+	if err != nil {
+		return opts, err
+	}
+	if err := processOpts(&opts); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// LoadOpts merges the Docker Flow file, environment variables and command line arguments without processing the result.
+func LoadOpts() (Opts, error) {
 	opts := Opts{
 		ComposePath: dockerComposePath,
 		Flow:        []string{"deploy"},
+		Sources:     map[string]string{},
 	}
+	getConfigArgs(&opts)
 	if err := parseYml(&opts); err != nil {
 		return opts, err
 	}
@@ -63,27 +121,56 @@ var GetOpts = func() (Opts, error) {
 	if err := parseArgs(&opts); err != nil {
 		return opts, err
 	}
-	if err := processOpts(&opts); err != nil {
-		return opts, err
-	}
 	return opts, nil
 }
 
 func ParseYml(opts *Opts) error {
-	data, err := util.ReadFile(dockerFlowPath)
+	path := opts.Config
+	if len(path) == 0 {
+		path = dockerFlowPath
+	}
+	data, err := util.ReadFile(path)
 	if err != nil {
+		if len(opts.Config) > 0 || len(opts.Profile) > 0 {
+			return fmt.Errorf("Could not read the Docker Flow file %s\n%s", path, err.Error())
+		}
 		return nil
 	}
-	if err = yaml.Unmarshal([]byte(data), opts); err != nil {
-		return fmt.Errorf("Could not parse the Docker Flow file %s\n%s", dockerFlowPath, err.Error())
+	config, err := parseConfig(path, data, opts.Profile)
+	if err != nil {
+		return fmt.Errorf("Could not parse the Docker Flow file %s\n%s", path, err.Error())
+	}
+	if data, err = yaml.Marshal(config); err != nil {
+		return fmt.Errorf("Could not parse the Docker Flow file %s\n%s", path, err.Error())
+	}
+	unmarshal := yaml.Unmarshal
+	if opts.StrictConfig {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	if err = unmarshal(data, opts); err != nil {
+		return fmt.Errorf("Could not parse the Docker Flow file %s\n%s", path, err.Error())
+	}
+	for _, option := range getOptions() {
+		if _, ok := config[option.Name]; ok {
+			setOptSource(opts, option.Name, OptSourceFile)
+		}
 	}
 	return nil
 }
 
 func ParseArgs(opts *Opts) error {
-	if _, err := flags.ParseArgs(opts, os.Args[1:]); err != nil {
+	parser := flags.NewParser(opts, flags.Default)
+	if _, err := parser.ParseArgs(os.Args[1:]); err != nil {
 		return fmt.Errorf("Could not parse command line arguments\n%s", err.Error())
 	}
+	for _, option := range getOptions() {
+		if len(option.Long) == 0 {
+			continue
+		}
+		if flag := parser.FindOptionByLongName(option.Long); flag != nil && flag.IsSet() {
+			setOptSource(opts, option.Name, OptSourceFlag)
+		}
+	}
 	return nil
 }
 
@@ -91,30 +178,26 @@ func ParseEnvVars(opts *Opts) error {
 	if err := envconfig.Process("flow", opts); err != nil {
 		return fmt.Errorf("Could not retrieve environment variables\n%s", err.Error())
 	}
-	data := []struct {
-		key   string
-		value *[]string
-	}{
-		{"FLOW_SIDE_TARGETS", &opts.SideTargets},
-		{"FLOW", &opts.Flow},
-		{"FLOW_SERVICE_PATH", &opts.ServicePath},
-	}
-	for _, d := range data {
-		value := strings.Trim(os.Getenv(d.key), " ")
-		if len(value) > 0 {
-			*d.value = strings.Split(value, ",")
+	for _, option := range getOptions() {
+		if _, ok := os.LookupEnv(option.Env); ok && len(option.Env) > 0 {
+			setOptSource(opts, option.Name, OptSourceEnv)
+		}
+	}
+	// FLOW is still accepted for backwards compatibility
+	if _, ok := os.LookupEnv("FLOW_FLOW"); !ok {
+		if value := strings.Trim(os.Getenv("FLOW"), " "); len(value) > 0 {
+			opts.Flow = strings.Split(value, ",")
+			setOptSource(opts, "flow", OptSourceEnv)
 		}
 	}
 	return nil
 }
 
 func ProcessOpts(opts *Opts) (err error) {
-	sc := getServiceDiscovery()
-	if len(opts.Project) == 0 {
-		dir, _ := getWd()
-		opts.Project = dir[strings.LastIndex(dir, string(os.PathSeparator))+1:]
-	}
-	if len(opts.Target) == 0 {
+	SetDefaultOpts(opts)
+	if len(opts.Services) > 0 && len(opts.Target) > 0 {
+		return fmt.Errorf("target argument cannot be used together with services")
+	} else if len(opts.Services) == 0 && len(opts.Target) == 0 {
 		return fmt.Errorf("target argument is required")
 	}
 	if len(opts.ServiceDiscoveryAddress) == 0 {
@@ -125,6 +208,9 @@ func ProcessOpts(opts *Opts) (err error) {
 			return fmt.Errorf("scale must be a number or empty")
 		}
 	}
+	if opts.Timeout < 0 || opts.StepTimeout < 0 {
+		return fmt.Errorf("timeout and step-timeout must not be negative")
+	}
 	if len(opts.ConsulTemplateFePath) > 0 {
 		data, err := util.ReadFile(opts.ConsulTemplateFePath)
 		if err != nil {
@@ -139,17 +225,51 @@ func ProcessOpts(opts *Opts) (err error) {
 		}
 		opts.ConsulTemplateBe = string(data)
 	}
+	if len(opts.Pipeline) > 0 {
+		if err := pipeline.Validate(opts.Pipeline); err != nil {
+			return err
+		}
+		opts.Flow = []string{}
+		for _, stage := range opts.Pipeline {
+			opts.Flow = append(opts.Flow, stage.Type)
+		}
+	}
 	if len(opts.Flow) == 0 {
 		opts.Flow = []string{"deploy"}
 	}
-	if len(opts.ServiceName) == 0 {
+	if err := ValidateHooks(opts.Hooks); err != nil {
+		return err
+	}
+	if err := ValidateWebhooks(opts.Webhooks); err != nil {
+		return err
+	}
+	if len(opts.ServiceName) == 0 && len(opts.Target) > 0 {
 		opts.ServiceName = fmt.Sprintf("%s-%s", opts.Project, opts.Target)
 	}
-	if len(opts.ProxyReconfPort) == 0 {
-		opts.ProxyReconfPort = strconv.Itoa(ProxyReconfigureDefaultPort)
+	if len(opts.ServicePathType) > 0 && opts.ServicePathType != ProxyPathTypePrefix && opts.ServicePathType != ProxyPathTypeRegex {
+		return fmt.Errorf("service-path-type must be %s or %s", ProxyPathTypePrefix, ProxyPathTypeRegex)
 	}
-	if opts.CurrentColor, err = sc.GetColor(opts.ServiceDiscoveryAddress, opts.ServiceName); err != nil {
-		return err
+	if opts.ProxyFailPolicy != ProxyFailPolicyAny && opts.ProxyFailPolicy != ProxyFailPolicyQuorum {
+		return fmt.Errorf("proxy-fail-policy must be %s or %s", ProxyFailPolicyAny, ProxyFailPolicyQuorum)
+	}
+	if opts.LogFormat != LogFormatText && opts.LogFormat != LogFormatJson {
+		return fmt.Errorf("log-format must be %s or %s", LogFormatText, LogFormatJson)
+	}
+	if len(opts.Services) > 0 {
+		// Colors and targets of each service are resolved when its flow starts
+		return ValidateServices(opts.Services)
+	}
+	ctx, cancel := getRequestContext(context.Background(), time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+	ctx = util.WithRetryPolicy(ctx, getRetryPolicy(*opts))
+	return setColorsAndTargets(ctx, opts)
+}
+
+// SetDefaultOpts sets the values of options that were not specified.
+func SetDefaultOpts(opts *Opts) {
+	if len(opts.Project) == 0 {
+		dir, _ := getWd()
+		opts.Project = dir[strings.LastIndex(dir, string(os.PathSeparator))+1:]
 	}
 	if len(opts.Host) == 0 {
 		opts.Host = os.Getenv("DOCKER_HOST")
@@ -157,6 +277,34 @@ func ProcessOpts(opts *Opts) (err error) {
 	if len(opts.CertPath) == 0 {
 		opts.CertPath = os.Getenv("DOCKER_CERT_PATH")
 	}
+	if len(opts.ProxyReconfPort) == 0 {
+		opts.ProxyReconfPort = strconv.Itoa(ProxyReconfigureDefaultPort)
+	}
+	if opts.ProxyVerifyTimeout <= 0 {
+		opts.ProxyVerifyTimeout = ProxyVerifyDefaultTimeout
+	}
+	if len(opts.ProxyFailPolicy) == 0 {
+		opts.ProxyFailPolicy = ProxyFailPolicyAny
+	}
+	if len(opts.LogFormat) == 0 {
+		opts.LogFormat = LogFormatText
+	}
+	if opts.RetryAttempts <= 0 {
+		opts.RetryAttempts = 1
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = RetryDefaultDelay
+	}
+	if opts.RetryMaxDelay <= 0 {
+		opts.RetryMaxDelay = RetryDefaultMaxDelay
+	}
+}
+
+func setColorsAndTargets(ctx context.Context, opts *Opts) (err error) {
+	sc := getServiceDiscovery()
+	if opts.CurrentColor, err = sc.GetColor(ctx, opts.ServiceDiscoveryAddress, opts.ServiceName); err != nil {
+		return err
+	}
 	opts.NextColor = sc.GetNextColor(opts.CurrentColor)
 	if opts.BlueGreen {
 		opts.NextTarget = fmt.Sprintf("%s-%s", opts.Target, opts.NextColor)
@@ -167,3 +315,12 @@ func ProcessOpts(opts *Opts) (err error) {
 	}
 	return nil
 }
+
+func getRetryPolicy(opts Opts) util.RetryPolicy {
+	return util.RetryPolicy{
+		Attempts: opts.RetryAttempts,
+		Delay:    time.Duration(opts.RetryDelay) * time.Millisecond,
+		MaxDelay: time.Duration(opts.RetryMaxDelay) * time.Millisecond,
+		RetryOn:  opts.RetryOn,
+	}
+}
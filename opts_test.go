@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
@@ -72,6 +73,43 @@ func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenTargetIsEmpty() {
 	s.Error(actual)
 }
 
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsNil_WhenTargetIsEmptyAndServicesAreSpecified() {
+	s.opts.Target = ""
+	s.opts.Services = []Service{{Target: "db"}, {Target: "api", DependsOn: []string{"db"}}}
+
+	actual := ProcessOpts(&s.opts)
+
+	s.NoError(actual)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenTargetAndServicesAreSpecified() {
+	s.opts.Services = []Service{{Target: "db"}}
+
+	actual := ProcessOpts(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenServicesAreInvalid() {
+	s.opts.Target = ""
+	s.opts.Services = []Service{{Target: "api", DependsOn: []string{"db"}}}
+
+	actual := ProcessOpts(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_DoesNotInvokeGetColor_WhenServicesAreSpecified() {
+	mockObj := getServiceDiscoveryMock(s.opts, "")
+	serviceDiscovery = mockObj
+	s.opts.Target = ""
+	s.opts.Services = []Service{{Target: "db"}}
+
+	ProcessOpts(&s.opts)
+
+	mockObj.AssertNotCalled(s.T(), "GetColor", mock.Anything, mock.Anything, mock.Anything)
+}
+
 func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenServiceDiscoveryAddressIsEmpty() {
 	s.opts.ServiceDiscoveryAddress = ""
 
@@ -91,7 +129,7 @@ func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenScaleIsNotNumber() {
 func (s OptsTestSuite) Test_ProcessOpts_SetsServiceNameToProjectAndTarget() {
 	expected := fmt.Sprintf("%s-%s", s.opts.Project, s.opts.Target)
 	mockObj := getServiceDiscoveryMock(s.opts, "GetColor")
-	mockObj.On("GetColor", mock.Anything, mock.Anything).Return("orange", fmt.Errorf("This is an error"))
+	mockObj.On("GetColor", mock.Anything, mock.Anything, mock.Anything).Return("orange", fmt.Errorf("This is an error"))
 	serviceDiscovery = mockObj
 	s.opts.ServiceName = ""
 
@@ -107,6 +145,84 @@ func (s OptsTestSuite) Test_ProcessOpts_SetsPortTo8080_WhenEmpty() {
 	s.Equal(strconv.Itoa(ProxyReconfigureDefaultPort), s.opts.ProxyReconfPort)
 }
 
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenServicePathTypeIsUnknown() {
+	s.opts.ServicePathType = "suffix"
+
+	actual := ProcessOpts(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenTimeoutIsNegative() {
+	s.opts.Timeout = -1
+
+	s.Error(ProcessOpts(&s.opts))
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenStepTimeoutIsNegative() {
+	s.opts.StepTimeout = -1
+
+	s.Error(ProcessOpts(&s.opts))
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_SetsProxyVerifyTimeoutToDefault_WhenEmpty() {
+	s.opts.ProxyVerifyTimeout = 0
+
+	ProcessOpts(&s.opts)
+
+	s.Equal(ProxyVerifyDefaultTimeout, s.opts.ProxyVerifyTimeout)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_SetsRetryDefaults_WhenEmpty() {
+	s.opts.RetryAttempts = 0
+	s.opts.RetryDelay = 0
+	s.opts.RetryMaxDelay = 0
+
+	ProcessOpts(&s.opts)
+
+	s.Equal(1, s.opts.RetryAttempts)
+	s.Equal(RetryDefaultDelay, s.opts.RetryDelay)
+	s.Equal(RetryDefaultMaxDelay, s.opts.RetryMaxDelay)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_SetsProxyFailPolicyToAny_WhenEmpty() {
+	s.opts.ProxyFailPolicy = ""
+
+	ProcessOpts(&s.opts)
+
+	s.Equal(ProxyFailPolicyAny, s.opts.ProxyFailPolicy)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenWebhooksAreInvalid() {
+	s.opts.Webhooks = []Webhook{{Method: "POST"}}
+
+	actual := ProcessOpts(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_SetsLogFormatToText_WhenEmpty() {
+	ProcessOpts(&s.opts)
+
+	s.Equal(LogFormatText, s.opts.LogFormat)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenLogFormatIsUnknown() {
+	s.opts.LogFormat = "xml"
+
+	actual := ProcessOpts(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenProxyFailPolicyIsUnknown() {
+	s.opts.ProxyFailPolicy = "sometimes"
+
+	actual := ProcessOpts(&s.opts)
+
+	s.Error(actual)
+}
+
 func (s OptsTestSuite) Test_ProcessOpts_DoesNotSetServiceNameWhenNotEmpty() {
 	expected := s.opts.ServiceName
 
@@ -116,7 +232,7 @@ func (s OptsTestSuite) Test_ProcessOpts_DoesNotSetServiceNameWhenNotEmpty() {
 }
 
 func (s OptsTestSuite) Test_ProcessOpts_SetsCurrentColorFromServiceDiscovery() {
-	expected, _ := serviceDiscovery.GetColor(s.opts.ServiceDiscoveryAddress, s.opts.ServiceName)
+	expected, _ := serviceDiscovery.GetColor(context.Background(), s.opts.ServiceDiscoveryAddress, s.opts.ServiceName)
 
 	ProcessOpts(&s.opts)
 
@@ -125,7 +241,7 @@ func (s OptsTestSuite) Test_ProcessOpts_SetsCurrentColorFromServiceDiscovery() {
 
 func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenGetColorFails() {
 	mockObj := getServiceDiscoveryMock(s.opts, "GetColor")
-	mockObj.On("GetColor", mock.Anything, mock.Anything).Return("orange", fmt.Errorf("This is an error"))
+	mockObj.On("GetColor", mock.Anything, mock.Anything, mock.Anything).Return("orange", fmt.Errorf("This is an error"))
 	serviceDiscovery = mockObj
 
 	actual := ProcessOpts(&s.opts)
@@ -222,6 +338,31 @@ func (s OptsTestSuite) Test_ProcessOpts_SetsFlowToDeploy_WhenEmpty() {
 	s.Equal(expected, s.opts.Flow)
 }
 
+func (s OptsTestSuite) Test_ProcessOpts_SetsFlowFromPipeline() {
+	s.opts.Flow = []string{"deploy"}
+	s.opts.Pipeline = []Stage{{Type: FLOW_DEPLOY}, {Type: FLOW_SHELL}, {Type: FLOW_PROXY}}
+
+	ProcessOpts(&s.opts)
+
+	s.Equal([]string{FLOW_DEPLOY, FLOW_SHELL, FLOW_PROXY}, s.opts.Flow)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenPipelineIsInvalid() {
+	s.opts.Pipeline = []Stage{{Type: "unknown"}}
+
+	actual := ProcessOpts(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenHooksAreInvalid() {
+	s.opts.Hooks = map[string][]Hook{"during_deploy": {{Command: "./migrate.sh"}}}
+
+	actual := ProcessOpts(&s.opts)
+
+	s.Error(actual)
+}
+
 func (s OptsTestSuite) Test_ProcessOpts_ReturnsError_WhenConsulTemplateFeFileDoesNotExist() {
 	s.opts.ConsulTemplateFePath = "/this/path/does/not/exist"
 	util.ReadFile = func(fileName string) ([]byte, error) {
@@ -279,6 +420,7 @@ func (s OptsTestSuite) Test_ParseEnvVars_Strings() {
 		{"myHost", "FLOW_HOST", &s.opts.Host},
 		{"myCertPath", "FLOW_CERT_PATH", &s.opts.CertPath},
 		{"myComposePath", "FLOW_COMPOSE_PATH", &s.opts.ComposePath},
+		{"myConfig", "FLOW_CONFIG", &s.opts.Config},
 		{"myTarget", "FLOW_TARGET", &s.opts.Target},
 		{"myProject", "FLOW_PROJECT", &s.opts.Project},
 		{"mySDAddress", "FLOW_CONSUL_ADDRESS", &s.opts.ServiceDiscoveryAddress},
@@ -290,6 +432,13 @@ func (s OptsTestSuite) Test_ParseEnvVars_Strings() {
 		{"myConsulTemplateFePath", "FLOW_CONSUL_TEMPLATE_FE_PATH", &s.opts.ConsulTemplateFePath},
 		{"myConsulTemplateBePath", "FLOW_CONSUL_TEMPLATE_BE_PATH", &s.opts.ConsulTemplateBePath},
 		{"myTestComposePath", "FLOW_TEST_COMPOSE_PATH", &s.opts.TestComposePath},
+		{"myProxyImage", "FLOW_PROXY_IMAGE", &s.opts.ProxyImage},
+		{"myProxyName", "FLOW_PROXY_NAME", &s.opts.ProxyName},
+		{"myProxyRestart", "FLOW_PROXY_RESTART", &s.opts.ProxyRestart},
+		{"myProxyNetwork", "FLOW_PROXY_NETWORK", &s.opts.ProxyNetwork},
+		{"myProxyVerifyHeader", "FLOW_PROXY_VERIFY_HEADER", &s.opts.ProxyVerifyHeader},
+		{"myServiceCertPath", "FLOW_SERVICE_CERT_PATH", &s.opts.ServiceCertPath},
+		{"regex", "FLOW_SERVICE_PATH_TYPE", &s.opts.ServicePathType},
 	}
 	for _, d := range data {
 		os.Setenv(d.key, d.expected)
@@ -307,6 +456,9 @@ func (s OptsTestSuite) Test_ParseEnvVars_Bools() {
 	}{
 		{"FLOW_BLUE_GREEN", &s.opts.BlueGreen},
 		{"FLOW_PULL_SIDE_TARGETS", &s.opts.PullSideTargets},
+		{"FLOW_PROXY_VERIFY", &s.opts.ProxyVerify},
+		{"FLOW_HTTPS_ONLY", &s.opts.HttpsOnly},
+		{"FLOW_STRICT_CONFIG", &s.opts.StrictConfig},
 	}
 	for _, d := range data {
 		os.Setenv(d.key, "true")
@@ -326,6 +478,12 @@ func (s OptsTestSuite) Test_ParseEnvVars_Slices() {
 		{"myTarget1,myTarget2", "FLOW_SIDE_TARGETS", &s.opts.SideTargets},
 		{"deploy,stop-old", "FLOW", &s.opts.Flow},
 		{"path1,path2", "FLOW_SERVICE_PATH", &s.opts.ServicePath},
+		{"443:443,8081:8081", "FLOW_PROXY_PORTS", &s.opts.ProxyPorts},
+		{"KEY1=value1,KEY2=value2", "FLOW_PROXY_ENV", &s.opts.ProxyEnv},
+		{"/certs:/certs,/logs:/logs", "FLOW_PROXY_VOLUMES", &s.opts.ProxyVolumes},
+		{"skipCheck=true,distribute=true", "FLOW_PROXY_PARAMS", &s.opts.ProxyParams},
+		{"my-domain.com,my-other-domain.com", "FLOW_SERVICE_DOMAIN", &s.opts.ServiceDomain},
+		{"timeout,connection refused", "FLOW_RETRY_ON", &s.opts.RetryOn},
 	}
 	for _, d := range data {
 		os.Setenv(d.key, d.expected)
@@ -362,6 +520,33 @@ func (s OptsTestSuite) Test_ParseEnvVars_ReturnsError_WhenFailure() {
 	os.Unsetenv("FLOW_BLUE_GREEN")
 }
 
+func (s OptsTestSuite) Test_ParseEnvVars_PrefersFlowFlowOverFlow() {
+	os.Setenv("FLOW", "deploy,stop-old")
+	os.Setenv("FLOW_FLOW", "deploy,proxy")
+	defer func() {
+		os.Unsetenv("FLOW")
+		os.Unsetenv("FLOW_FLOW")
+	}()
+
+	ParseEnvVars(&s.opts)
+
+	s.Equal([]string{"deploy", "proxy"}, s.opts.Flow)
+}
+
+func (s OptsTestSuite) Test_ParseEnvVars_SetsSources() {
+	os.Setenv("FLOW_TEST_COMPOSE_PATH", "myTestComposePath")
+	os.Setenv("FLOW_PROXY_PORTS", "443:443")
+	defer func() {
+		os.Unsetenv("FLOW_TEST_COMPOSE_PATH")
+		os.Unsetenv("FLOW_PROXY_PORTS")
+	}()
+
+	ParseEnvVars(&s.opts)
+
+	s.Equal(OptSourceEnv, s.opts.Sources["test_compose_path"])
+	s.Equal(OptSourceEnv, s.opts.Sources["proxy_ports"])
+}
+
 // ParseArgs
 
 func (s OptsTestSuite) Test_ParseArgs_LongStrings() {
@@ -384,6 +569,13 @@ func (s OptsTestSuite) Test_ParseArgs_LongStrings() {
 		{"consulTemplateFePathFromArgs", "consul-template-fe-path", &s.opts.ConsulTemplateFePath},
 		{"consulTemplateBePathFromArgs", "consul-template-be-path", &s.opts.ConsulTemplateBePath},
 		{"testComposePathFromArgs", "test-compose-path", &s.opts.TestComposePath},
+		{"proxyImageFromArgs", "proxy-image", &s.opts.ProxyImage},
+		{"proxyNameFromArgs", "proxy-name", &s.opts.ProxyName},
+		{"proxyRestartFromArgs", "proxy-restart", &s.opts.ProxyRestart},
+		{"proxyNetworkFromArgs", "proxy-network", &s.opts.ProxyNetwork},
+		{"proxyVerifyHeaderFromArgs", "proxy-verify-header", &s.opts.ProxyVerifyHeader},
+		{"serviceCertPathFromArgs", "service-cert-path", &s.opts.ServiceCertPath},
+		{"regex", "service-path-type", &s.opts.ServicePathType},
 	}
 
 	for _, d := range data {
@@ -393,6 +585,14 @@ func (s OptsTestSuite) Test_ParseArgs_LongStrings() {
 	}
 }
 
+func (s OptsTestSuite) Test_ParseArgs_SetsSources() {
+	os.Args = []string{"myProgram", "--proxy-timeout=10", "-T", "db"}
+
+	ParseArgs(&s.opts)
+
+	s.Equal(map[string]string{"proxy_timeout": OptSourceFlag, "side_targets": OptSourceFlag}, s.opts.Sources)
+}
+
 func (s OptsTestSuite) Test_ParseArgs_ParsesLongSlices() {
 	os.Args = []string{"myProgram"}
 	data := []struct {
@@ -445,6 +645,7 @@ func (s OptsTestSuite) TestParseArgs_LongBools() {
 	}{
 		{"blue-green", &s.opts.BlueGreen},
 		{"pull-side-targets", &s.opts.PullSideTargets},
+		{"resume", &s.opts.Resume},
 	}
 
 	for _, d := range data {
@@ -478,6 +679,12 @@ func (s OptsTestSuite) TestParseArgs_LongSlices() {
 	}{
 		{[]string{"target1", "target2"}, "side-target", &s.opts.SideTargets},
 		{[]string{"deploy", "stop-old"}, "flow", &s.opts.Flow},
+		{[]string{"443:443", "8081:8081"}, "proxy-port", &s.opts.ProxyPorts},
+		{[]string{"KEY1=value1", "KEY2=value2"}, "proxy-env", &s.opts.ProxyEnv},
+		{[]string{"/certs:/certs", "/logs:/logs"}, "proxy-volume", &s.opts.ProxyVolumes},
+		{[]string{"skipCheck=true", "distribute=true"}, "proxy-param", &s.opts.ProxyParams},
+		{[]string{"my-domain.com", "my-other-domain.com"}, "service-domain", &s.opts.ServiceDomain},
+		{[]string{"timeout", "connection refused"}, "retry-on", &s.opts.RetryOn},
 	}
 
 	for _, d := range data {
@@ -625,6 +832,488 @@ consul_template_be_path: %s`,
 	s.Equal(consulTemplateBePath, s.opts.ConsulTemplateBePath)
 }
 
+func (s OptsTestSuite) Test_ParseYml_SetsProxies() {
+	yml := `
+proxy_fail_policy: quorum
+proxies:
+  - host: proxy1.example.com
+    docker_host: tcp://proxy1:2376
+    docker_cert_path: /certs/proxy1
+    reconf_port: 8081
+  - host: proxy2.example.com
+    docker_host: tcp://proxy2:2376`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	expected := []ProxyInstance{
+		{Host: "proxy1.example.com", DockerHost: "tcp://proxy1:2376", DockerCertPath: "/certs/proxy1", ReconfPort: "8081"},
+		{Host: "proxy2.example.com", DockerHost: "tcp://proxy2:2376"},
+	}
+
+	ParseYml(&s.opts)
+
+	s.Equal(ProxyFailPolicyQuorum, s.opts.ProxyFailPolicy)
+	s.Equal(expected, s.opts.Proxies)
+}
+
+func (s OptsTestSuite) Test_ParseYml_SetsPipeline() {
+	yml := `
+pipeline:
+  - name: deploy-new
+    type: deploy
+    timeout: 300
+  - type: shell
+    params:
+      command: ./integration-tests.sh
+  - type: notify
+    when: always
+    params:
+      url: http://hooks.example.com`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	expected := []Stage{
+		{Name: "deploy-new", Type: FLOW_DEPLOY, Timeout: 300},
+		{Type: FLOW_SHELL, Params: map[string]string{"command": "./integration-tests.sh"}},
+		{Type: FLOW_NOTIFY, When: StageWhenAlways, Params: map[string]string{"url": "http://hooks.example.com"}},
+	}
+
+	ParseYml(&s.opts)
+
+	s.Equal(expected, s.opts.Pipeline)
+}
+
+func (s OptsTestSuite) Test_ParseYml_SetsHooks() {
+	yml := `
+hooks:
+  before_deploy:
+    - service: migrations
+  after_proxy:
+    - name: purge-cdn
+      command: ./purge-cdn.sh
+      ignore_failure: true`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	expected := map[string][]Hook{
+		"before_deploy": {{Service: "migrations"}},
+		"after_proxy":   {{Name: "purge-cdn", Command: "./purge-cdn.sh", IgnoreFailure: true}},
+	}
+
+	ParseYml(&s.opts)
+
+	s.Equal(expected, s.opts.Hooks)
+}
+
+func (s OptsTestSuite) Test_ParseYml_MergesProfile() {
+	yml := `
+target: app
+scale: 2
+service_path:
+  - /api
+hooks:
+  before_deploy:
+    - service: migrations
+profiles:
+  production:
+    scale: 5
+    service_path:
+      - /api/v1
+    hooks:
+      after_proxy:
+        - command: ./purge-cdn.sh`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	s.opts.Profile = "production"
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("app", s.opts.Target)
+	s.Equal("5", s.opts.Scale)
+	s.Equal([]string{"/api/v1"}, s.opts.ServicePath)
+	s.Equal(map[string][]Hook{
+		"before_deploy": {{Service: "migrations"}},
+		"after_proxy":   {{Command: "./purge-cdn.sh"}},
+	}, s.opts.Hooks)
+}
+
+func (s OptsTestSuite) Test_ParseYml_IgnoresProfiles_WhenProfileIsNotSelected() {
+	yml := `
+scale: 2
+profiles:
+  production:
+    scale: 5`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	s.opts.Profile = ""
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("2", s.opts.Scale)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsError_WhenProfileIsNotDefined() {
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte("profiles:\n  staging:\n    scale: 1"), nil
+	}
+	s.opts.Profile = "production"
+
+	actual := ParseYml(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsError_WhenProfileIsSelectedAndReadFileFails() {
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(""), fmt.Errorf("This is an error")
+	}
+	s.opts.Profile = "production"
+
+	actual := ParseYml(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ParseYml_InterpolatesEnvVars() {
+	yml := `
+target: app-${FLOW_TEST_TAG}
+scale: ${FLOW_TEST_SCALE:-3}
+timeout: ${FLOW_TEST_TIMEOUT}
+blue_green: ${FLOW_TEST_BLUE_GREEN}
+service_path:
+  - /api/${FLOW_TEST_VERSION:-v1}`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	os.Setenv("FLOW_TEST_TAG", "1.2")
+	os.Setenv("FLOW_TEST_TIMEOUT", "60")
+	os.Setenv("FLOW_TEST_BLUE_GREEN", "true")
+	defer func() {
+		os.Unsetenv("FLOW_TEST_TAG")
+		os.Unsetenv("FLOW_TEST_TIMEOUT")
+		os.Unsetenv("FLOW_TEST_BLUE_GREEN")
+	}()
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("app-1.2", s.opts.Target)
+	s.Equal("3", s.opts.Scale)
+	s.Equal(60, s.opts.Timeout)
+	s.True(s.opts.BlueGreen)
+	s.Equal([]string{"/api/v1"}, s.opts.ServicePath)
+}
+
+func (s OptsTestSuite) Test_ParseYml_KeepsInterpolatedValuesOfStringOptionsAsTheyAre() {
+	yml := `
+target: ${FLOW_TEST_NUMBER}
+proxy_image: ${FLOW_TEST_NUMBER}
+service_path:
+  - ${FLOW_TEST_BOOL}
+pipeline:
+  - type: wait
+    name: ${FLOW_TEST_NUMBER}
+    timeout: ${FLOW_TEST_TIMEOUT}
+    params:
+      seconds: 5
+services:
+  - name: app
+    blue_green: ${FLOW_TEST_BOOL}
+    scale: ${FLOW_TEST_NUMBER}`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	os.Setenv("FLOW_TEST_NUMBER", "0123")
+	os.Setenv("FLOW_TEST_BOOL", "true")
+	os.Setenv("FLOW_TEST_TIMEOUT", "60")
+	defer func() {
+		os.Unsetenv("FLOW_TEST_NUMBER")
+		os.Unsetenv("FLOW_TEST_BOOL")
+		os.Unsetenv("FLOW_TEST_TIMEOUT")
+	}()
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("0123", s.opts.Target)
+	s.Equal("0123", s.opts.ProxyImage)
+	s.Equal([]string{"true"}, s.opts.ServicePath)
+	s.Equal("0123", s.opts.Pipeline[0].Name)
+	s.Equal(60, s.opts.Pipeline[0].Timeout)
+	s.True(*s.opts.Services[0].BlueGreen)
+	s.Equal("0123", s.opts.Services[0].Scale)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsErrorListingUnresolvedEnvVars() {
+	yml := `
+target: ${FLOW_TEST_UNSET_TARGET}
+proxy_host: ${FLOW_TEST_UNSET_HOST}
+scale: ${FLOW_TEST_UNSET_SCALE:-2}`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+
+	err := ParseYml(&s.opts)
+
+	s.Error(err)
+	s.Contains(err.Error(), "FLOW_TEST_UNSET_HOST, FLOW_TEST_UNSET_TARGET")
+	s.NotContains(err.Error(), "FLOW_TEST_UNSET_SCALE")
+}
+
+func (s OptsTestSuite) Test_ParseYml_DoesNotInterpolateHooksAndStageParams() {
+	yml := `
+target: app-${FLOW_TEST_TAG:-latest}
+pipeline:
+  - type: shell
+    name: ${FLOW_TEST_STAGE_NAME:-check}
+    params:
+      command: curl http://app-${FLOW_NEXT_COLOR}/health
+hooks:
+  after_proxy:
+    - command: echo ${FLOW_NEXT_COLOR} > color.txt`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("app-latest", s.opts.Target)
+	s.Equal("check", s.opts.Pipeline[0].Name)
+	s.Equal("curl http://app-${FLOW_NEXT_COLOR}/health", s.opts.Pipeline[0].Params["command"])
+	s.Equal("echo ${FLOW_NEXT_COLOR} > color.txt", s.opts.Hooks["after_proxy"][0].Command)
+}
+
+func (s OptsTestSuite) Test_ParseYml_InterpolatesEnvVarsOfSelectedProfileOnly() {
+	yml := `
+target: app
+proxy_host: ${FLOW_TEST_PROXY:-proxy.local}
+profiles:
+  staging:
+    scale: ${FLOW_TEST_STAGING_SCALE:-2}
+  production:
+    proxy_host: ${FLOW_TEST_UNSET_PROD_PROXY}`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	s.opts.Profile = "staging"
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("2", s.opts.Scale)
+	s.Equal("proxy.local", s.opts.ProxyHost)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsErrorListingUnresolvedEnvVarsOfSelectedProfile() {
+	yml := `
+target: app
+profiles:
+  production:
+    proxy_host: ${FLOW_TEST_UNSET_PROD_PROXY}`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(yml), nil
+	}
+	s.opts.Profile = "production"
+
+	err := ParseYml(&s.opts)
+
+	s.Error(err)
+	s.Contains(err.Error(), "FLOW_TEST_UNSET_PROD_PROXY")
+}
+
+func (s OptsTestSuite) Test_ParseYml_MergesIncludedFiles() {
+	files := map[string]string{
+		dockerFlowPath: `
+include:
+  - config/proxy.yml
+  - config/hooks.yml
+target: app
+proxy_host: proxy.example.com`,
+		filepath.Join("config", "proxy.yml"): `
+include: common.yml
+proxy_host: proxy.staging.example.com
+proxy_reconf_port: 8081`,
+		filepath.Join("config", "common.yml"): `
+consul_address: http://consul:8500
+proxy_reconf_port: 8080`,
+		filepath.Join("config", "hooks.yml"): `
+hooks:
+  before_deploy:
+    - service: migrations`,
+	}
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		if data, ok := files[fileName]; ok {
+			return []byte(data), nil
+		}
+		return nil, fmt.Errorf("The file %s does not exist", fileName)
+	}
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("app", s.opts.Target)
+	s.Equal("proxy.example.com", s.opts.ProxyHost)
+	s.Equal("8081", s.opts.ProxyReconfPort)
+	s.Equal("http://consul:8500", s.opts.ServiceDiscoveryAddress)
+	s.Equal(map[string][]Hook{"before_deploy": {{Service: "migrations"}}}, s.opts.Hooks)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsError_WhenIncludedFileCannotBeRead() {
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		if fileName == dockerFlowPath {
+			return []byte("include: missing.yml"), nil
+		}
+		return nil, fmt.Errorf("This is an error")
+	}
+
+	actual := ParseYml(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsError_WhenFileIsIncludedRecursively() {
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		if fileName == dockerFlowPath {
+			return []byte("include: other.yml"), nil
+		}
+		return []byte("include: docker-flow.yml"), nil
+	}
+
+	actual := ParseYml(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReadsConfig() {
+	actual := ""
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		actual = fileName
+		return []byte("target: app"), nil
+	}
+	s.opts.Config = "/path/to/my-flow.yml"
+
+	ParseYml(&s.opts)
+
+	s.Equal("/path/to/my-flow.yml", actual)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsError_WhenConfigIsSpecifiedAndReadFileFails() {
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(""), fmt.Errorf("This is an error")
+	}
+	s.opts.Config = "/path/to/my-flow.yml"
+
+	actual := ParseYml(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ParsesJson() {
+	data := `{
+  "target": "app",
+  "timeout": 60,
+  "blue_green": true,
+  "side_targets": ["db"],
+  "pipeline": [{"type": "deploy", "timeout": 300}]
+}`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(data), nil
+	}
+	s.opts.Config = "my-flow.json"
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("app", s.opts.Target)
+	s.Equal(60, s.opts.Timeout)
+	s.True(s.opts.BlueGreen)
+	s.Equal([]string{"db"}, s.opts.SideTargets)
+	s.Equal([]Stage{{Type: FLOW_DEPLOY, Timeout: 300}}, s.opts.Pipeline)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ParsesToml() {
+	data := `
+target = "app"
+timeout = 60
+blue_green = true
+side_targets = ["db"]
+
+[[pipeline]]
+type = "deploy"
+timeout = 300
+
+[hooks]
+before_deploy = [{ service = "migrations" }]`
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(data), nil
+	}
+	s.opts.Config = "my-flow.toml"
+
+	err := ParseYml(&s.opts)
+
+	s.NoError(err)
+	s.Equal("app", s.opts.Target)
+	s.Equal(60, s.opts.Timeout)
+	s.True(s.opts.BlueGreen)
+	s.Equal([]string{"db"}, s.opts.SideTargets)
+	s.Equal([]Stage{{Type: FLOW_DEPLOY, Timeout: 300}}, s.opts.Pipeline)
+	s.Equal(map[string][]Hook{"before_deploy": {{Service: "migrations"}}}, s.opts.Hooks)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsError_WhenJsonIsInvalid() {
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte("target: app"), nil
+	}
+	s.opts.Config = "my-flow.json"
+
+	actual := ParseYml(&s.opts)
+
+	s.Error(actual)
+}
+
+func (s OptsTestSuite) Test_ParseYml_IgnoresUnknownOptions_WhenNotStrict() {
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte("target: app\nside_target: db"), nil
+	}
+	s.opts.StrictConfig = false
+
+	actual := ParseYml(&s.opts)
+
+	s.NoError(actual)
+}
+
+func (s OptsTestSuite) Test_ParseYml_ReturnsError_WhenStrictAndOptionIsUnknown() {
+	data := []string{
+		"target: app\nside_target: db",
+		"pipeline:\n  - type: deploy\n    timeuot: 10",
+	}
+	for _, d := range data {
+		util.ReadFile = func(fileName string) ([]byte, error) {
+			return []byte(d), nil
+		}
+		s.opts.StrictConfig = true
+
+		actual := ParseYml(&s.opts)
+
+		s.Error(actual, d)
+	}
+}
+
+func (s OptsTestSuite) Test_ParseYml_SetsSources() {
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte("target: app\nside_targets:\n  - db\nhooks: {}"), nil
+	}
+
+	ParseYml(&s.opts)
+
+	s.Equal(map[string]string{"target": OptSourceFile, "side_targets": OptSourceFile, "hooks": OptSourceFile}, s.opts.Sources)
+}
+
 // GetOpts
 
 func (s OptsTestSuite) TestGetOpts_SetsComposePath() {
@@ -649,6 +1338,30 @@ func (s OptsTestSuite) TestGetOpts_InvokesParseYml() {
 	s.True(called)
 }
 
+func (s OptsTestSuite) Test_GetOpts_SetsConfigArgsBeforeParseYml() {
+	getConfigArgsOrig := getConfigArgs
+	parseYmlOrig := parseYml
+	defer func() {
+		getConfigArgs = getConfigArgsOrig
+		parseYml = parseYmlOrig
+	}()
+	actual := ""
+	processOpts = func(*Opts) error {
+		return nil
+	}
+	getConfigArgs = func(opts *Opts) {
+		opts.Profile = "staging"
+	}
+	parseYml = func(opts *Opts) error {
+		actual = opts.Profile
+		return nil
+	}
+
+	GetOpts()
+
+	s.Equal("staging", actual)
+}
+
 func (s OptsTestSuite) Test_GetOpts_ReturnsError_WhenParseYmlFails() {
 	restore := parseYml
 	processOpts = func(*Opts) error {
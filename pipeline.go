@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"./util"
+)
+
+const StageWhenOnSuccess = "on_success"
+const StageWhenOnFailure = "on_failure"
+const StageWhenAlways = "always"
+
+var StepCancelGracePeriod = 5 * time.Second
+
+type Stage struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"`
+	When    string            `yaml:"when"`
+	Timeout int               `yaml:"timeout"`
+	Params  map[string]string `yaml:"params"`
+}
+
+type Pipeline struct{}
+
+var pipeline = Pipeline{}
+
+func (m Pipeline) Run(ctx context.Context, state *RunState, stages []Stage) error {
+	errs := []string{}
+	resumeAt := 0
+	if state.Run != nil {
+		resumeAt = state.Run.Position
+	}
+	// Stages and hooks that run because the flow failed are reported to webhooks as the rollback
+	rolledBack := false
+	rollbackErrs := []string{}
+	flowStart := time.Now()
+	SendWebhooks(ctx, state, WebhookEvent{Event: WebhookEventStart})
+	for i, stage := range stages {
+		result := StepResult{Name: m.getStageName(stage), Type: stage.Type, Status: StepStatusSkipped}
+		if i >= resumeAt && m.shouldRun(stage, state.Failed) {
+			if !state.Failed {
+				m.putRunPosition(state, i)
+			}
+			start := time.Now()
+			result.Error = m.runStageWithHooks(ctx, state, stage)
+			result.Duration = time.Since(start)
+			result.Status = StepStatusSuccess
+			rollback := state.Failed && stage.When == StageWhenOnFailure
+			rolledBack = rolledBack || rollback
+			if result.Error != nil {
+				result.Status = StepStatusFailure
+				msg := fmt.Sprintf("The %s stage failed\n%s", result.Name, result.Error.Error())
+				errs = append(errs, msg)
+				if rollback {
+					rollbackErrs = append(rollbackErrs, msg)
+				}
+			}
+			m.putRunResult(state, result)
+			logStepResult(state, result)
+			m.sendStepWebhooks(ctx, state, result)
+			state.Failed = state.Failed || result.Error != nil
+		}
+		state.Results = append(state.Results, result)
+	}
+	m.putRunStatus(ctx, state)
+	if state.Failed {
+		if err := RunHooks(ctx, state, HookOnFailure); err != nil {
+			errs = append(errs, err.Error())
+			rollbackErrs = append(rollbackErrs, err.Error())
+		}
+		rolledBack = rolledBack || len(state.Opts.Hooks[HookOnFailure]) > 0
+	}
+	if rolledBack {
+		m.sendResultWebhooks(ctx, state, WebhookEventRollback, rollbackErrs, time.Since(flowStart))
+	}
+	if len(errs) > 0 {
+		m.sendResultWebhooks(ctx, state, WebhookEventFailure, errs, time.Since(flowStart))
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	m.sendResultWebhooks(ctx, state, WebhookEventSuccess, errs, time.Since(flowStart))
+	return nil
+}
+
+func (m Pipeline) sendStepWebhooks(ctx context.Context, state *RunState, result StepResult) {
+	event := WebhookEvent{
+		Event:    WebhookEventStep,
+		Step:     result.Name,
+		Status:   result.Status,
+		Duration: result.Duration.Seconds(),
+	}
+	if result.Error != nil {
+		event.Error = result.Error.Error()
+	}
+	SendWebhooks(ctx, state, event)
+}
+
+func (m Pipeline) sendResultWebhooks(ctx context.Context, state *RunState, eventType string, errs []string, duration time.Duration) {
+	event := WebhookEvent{Event: eventType, Status: StepStatusSuccess, Duration: duration.Seconds()}
+	if len(errs) > 0 {
+		event.Status = StepStatusFailure
+		event.Error = strings.Join(errs, "\n")
+	}
+	SendWebhooks(ctx, state, event)
+}
+
+func (m Pipeline) putRunPosition(state *RunState, position int) {
+	if state.Run == nil {
+		return
+	}
+	state.Run.Position = position
+	m.putRun(state)
+}
+
+func (m Pipeline) putRunResult(state *RunState, result StepResult) {
+	if state.Run == nil {
+		return
+	}
+	if !state.Failed {
+		if result.Status == StepStatusFailure {
+			state.Run.Status = FlowRunStatusFailed
+			state.Run.FailedStep = result.Name
+		} else {
+			state.Run.CompletedSteps = append(state.Run.CompletedSteps, result.Name)
+		}
+	}
+	state.Run.Deployed = state.Deployed
+	m.putRun(state)
+}
+
+func (m Pipeline) putRunStatus(ctx context.Context, state *RunState) {
+	if state.Run == nil {
+		return
+	}
+	if !state.Failed {
+		state.Run.Status = FlowRunStatusCompleted
+		state.Run.FailedStep = ""
+	} else if ctx.Err() == context.Canceled {
+		state.Run.Status = FlowRunStatusInterrupted
+	} else {
+		return
+	}
+	m.putRun(state)
+}
+
+func (m Pipeline) putRun(state *RunState) {
+	// The run is stored even when the flow is cancelled so that it can be resumed.
+	ctx, cancel := context.WithTimeout(context.Background(), FlowRunPutTimeout)
+	defer cancel()
+	ctx = util.WithRetryPolicy(ctx, getRetryPolicy(state.Opts))
+	if err := getServiceDiscovery().PutRun(
+		ctx,
+		state.Opts.ServiceDiscoveryAddress,
+		state.Opts.ServiceName,
+		*state.Run,
+	); err != nil {
+		logPrintf("Could not store the state of the flow %s\n%s", state.Run.Id, err.Error())
+	}
+}
+
+func (m Pipeline) GetStages(flow []string) []Stage {
+	stages := []Stage{}
+	for _, step := range flow {
+		step = strings.ToLower(step)
+		stage := Stage{Type: step}
+		if strings.HasPrefix(step, FLOW_TEST+":") {
+			stage.Type = FLOW_TEST
+			stage.Params = map[string]string{"target": step[len(FLOW_TEST)+1:]}
+		}
+		if _, ok := getStep(stage.Type); ok {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+func (m Pipeline) Validate(stages []Stage) error {
+	for _, stage := range stages {
+		if _, ok := getStep(stage.Type); !ok {
+			return fmt.Errorf("pipeline stage %s has unknown type %s", m.getStageName(stage), stage.Type)
+		}
+		switch stage.When {
+		case "", StageWhenOnSuccess, StageWhenOnFailure, StageWhenAlways:
+		default:
+			return fmt.Errorf(
+				"pipeline stage %s must run %s, %s or %s",
+				m.getStageName(stage),
+				StageWhenOnSuccess,
+				StageWhenOnFailure,
+				StageWhenAlways,
+			)
+		}
+		if stage.Timeout < 0 {
+			return fmt.Errorf("pipeline stage %s timeout must not be negative", m.getStageName(stage))
+		}
+	}
+	return nil
+}
+
+func (m Pipeline) shouldRun(stage Stage, failed bool) bool {
+	switch stage.When {
+	case StageWhenAlways:
+		return true
+	case StageWhenOnFailure:
+		return failed
+	default:
+		return !failed
+	}
+}
+
+func (m Pipeline) runStageWithHooks(ctx context.Context, state *RunState, stage Stage) error {
+	if err := RunHooks(ctx, state, getHookKey(HookBefore, stage.Type)); err != nil {
+		return err
+	}
+	if err := m.runStage(ctx, state, stage); err != nil {
+		return err
+	}
+	return RunHooks(ctx, state, getHookKey(HookAfter, stage.Type))
+}
+
+func (m Pipeline) runStage(ctx context.Context, state *RunState, stage Stage) error {
+	step, ok := getStep(stage.Type)
+	if !ok {
+		return fmt.Errorf("Unknown stage type %s", stage.Type)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("The flow was stopped before the stage started\n%s", err.Error())
+	}
+	timeout := stage.Timeout
+	if timeout <= 0 {
+		timeout = state.Opts.StepTimeout
+	}
+	stageCtx, cancel := getRequestContext(withLogFields(ctx, state, m.getStageName(stage)), time.Duration(timeout)*time.Second)
+	defer cancel()
+	// The step works on its own copy of the state that is taken over only once the step returns.
+	// That way a step that outlives its timeout cannot change the state used by the stages that follow.
+	stepState := *state
+	done := make(chan error, 1)
+	go func() {
+		done <- step.Run(stageCtx, &stepState, stage)
+	}()
+	select {
+	case err := <-done:
+		*state = stepState
+		return err
+	case <-stageCtx.Done():
+		// Commands and requests are aborted with the context so the step gets a chance to clean up.
+		select {
+		case <-done:
+			*state = stepState
+		case <-time.After(StepCancelGracePeriod):
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("The flow was stopped before the stage finished\n%s", err.Error())
+		}
+		return fmt.Errorf("The stage did not finish in %d seconds", timeout)
+	}
+}
+
+func (m Pipeline) getStageName(stage Stage) string {
+	if len(stage.Name) > 0 {
+		return stage.Name
+	}
+	return stage.Type
+}
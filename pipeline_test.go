@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+	"./compose"
+)
+
+type PipelineTestSuite struct {
+	suite.Suite
+	opts  Opts
+	dc    compose.DockerComposer
+	steps map[string]Step
+}
+
+func (s *PipelineTestSuite) SetupTest() {
+	s.opts = Opts{
+		ComposePath:   "myComposePath",
+		Target:        "myTarget",
+		NextColor:     "orange",
+		CurrentColor:  "pink",
+		NextTarget:    "myNextTarget",
+		CurrentTarget: "myCurrentTarget",
+		Project:       "myProject",
+		ServiceName:   "myServiceName",
+		BlueGreen:     true,
+	}
+	s.dc = getDockerComposeMock(s.opts, "")
+	s.steps = steps
+	serviceDiscovery = getServiceDiscoveryMock(s.opts, "")
+}
+
+func (s *PipelineTestSuite) TearDownTest() {
+	steps = s.steps
+}
+
+func (s PipelineTestSuite) mockSteps(actual *[]string) {
+	steps = map[string]Step{
+		"success": StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+			*actual = append(*actual, stage.Name)
+			return nil
+		}),
+		"failure": StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+			*actual = append(*actual, stage.Name)
+			return fmt.Errorf("This is an error")
+		}),
+	}
+}
+
+// Run
+
+func (s PipelineTestSuite) Test_Run_RunsStagesInOrder() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	stages := []Stage{{Name: "first", Type: "success"}, {Name: "second", Type: "success"}}
+
+	err := Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), stages)
+
+	s.NoError(err)
+	s.Equal([]string{"first", "second"}, actual)
+}
+
+func (s PipelineTestSuite) Test_Run_ReturnsError_WhenStageFails() {
+	s.mockSteps(&[]string{})
+	stages := []Stage{{Name: "first", Type: "failure"}}
+
+	err := Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), stages)
+
+	s.Error(err)
+}
+
+func (s PipelineTestSuite) Test_Run_ReturnsError_WhenStageTypeIsUnknown() {
+	s.mockSteps(&[]string{})
+	stages := []Stage{{Name: "first", Type: "unknown"}}
+
+	err := Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), stages)
+
+	s.Error(err)
+}
+
+func (s PipelineTestSuite) Test_Run_SkipsOnSuccessStages_WhenPreviousStageFails() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	stages := []Stage{
+		{Name: "first", Type: "failure"},
+		{Name: "second", Type: "success"},
+		{Name: "third", Type: "success", When: StageWhenOnSuccess},
+	}
+
+	Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), stages)
+
+	s.Equal([]string{"first"}, actual)
+}
+
+func (s PipelineTestSuite) Test_Run_RunsOnFailureStagesOnlyWhenPreviousStageFails() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	stages := []Stage{
+		{Name: "first", Type: "success", When: StageWhenOnFailure},
+		{Name: "second", Type: "failure"},
+		{Name: "third", Type: "success", When: StageWhenOnFailure},
+	}
+
+	err := Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), stages)
+
+	s.Error(err)
+	s.Equal([]string{"second", "third"}, actual)
+}
+
+func (s PipelineTestSuite) Test_Run_RunsAlwaysStages() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	stages := []Stage{
+		{Name: "first", Type: "success", When: StageWhenAlways},
+		{Name: "second", Type: "failure"},
+		{Name: "third", Type: "success", When: StageWhenAlways},
+	}
+
+	Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), stages)
+
+	s.Equal([]string{"first", "second", "third"}, actual)
+}
+
+func (s PipelineTestSuite) Test_Run_PassesFailureToStages() {
+	s.mockSteps(&[]string{})
+	actual := false
+	steps["check"] = StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		actual = state.Failed
+		return nil
+	})
+	stages := []Stage{{Type: "failure"}, {Type: "check", When: StageWhenAlways}}
+
+	Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), stages)
+
+	s.True(actual)
+}
+
+func (s PipelineTestSuite) Test_Run_ReturnsError_WhenStageTimeoutIsReached() {
+	s.mockSteps(&[]string{})
+	steps["slow"] = StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		<-ctx.Done()
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	stages := []Stage{{Type: "slow", Timeout: 1}}
+
+	err := Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), stages)
+
+	s.Error(err)
+}
+
+func (s PipelineTestSuite) Test_Run_UsesStepTimeout_WhenStageDoesNotSpecifyTimeout() {
+	s.mockSteps(&[]string{})
+	steps["slow"] = StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		<-ctx.Done()
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	s.opts.StepTimeout = 1
+
+	err := Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), []Stage{{Type: "slow"}})
+
+	s.Error(err)
+}
+
+func (s PipelineTestSuite) Test_Run_DoesNotRunStages_WhenContextIsCancelled() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Pipeline{}.Run(ctx, NewRunState(s.opts, s.dc), []Stage{{Name: "first", Type: "success"}})
+
+	s.Error(err)
+	s.Empty(actual)
+}
+
+func (s PipelineTestSuite) Test_Run_AddsResultForEachStage() {
+	s.mockSteps(&[]string{})
+	state := NewRunState(s.opts, s.dc)
+	stages := []Stage{{Name: "first", Type: "success"}, {Name: "second", Type: "failure"}, {Name: "third", Type: "success"}}
+
+	Pipeline{}.Run(context.Background(), state, stages)
+
+	s.Len(state.Results, 3)
+	s.Equal(StepResult{Name: "first", Type: "success", Status: StepStatusSuccess, Duration: state.Results[0].Duration}, state.Results[0])
+	s.Equal(StepStatusFailure, state.Results[1].Status)
+	s.Error(state.Results[1].Error)
+	s.Equal(StepResult{Name: "third", Type: "success", Status: StepStatusSkipped}, state.Results[2])
+}
+
+func (s PipelineTestSuite) Test_Run_RunsBeforeAndAfterHooks() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	runStepCmdOrig := runStepCmd
+	defer func() { runStepCmd = runStepCmdOrig }()
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = append(actual, cmd.Args[2])
+		return nil
+	}
+	s.opts.Hooks = map[string][]Hook{
+		"before_success": {{Command: "before"}},
+		"after_success":  {{Command: "after"}},
+	}
+
+	err := Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), []Stage{{Name: "stage", Type: "success"}})
+
+	s.NoError(err)
+	s.Equal([]string{"before", "stage", "after"}, actual)
+}
+
+func (s PipelineTestSuite) Test_Run_DoesNotRunStage_WhenBeforeHookFails() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	runStepCmdOrig := runStepCmd
+	defer func() { runStepCmd = runStepCmdOrig }()
+	runStepCmd = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("This is an error")
+	}
+	s.opts.Hooks = map[string][]Hook{"before_success": {{Command: "before"}}}
+
+	err := Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), []Stage{{Name: "stage", Type: "success"}})
+
+	s.Error(err)
+	s.Empty(actual)
+}
+
+func (s PipelineTestSuite) Test_Run_RunsOnFailureHooks_WhenStageFails() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	runStepCmdOrig := runStepCmd
+	defer func() { runStepCmd = runStepCmdOrig }()
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = append(actual, cmd.Args[2])
+		return nil
+	}
+	s.opts.Hooks = map[string][]Hook{
+		"after_failure": {{Command: "after"}},
+		"on_failure":    {{Command: "rollback"}},
+	}
+
+	Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), []Stage{{Name: "stage", Type: "failure"}})
+
+	s.Equal([]string{"stage", "rollback"}, actual)
+}
+
+func (s PipelineTestSuite) Test_Run_DoesNotRunOnFailureHooks_WhenStagesSucceed() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	runStepCmdOrig := runStepCmd
+	defer func() { runStepCmd = runStepCmdOrig }()
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = append(actual, cmd.Args[2])
+		return nil
+	}
+	s.opts.Hooks = map[string][]Hook{"on_failure": {{Command: "rollback"}}}
+
+	Pipeline{}.Run(context.Background(), NewRunState(s.opts, s.dc), []Stage{{Name: "stage", Type: "success"}})
+
+	s.Equal([]string{"stage"}, actual)
+}
+
+func (s PipelineTestSuite) Test_Run_SkipsStagesBeforeRunPosition() {
+	actual := []string{}
+	s.mockSteps(&actual)
+	state := NewRunState(s.opts, s.dc)
+	state.Run = &FlowRun{Position: 1}
+	stages := []Stage{{Name: "first", Type: "success"}, {Name: "second", Type: "success"}}
+
+	err := Pipeline{}.Run(context.Background(), state, stages)
+
+	s.NoError(err)
+	s.Equal([]string{"second"}, actual)
+	s.Equal(StepStatusSkipped, state.Results[0].Status)
+	s.Equal([]string{"second"}, state.Run.CompletedSteps)
+	s.Equal(FlowRunStatusCompleted, state.Run.Status)
+}
+
+func (s PipelineTestSuite) Test_Run_KeepsPositionOfFailedStage() {
+	s.mockSteps(&[]string{})
+	state := NewRunState(s.opts, s.dc)
+	state.Run = &FlowRun{}
+	stages := []Stage{
+		{Name: "first", Type: "success"},
+		{Name: "second", Type: "failure"},
+		{Name: "third", Type: "success", When: StageWhenAlways},
+	}
+
+	Pipeline{}.Run(context.Background(), state, stages)
+
+	s.Equal(1, state.Run.Position)
+	s.Equal(FlowRunStatusFailed, state.Run.Status)
+	s.Equal("second", state.Run.FailedStep)
+	s.Equal([]string{"first"}, state.Run.CompletedSteps)
+}
+
+func (s PipelineTestSuite) Test_Run_StoresInterruptedRun_WhenContextIsCancelled() {
+	s.mockSteps(&[]string{})
+	ctx, cancel := context.WithCancel(context.Background())
+	steps["cancel"] = StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		cancel()
+		return ctx.Err()
+	})
+	state := NewRunState(s.opts, s.dc)
+	state.Run = &FlowRun{}
+
+	Pipeline{}.Run(ctx, state, []Stage{{Type: "success"}, {Type: "cancel"}})
+
+	s.Equal(FlowRunStatusInterrupted, state.Run.Status)
+	s.Equal(1, state.Run.Position)
+}
+
+func (s PipelineTestSuite) Test_Run_WaitsForCancelledStageToFinish() {
+	s.mockSteps(&[]string{})
+	actual := false
+	steps["slow"] = StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		actual = true
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	Pipeline{}.Run(ctx, NewRunState(s.opts, s.dc), []Stage{{Type: "slow"}})
+
+	s.True(actual)
+}
+
+func (s PipelineTestSuite) Test_Run_DoesNotChangeState_WhenStageFinishesAfterGracePeriod() {
+	s.mockSteps(&[]string{})
+	gracePeriodOrig := StepCancelGracePeriod
+	defer func() { StepCancelGracePeriod = gracePeriodOrig }()
+	StepCancelGracePeriod = 10 * time.Millisecond
+	finished := make(chan bool)
+	steps["stuck"] = StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		time.Sleep(1500 * time.Millisecond)
+		state.CurrentColor = "late"
+		close(finished)
+		return nil
+	})
+	state := NewRunState(s.opts, s.dc)
+
+	err := Pipeline{}.Run(context.Background(), state, []Stage{{Type: "stuck", Timeout: 1}})
+	<-finished
+
+	s.Error(err)
+	s.Equal("pink", state.CurrentColor)
+}
+
+// GetStages
+
+func (s PipelineTestSuite) Test_GetStages_ReturnsStagesFromFlow() {
+	expected := []Stage{
+		{Type: FLOW_DEPLOY},
+		{Type: FLOW_TEST, Params: map[string]string{"target": "tests"}},
+		{Type: FLOW_PROXY},
+	}
+
+	actual := Pipeline{}.GetStages([]string{"deploy", "test:tests", "unknown", "proxy"})
+
+	s.Equal(expected, actual)
+}
+
+// Validate
+
+func (s PipelineTestSuite) Test_Validate_ReturnsNil() {
+	stages := []Stage{{Type: FLOW_DEPLOY}, {Type: FLOW_NOTIFY, When: StageWhenAlways, Timeout: 10}}
+
+	s.NoError(Pipeline{}.Validate(stages))
+}
+
+func (s PipelineTestSuite) Test_Validate_ReturnsError_WhenTypeIsUnknown() {
+	s.Error(Pipeline{}.Validate([]Stage{{Type: "unknown"}}))
+}
+
+func (s PipelineTestSuite) Test_Validate_ReturnsError_WhenConditionIsUnknown() {
+	s.Error(Pipeline{}.Validate([]Stage{{Type: FLOW_DEPLOY, When: "sometimes"}}))
+}
+
+func (s PipelineTestSuite) Test_Validate_ReturnsError_WhenTimeoutIsNegative() {
+	s.Error(Pipeline{}.Validate([]Stage{{Type: FLOW_DEPLOY, Timeout: -1}}))
+}
+
+// Suite
+
+func TestPipelineTestSuite(t *testing.T) {
+	dockerHost := os.Getenv("DOCKER_HOST")
+	dockerCertPath := os.Getenv("DOCKER_CERT_PATH")
+	defer func() {
+		os.Setenv("DOCKER_HOST", dockerHost)
+		os.Setenv("DOCKER_CERT_PATH", dockerCertPath)
+	}()
+	suite.Run(t, new(PipelineTestSuite))
+}
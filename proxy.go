@@ -1,6 +1,77 @@
 package main
 
+import (
+	"context"
+	"time"
+)
+
+const ProxyFailPolicyAny = "any"
+const ProxyFailPolicyQuorum = "quorum"
+const ProxyVerifyDefaultTimeout = 30
+const ProxyPathTypePrefix = "prefix"
+const ProxyPathTypeRegex = "regex"
+
 type Proxy interface {
-	Provision(dockerHost, reconfPort, certPath, scAddress string) error
-	Reconfigure(dockerHost, proxyCertPath, host, reconfPort, serviceName, serviceColor string, servicePath []string, consulTemplateFePath, consulTemplateBePath string) error
+	Provision(req ProvisionRequest) error
+	Reconfigure(req ReconfigureRequest) error
+	Verify(req ReconfigureRequest) error
+}
+
+type ProvisionRequest struct {
+	Context        context.Context
+	Timeout        time.Duration
+	DockerHost     string
+	DockerCertPath string
+	ReconfPort     string
+	ScAddress      string
+	Container      ProxyContainer
+}
+
+type ReconfigureRequest struct {
+	Context              context.Context
+	Timeout              time.Duration
+	DockerHost           string
+	DockerCertPath       string
+	Host                 string
+	ReconfPort           string
+	ContainerName        string
+	ServiceName          string
+	ServiceColor         string
+	ServicePath          []string
+	ServiceDomain        []string
+	PathType             string
+	HttpsOnly            bool
+	CertPath             string
+	Params               []string
+	ConsulTemplateFePath string
+	ConsulTemplateBePath string
+	VerifyHeader         string
+	VerifyTimeout        time.Duration
+}
+
+type ProxyContainer struct {
+	Name    string
+	Image   string
+	Ports   []string
+	Env     []string
+	Volumes []string
+	Restart string
+	Network string
+}
+
+type ProxyInstance struct {
+	Host           string `yaml:"host"`
+	DockerHost     string `yaml:"docker_host"`
+	DockerCertPath string `yaml:"docker_cert_path"`
+	ReconfPort     string `yaml:"reconf_port"`
+}
+
+func getRequestContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
 }
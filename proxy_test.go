@@ -10,23 +10,31 @@ type ProxyMock struct {
 	mock.Mock
 }
 
-func (m *ProxyMock) Provision(host, reconfPort, certPath, scAddress string) error {
-	args := m.Called(host, certPath, scAddress)
+func (m *ProxyMock) Provision(req ProvisionRequest) error {
+	args := m.Called(req)
 	return args.Error(0)
 }
 
-func (m *ProxyMock) Reconfigure(dockerHost, proxyCertPath, host, reconfPort, serviceName, serviceColor string, servicePath []string, consulTemplateFePath, consulTemplateBePath string) error {
-	args := m.Called(dockerHost, proxyCertPath, host, reconfPort, serviceName, serviceColor, servicePath, consulTemplateFePath, consulTemplateBePath)
+func (m *ProxyMock) Reconfigure(req ReconfigureRequest) error {
+	args := m.Called(req)
+	return args.Error(0)
+}
+
+func (m *ProxyMock) Verify(req ReconfigureRequest) error {
+	args := m.Called(req)
 	return args.Error(0)
 }
 
 func getProxyMock(skipMethod string) *ProxyMock {
 	mockObj := new(ProxyMock)
 	if skipMethod != "Provision" {
-		mockObj.On("Provision", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockObj.On("Provision", mock.Anything).Return(nil)
 	}
 	if skipMethod != "Reconfigure" {
-		mockObj.On("Reconfigure", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockObj.On("Reconfigure", mock.Anything).Return(nil)
+	}
+	if skipMethod != "Verify" {
+		mockObj.On("Verify", mock.Anything).Return(nil)
 	}
 	return mockObj
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"./util"
+)
+
+const ReportStatusSuccess = "success"
+const ReportStatusFailure = "failure"
+const ReportRedacted = "xxxxx"
+
+type reportKey struct{}
+type reportServiceKey struct{}
+
+type Report struct {
+	Status   string                 `json:"status"`
+	Duration float64                `json:"duration"`
+	Error    string                 `json:"error,omitempty"`
+	Options  map[string]interface{} `json:"options"`
+	Services []*ReportService       `json:"services"`
+	mu       sync.Mutex
+}
+
+type ReportService struct {
+	Name          string       `json:"name"`
+	RunId         string       `json:"run_id"`
+	CurrentColor  string       `json:"current_color"`
+	NextColor     string       `json:"next_color"`
+	CurrentTarget string       `json:"current_target"`
+	NextTarget    string       `json:"next_target"`
+	Scale         int          `json:"scale,omitempty"`
+	ProxyUrls     []string     `json:"proxy_urls"`
+	Steps         []ReportStep `json:"steps"`
+	Error         string       `json:"error,omitempty"`
+	mu            sync.Mutex
+}
+
+type ReportStep struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// NewReport returns a report with the resolved options. The flow fills in the rest of it through the context.
+// Values that can hold credentials are redacted since reports are written to files and returned by the server.
+func NewReport(opts Opts) *Report {
+	report := &Report{Options: map[string]interface{}{}, Services: []*ReportService{}}
+	value := reflect.ValueOf(getReportOpts(opts))
+	for _, option := range getOptions() {
+		report.Options[option.Name] = value.Field(option.Index).Interface()
+	}
+	return report
+}
+
+// getReportOpts returns a copy of the options with passwords in URLs, values of proxy environment variables
+// and headers of webhooks redacted.
+func getReportOpts(opts Opts) Opts {
+	opts.ServiceDiscoveryAddress = redactReportUrl(opts.ServiceDiscoveryAddress)
+	opts.MetricsPushgateway = redactReportUrl(opts.MetricsPushgateway)
+	opts.ProxyHost = redactReportUrl(opts.ProxyHost)
+	if opts.ProxyEnv != nil {
+		env := make([]string, len(opts.ProxyEnv))
+		for i, variable := range opts.ProxyEnv {
+			env[i] = strings.SplitN(variable, "=", 2)[0] + "=" + ReportRedacted
+		}
+		opts.ProxyEnv = env
+	}
+	if opts.Pipeline != nil {
+		pipeline := make([]Stage, len(opts.Pipeline))
+		for i, stage := range opts.Pipeline {
+			if stageUrl, ok := stage.Params["url"]; ok {
+				params := map[string]string{}
+				for key, value := range stage.Params {
+					params[key] = value
+				}
+				params["url"] = redactReportUrl(stageUrl)
+				stage.Params = params
+			}
+			pipeline[i] = stage
+		}
+		opts.Pipeline = pipeline
+	}
+	if opts.Webhooks != nil {
+		webhooks := make([]Webhook, len(opts.Webhooks))
+		for i, webhook := range opts.Webhooks {
+			webhook.Url = redactReportUrl(webhook.Url)
+			if webhook.Headers != nil {
+				headers := map[string]string{}
+				for key := range webhook.Headers {
+					headers[key] = ReportRedacted
+				}
+				webhook.Headers = headers
+			}
+			webhooks[i] = webhook
+		}
+		opts.Webhooks = webhooks
+	}
+	return opts
+}
+
+func redactReportUrl(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); !ok {
+		return value
+	}
+	u.User = url.UserPassword(u.User.Username(), ReportRedacted)
+	return u.String()
+}
+
+func WithReport(ctx context.Context, report *Report) context.Context {
+	return context.WithValue(ctx, reportKey{}, report)
+}
+
+func getReport(ctx context.Context) *Report {
+	report, _ := ctx.Value(reportKey{}).(*Report)
+	return report
+}
+
+func getReportService(ctx context.Context) *ReportService {
+	service, _ := ctx.Value(reportServiceKey{}).(*ReportService)
+	return service
+}
+
+// addService adds the flow of a service to the report and returns the context its steps record their results in.
+// The results are recorded even without a report so that webhooks can use them.
+func (r *Report) addService(ctx context.Context, opts Opts, runId string) context.Context {
+	service := &ReportService{
+		Name:          opts.ServiceName,
+		RunId:         runId,
+		CurrentColor:  opts.CurrentColor,
+		NextColor:     opts.NextColor,
+		CurrentTarget: opts.CurrentTarget,
+		NextTarget:    opts.NextTarget,
+		ProxyUrls:     []string{},
+		Steps:         []ReportStep{},
+	}
+	if r != nil {
+		r.mu.Lock()
+		r.Services = append(r.Services, service)
+		r.mu.Unlock()
+	}
+	return context.WithValue(ctx, reportServiceKey{}, service)
+}
+
+func (r *Report) Finish(err error, duration time.Duration) {
+	if r == nil {
+		return
+	}
+	r.Status = ReportStatusSuccess
+	r.Duration = duration.Seconds()
+	if err != nil {
+		r.Status = ReportStatusFailure
+		r.Error = err.Error()
+	}
+}
+
+func (s *ReportService) setScale(scale int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Scale = scale
+}
+
+func (s *ReportService) getScale() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Scale
+}
+
+func (s *ReportService) addProxyUrl(proxyUrl string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ProxyUrls = append(s.ProxyUrls, proxyUrl)
+}
+
+func (s *ReportService) setResults(results []StepResult, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, result := range results {
+		step := ReportStep{Name: result.Name, Type: result.Type, Status: result.Status, Duration: result.Duration.Seconds()}
+		if result.Error != nil {
+			step.Error = result.Error.Error()
+		}
+		s.Steps = append(s.Steps, step)
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// WriteReport writes the report as JSON to the report path and the results of the test steps as JUnit XML to the report-junit path.
+func WriteReport(opts Opts, report *Report) error {
+	if len(opts.Report) > 0 {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := util.WriteFile(opts.Report, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("Could not write the report to %s\n%s", opts.Report, err.Error())
+		}
+	}
+	if len(opts.ReportJunit) > 0 {
+		data, err := xml.MarshalIndent(getJunitTestSuites(report), "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append([]byte(xml.Header), append(data, '\n')...)
+		if err := util.WriteFile(opts.ReportJunit, data, 0644); err != nil {
+			return fmt.Errorf("Could not write the JUnit report to %s\n%s", opts.ReportJunit, err.Error())
+		}
+	}
+	return nil
+}
+
+func getJunitTestSuites(report *Report) junitTestSuites {
+	suites := junitTestSuites{Suites: []junitTestSuite{}}
+	for _, service := range report.Services {
+		suite := junitTestSuite{Name: service.Name, Cases: []junitTestCase{}}
+		duration := 0.0
+		for _, step := range service.Steps {
+			if step.Type != FLOW_TEST {
+				continue
+			}
+			testCase := junitTestCase{Name: step.Name, ClassName: service.Name, Time: fmt.Sprintf("%.3f", step.Duration)}
+			switch step.Status {
+			case StepStatusFailure:
+				testCase.Failure = &junitFailure{Message: fmt.Sprintf("The %s step failed", step.Name), Text: step.Error}
+				suite.Failures++
+			case StepStatusSkipped:
+				testCase.Skipped = &struct{}{}
+				suite.Skipped++
+			}
+			duration += step.Duration
+			suite.Cases = append(suite.Cases, testCase)
+		}
+		suite.Tests = len(suite.Cases)
+		suite.Time = fmt.Sprintf("%.3f", duration)
+		suites.Suites = append(suites.Suites, suite)
+	}
+	return suites
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"./util"
+)
+
+const ServerFlowStatusQueued = "queued"
+const ServerFlowStatusRunning = "running"
+const ServerDefaultHistory = 100
+const serverMaxBodySize = 1 << 20
+
+var serverExecutable = os.Executable
+var loadServerOpts = LoadServerOpts
+var runServerFlow = RunServerFlow
+
+// serverUnsafeOptions are the options of the Docker Flow file that requests can set only when the server allows unsafe options.
+// They run commands, send requests to other hosts, read and write files on the host of the server
+// or choose the Docker hosts and the proxy containers the flow uses.
+var serverUnsafeOptions = map[string]bool{
+	"cert_path": true, "compose_path": true, "config": true, "consul_address": true, "consul_template_be_path": true,
+	"consul_template_fe_path": true, "hooks": true, "host": true, "metrics_pushgateway": true, "metrics_textfile": true,
+	"proxies": true, "proxy_docker_cert_path": true, "proxy_docker_host": true, "proxy_env": true, "proxy_host": true,
+	"proxy_image": true, "proxy_network": true, "proxy_volumes": true, "report": true, "report_junit": true,
+	"service_cert_path": true, "test_compose_path": true, "webhooks": true,
+}
+var serverUnsafeStages = map[string]bool{FLOW_SHELL: true, FLOW_NOTIFY: true}
+
+type ServerOpts struct {
+	Address            string `short:"a" long:"address" description:"Address the HTTP API listens on." default:":8080" env:"FLOW_SERVER_ADDRESS"`
+	AllowUnsafeOptions bool   `long:"allow-unsafe-options" description:"Allows requests to set hooks, webhooks, shell and notify stages, paths of files, Docker and Consul hosts and proxy containers. Those options run commands, send requests to other hosts, read and write files on the host of the server or choose the hosts and containers the flow uses." env:"FLOW_SERVER_ALLOW_UNSAFE_OPTIONS"`
+	Config             string `long:"config" description:"Path to the Docker Flow file the options of each flow are based on. If not specified, docker-flow.yml from the current directory is used when it exists."`
+	History            int    `long:"history" description:"Number of finished flows kept in memory. If not specified, the last 100 flows are kept." env:"FLOW_SERVER_HISTORY"`
+	Profile            string `long:"profile" description:"Name of the profile from the Docker Flow file merged over its base configuration."`
+	Token              string `long:"token" description:"Token that requests must send in the Authorization header (Bearer <token>). It is required unless the API listens on a loopback address." env:"FLOW_SERVER_TOKEN"`
+}
+
+type Server struct {
+	Opts   ServerOpts
+	ctx    context.Context
+	flows  map[string]*ServerFlow
+	order  []string
+	queues map[string]chan struct{}
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+}
+
+// ServerFlow is a flow requested through the API. Its options are those of the server overridden by the request.
+type ServerFlow struct {
+	Id         string     `json:"id"`
+	Services   []string   `json:"services"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Report     *Report    `json:"report,omitempty"`
+	Logs       string     `json:"logs"`
+	log        *serverFlowLog
+}
+
+type ServerService struct {
+	Name    string   `json:"name"`
+	Color   string   `json:"color"`
+	Scale   int      `json:"scale"`
+	LastRun *FlowRun `json:"last_run,omitempty"`
+	Flows   []string `json:"flows"`
+}
+
+// serverFlowLog collects the output of a flow while it runs.
+type serverFlowLog struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (l *serverFlowLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *serverFlowLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func RunServerCommand() error {
+	opts := ServerOpts{}
+	if _, err := flags.NewParser(&opts, flags.Default).ParseArgs(os.Args[2:]); err != nil {
+		return fmt.Errorf("Could not parse command line arguments\n%s", err.Error())
+	}
+	// The Docker Flow file is loaded on every request so it is validated up front
+	if _, err := loadServerOpts(); err != nil {
+		return err
+	}
+	if err := ValidateServerOpts(opts); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, stop := handleSignals(cancel)
+	defer stop()
+	return NewServer(ctx, opts).ListenAndServe()
+}
+
+// ValidateServerOpts checks that the API cannot be used to run flows without a token from other hosts.
+func ValidateServerOpts(opts ServerOpts) error {
+	if len(opts.Token) == 0 && !isLoopbackAddress(opts.Address) {
+		return fmt.Errorf("token is required unless the address is a loopback address (e.g. 127.0.0.1:8080)")
+	}
+	return nil
+}
+
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// LoadServerOpts merges the Docker Flow file and environment variables the options of each flow are based on.
+// Arguments of the server command are not flow options so they are not parsed.
+func LoadServerOpts() (Opts, error) {
+	opts := Opts{
+		ComposePath: dockerComposePath,
+		Flow:        []string{"deploy"},
+		Sources:     map[string]string{},
+	}
+	getConfigArgs(&opts)
+	if err := parseYml(&opts); err != nil {
+		return opts, err
+	}
+	if err := parseEnvVars(&opts); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// NewServer returns a server whose flows are stopped when the context is cancelled.
+func NewServer(ctx context.Context, opts ServerOpts) *Server {
+	if opts.History <= 0 {
+		opts.History = ServerDefaultHistory
+	}
+	return &Server{
+		Opts:   opts,
+		ctx:    ctx,
+		flows:  map[string]*ServerFlow{},
+		order:  []string{},
+		queues: map[string]chan struct{}{},
+	}
+}
+
+// ListenAndServe serves the API until the context of the server is cancelled and waits for the running flows to stop.
+func (s *Server) ListenAndServe() error {
+	return s.listenAndServe(s)
+}
+
+func (s *Server) listenAndServe(handler http.Handler) error {
+	httpServer := &http.Server{Addr: s.Opts.Address, Handler: handler}
+	go func() {
+		<-s.ctx.Done()
+		httpServer.Close()
+	}()
+	logPrintf("Listening on %s", s.Opts.Address)
+	err := httpServer.ListenAndServe()
+	if s.ctx.Err() != nil {
+		s.wg.Wait()
+		return nil
+	}
+	return err
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(s.Opts.Token) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.Opts.Token)) != 1 {
+		writeServerError(w, http.StatusUnauthorized, fmt.Errorf("The token is missing or invalid"))
+		return
+	}
+	path := strings.TrimRight(r.URL.Path, "/")
+	switch {
+	case path == "/v1/flows" && r.Method == "POST":
+		s.createFlow(w, r)
+	case strings.HasPrefix(path, "/v1/flows/") && r.Method == "GET":
+		s.getFlow(w, strings.TrimPrefix(path, "/v1/flows/"))
+	case strings.HasPrefix(path, "/v1/services/") && r.Method == "GET":
+		s.getService(w, r, strings.TrimPrefix(path, "/v1/services/"))
+	case path == "/v1/flows" || strings.HasPrefix(path, "/v1/flows/") || strings.HasPrefix(path, "/v1/services/"):
+		writeServerError(w, http.StatusMethodNotAllowed, fmt.Errorf("The method %s is not allowed", r.Method))
+	default:
+		writeServerError(w, http.StatusNotFound, fmt.Errorf("%s was not found", r.URL.Path))
+	}
+}
+
+// createFlow queues a flow with the options of the server overridden by the fields of the request.
+// The fields are the keys of the Docker Flow file.
+func (s *Server) createFlow(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, serverMaxBodySize))
+	if err != nil {
+		writeServerError(w, http.StatusBadRequest, fmt.Errorf("Could not read the request\n%s", err.Error()))
+		return
+	}
+	request, err := getServerRequest(body)
+	if err != nil {
+		writeServerError(w, http.StatusBadRequest, fmt.Errorf("Could not parse the request\n%s", err.Error()))
+		return
+	}
+	if unsafe := getServerUnsafeOptions(request); len(unsafe) > 0 && !s.Opts.AllowUnsafeOptions {
+		writeServerError(w, http.StatusForbidden, fmt.Errorf("The request sets %s which the server does not allow. The server must be started with --allow-unsafe-options to allow them.", strings.Join(unsafe, ", ")))
+		return
+	}
+	opts, err := loadServerOpts()
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := yaml.UnmarshalStrict(body, &opts); err != nil {
+		writeServerError(w, http.StatusBadRequest, fmt.Errorf("Could not parse the request\n%s", err.Error()))
+		return
+	}
+	flow, err := s.queueFlow(opts, nil)
+	if err != nil {
+		writeServerError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/flows/"+flow.Id)
+	writeServerJson(w, http.StatusAccepted, s.getFlowCopy(flow))
+}
+
+// getServerRequest decodes the request into a struct with only the options of the Docker Flow file
+// so that requests cannot set fields of Opts that are computed by the flow, like the service name or the colors.
+func getServerRequest(body []byte) (Opts, error) {
+	optsType := reflect.TypeOf(Opts{})
+	fields := []reflect.StructField{}
+	for _, option := range getOptions() {
+		field := optsType.Field(option.Index)
+		if name := field.Tag.Get("yaml"); len(name) > 0 && name != "-" {
+			fields = append(fields, reflect.StructField{Name: field.Name, Type: field.Type, Tag: reflect.StructTag(fmt.Sprintf(`yaml:"%s"`, name))})
+		}
+	}
+	request := reflect.New(reflect.StructOf(fields))
+	if err := yaml.UnmarshalStrict(body, request.Interface()); err != nil {
+		return Opts{}, err
+	}
+	opts := Opts{}
+	optsValue := reflect.ValueOf(&opts).Elem()
+	for _, field := range fields {
+		optsValue.FieldByName(field.Name).Set(request.Elem().FieldByName(field.Name))
+	}
+	return opts, nil
+}
+
+// getServerUnsafeOptions returns the unsafe options set by a request, including shell and notify stages
+// and paths of Docker Compose files of stages and services.
+func getServerUnsafeOptions(request Opts) []string {
+	unsafe := []string{}
+	value := reflect.ValueOf(request)
+	for _, option := range getOptions() {
+		field := value.Field(option.Index)
+		if serverUnsafeOptions[option.Name] && !reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+			unsafe = append(unsafe, option.Name)
+		}
+	}
+	for _, stage := range request.Pipeline {
+		if serverUnsafeStages[strings.ToLower(stage.Type)] {
+			unsafe = append(unsafe, fmt.Sprintf("the %s stage", stage.Type))
+		} else if len(stage.Params["compose_path"]) > 0 {
+			unsafe = append(unsafe, fmt.Sprintf("compose_path of the %s stage", stage.Type))
+		}
+	}
+	for _, service := range request.Services {
+		if len(service.ComposePath) > 0 {
+			unsafe = append(unsafe, fmt.Sprintf("compose_path of the service %s", service.GetName()))
+		}
+	}
+	return unsafe
+}
+
+// queueFlow validates the options and runs the flow once no other flow of its services is running.
+// The environment variables are added to those of the flow process.
+func (s *Server) queueFlow(opts Opts, env []string) (*ServerFlow, error) {
+	flow, err := s.newFlow(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.startFlow(flow, opts, env)
+	return flow, nil
+}
+
+// newFlow validates the options and returns the flow that runs them without starting it.
+func (s *Server) newFlow(opts Opts) (*ServerFlow, error) {
+	processed := opts
+	if err := processOpts(&processed); err != nil {
+		return nil, err
+	}
+	return &ServerFlow{
+		Id:        getFlowRunId(),
+		Services:  getServerFlowServices(processed),
+		Status:    ServerFlowStatusQueued,
+		CreatedAt: time.Now().UTC(),
+		log:       &serverFlowLog{},
+	}, nil
+}
+
+// startFlow adds the flow to those of the server and runs it once no other flow of its services is running.
+func (s *Server) startFlow(flow *ServerFlow, opts Opts, env []string) {
+	s.mu.Lock()
+	s.flows[flow.Id] = flow
+	s.order = append(s.order, flow.Id)
+	s.wg.Add(1)
+	s.mu.Unlock()
+	logPrintf("Queued the flow %s of %s", flow.Id, strings.Join(flow.Services, ", "))
+	go s.run(flow, opts, env)
+}
+
+func (s *Server) getFlow(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	flow, ok := s.flows[id]
+	s.mu.Unlock()
+	if !ok {
+		writeServerError(w, http.StatusNotFound, fmt.Errorf("The flow %s was not found", id))
+		return
+	}
+	writeServerJson(w, http.StatusOK, s.getFlowCopy(flow))
+}
+
+// getService returns the color and scale of the service stored in Consul together with the flows of the server that run it.
+func (s *Server) getService(w http.ResponseWriter, r *http.Request, name string) {
+	opts, err := loadServerOpts()
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, err)
+		return
+	}
+	SetDefaultOpts(&opts)
+	if len(opts.ServiceDiscoveryAddress) == 0 {
+		writeServerError(w, http.StatusInternalServerError, fmt.Errorf("consul-address argument is required"))
+		return
+	}
+	ctx, cancel := getRequestContext(r.Context(), time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+	ctx = util.WithRetryPolicy(ctx, getRetryPolicy(opts))
+	sd := getServiceDiscovery()
+	service := ServerService{Name: name, Flows: []string{}}
+	if service.Color, err = sd.GetColor(ctx, opts.ServiceDiscoveryAddress, name); err != nil {
+		writeServerError(w, http.StatusBadGateway, err)
+		return
+	}
+	if service.Scale, err = sd.GetScaleCalc(ctx, opts.ServiceDiscoveryAddress, name, ""); err != nil {
+		writeServerError(w, http.StatusBadGateway, err)
+		return
+	}
+	run, err := sd.GetRun(ctx, opts.ServiceDiscoveryAddress, name)
+	if err != nil {
+		writeServerError(w, http.StatusBadGateway, err)
+		return
+	}
+	if len(run.Id) > 0 {
+		service.LastRun = &run
+	}
+	s.mu.Lock()
+	for _, id := range s.order {
+		for _, serviceName := range s.flows[id].Services {
+			if serviceName == name {
+				service.Flows = append(service.Flows, id)
+			}
+		}
+	}
+	s.mu.Unlock()
+	writeServerJson(w, http.StatusOK, service)
+}
+
+// run waits until no other flow of the same services is running and runs the flow.
+func (s *Server) run(flow *ServerFlow, opts Opts, env []string) {
+	defer s.wg.Done()
+	services := append([]string{}, flow.Services...)
+	// Queues are always acquired in the same order so that flows of overlapping services cannot block each other
+	sort.Strings(services)
+	acquired := []chan struct{}{}
+	defer func() {
+		for _, queue := range acquired {
+			<-queue
+		}
+	}()
+	for _, service := range services {
+		queue := s.getQueue(service)
+		select {
+		case queue <- struct{}{}:
+			acquired = append(acquired, queue)
+		case <-s.ctx.Done():
+			s.finish(flow, nil, fmt.Errorf("The server was stopped before the flow started"))
+			return
+		}
+	}
+	s.mu.Lock()
+	now := time.Now().UTC()
+	flow.Status = ServerFlowStatusRunning
+	flow.StartedAt = &now
+	s.mu.Unlock()
+	logPrintf("Running the flow %s of %s...", flow.Id, strings.Join(flow.Services, ", "))
+	report, err := runServerFlow(s.ctx, opts, env, flow.log)
+	s.finish(flow, report, err)
+}
+
+func (s *Server) finish(flow *ServerFlow, report *Report, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	flow.FinishedAt = &now
+	flow.Report = report
+	flow.Status = ReportStatusSuccess
+	if err != nil {
+		flow.Status = ReportStatusFailure
+		flow.Error = err.Error()
+	}
+	logPrintf("The flow %s finished with the status %s", flow.Id, flow.Status)
+	s.removeOldFlows()
+}
+
+// removeOldFlows forgets the oldest finished flows above the history limit. It must be called with the lock held.
+func (s *Server) removeOldFlows() {
+	finished := 0
+	for _, id := range s.order {
+		if s.flows[id].FinishedAt != nil {
+			finished++
+		}
+	}
+	order := []string{}
+	for _, id := range s.order {
+		if finished > s.Opts.History && s.flows[id].FinishedAt != nil {
+			delete(s.flows, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	s.order = order
+}
+
+func (s *Server) getQueue(service string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.queues[service]; !ok {
+		s.queues[service] = make(chan struct{}, 1)
+	}
+	return s.queues[service]
+}
+
+func (s *Server) getFlowCopy(flow *ServerFlow) ServerFlow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := *flow
+	result.Logs = flow.log.String()
+	return result
+}
+
+func getServerFlowServices(opts Opts) []string {
+	if len(opts.Services) == 0 {
+		return []string{opts.ServiceName}
+	}
+	services := []string{}
+	for _, service := range opts.Services {
+		services = append(services, fmt.Sprintf("%s-%s", opts.Project, service.Target))
+	}
+	return services
+}
+
+// RunServerFlow runs the flow in a separate docker-flow process so that its output is kept apart from other flows
+// and flows of different services do not share environment variables such as DOCKER_HOST.
+// The process gets the options as its Docker Flow file and is stopped with SIGTERM when the context is cancelled.
+func RunServerFlow(ctx context.Context, opts Opts, env []string, output *serverFlowLog) (*Report, error) {
+	executable, err := serverExecutable()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := util.TempDir("", "docker-flow-server")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, dockerFlowPath)
+	opts.Report = filepath.Join(dir, "report.json")
+	data, err := getServerFlowConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.WriteFile(configPath, data, 0600); err != nil {
+		return nil, err
+	}
+	cmd := util.ExecCmd(context.Background(), executable, "--config", configPath)
+	cmd.Env = []string{}
+	// The options are in the Docker Flow file so variables of the server must not override them
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "FLOW_") && !strings.HasPrefix(variable, "FLOW=") {
+			cmd.Env = append(cmd.Env, variable)
+		}
+	}
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Could not start the flow\n%s", err.Error())
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		cmd.Process.Signal(syscall.SIGTERM)
+		err = <-done
+	}
+	var report *Report
+	if data, readErr := util.ReadFile(opts.Report); readErr == nil {
+		report = &Report{}
+		if jsonErr := json.Unmarshal(data, report); jsonErr != nil {
+			report = nil
+		}
+	}
+	if err != nil {
+		return report, fmt.Errorf("The flow failed\n%s", err.Error())
+	}
+	return report, nil
+}
+
+func getServerFlowConfig(opts Opts) ([]byte, error) {
+	config := yaml.MapSlice{}
+	t := reflect.TypeOf(opts)
+	value := reflect.ValueOf(opts)
+	for _, option := range getOptions() {
+		if t.Field(option.Index).Tag.Get("yaml") == "-" {
+			continue
+		}
+		config = append(config, yaml.MapItem{Key: option.Name, Value: value.Field(option.Index).Interface()})
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	// The flow process interpolates environment variables in its Docker Flow file
+	// so values of the request, already interpolated by the server, are escaped.
+	escaped := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(data, &escaped); err != nil {
+		return nil, err
+	}
+	escapeOptions(escaped)
+	return yaml.Marshal(escaped)
+}
+
+func writeServerJson(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+func writeServerError(w http.ResponseWriter, status int, err error) {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
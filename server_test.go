@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"./util"
+)
+
+type ServerTestSuite struct {
+	suite.Suite
+	opts   *Opts
+	server *Server
+	runs   *serverRuns
+}
+
+type serverRuns struct {
+	opts    []Opts
+	running int
+	maxRun  int
+	started chan string
+	release chan struct{}
+	err     error
+	ids     int
+	mu      sync.Mutex
+}
+
+func (s *ServerTestSuite) SetupTest() {
+	s.opts = &Opts{
+		ComposePath:             "docker-compose.yml",
+		Flow:                    []string{"deploy"},
+		Project:                 "myProject",
+		Target:                  "myTarget",
+		ServiceDiscoveryAddress: "http://consul",
+	}
+	loadServerOpts = func() (Opts, error) {
+		return *s.opts, nil
+	}
+	processOpts = func(opts *Opts) error {
+		if len(opts.Target) == 0 && len(opts.Services) == 0 {
+			return fmt.Errorf("target argument is required")
+		}
+		opts.ServiceName = fmt.Sprintf("%s-%s", opts.Project, opts.Target)
+		return nil
+	}
+	runs := &serverRuns{started: make(chan string, 10), release: make(chan struct{})}
+	close(runs.release)
+	s.runs = runs
+	getFlowRunId = func() string {
+		runs.mu.Lock()
+		defer runs.mu.Unlock()
+		runs.ids++
+		return fmt.Sprintf("flow-%d", runs.ids)
+	}
+	runServerFlow = func(ctx context.Context, opts Opts, env []string, output *serverFlowLog) (*Report, error) {
+		runs.mu.Lock()
+		runs.opts = append(runs.opts, opts)
+		runs.running++
+		if runs.running > runs.maxRun {
+			runs.maxRun = runs.running
+		}
+		release := runs.release
+		runs.mu.Unlock()
+		runs.started <- opts.Target
+		fmt.Fprintf(output, "Deploying (%s)...\n", opts.Target)
+		<-release
+		runs.mu.Lock()
+		defer runs.mu.Unlock()
+		runs.running--
+		return &Report{Status: ReportStatusSuccess}, runs.err
+	}
+	serviceDiscovery = getServiceDiscoveryMock(Opts{ServiceDiscoveryAddress: "http://consul", ServiceName: "myProject-myTarget"}, "")
+	s.server = NewServer(context.Background(), ServerOpts{})
+	logPrintln = func(v ...interface{}) {}
+	logPrintf = func(format string, v ...interface{}) {}
+}
+
+func (s *ServerTestSuite) TearDownTest() {
+	s.server.wg.Wait()
+}
+
+// POST /v1/flows
+
+func (s ServerTestSuite) Test_CreateFlow_QueuesFlowAndReturnsIt() {
+	resp := s.request("POST", "/v1/flows", `{}`)
+
+	s.Equal(http.StatusAccepted, resp.Code)
+	s.Equal("/v1/flows/flow-1", resp.Header().Get("Location"))
+	actual := ServerFlow{}
+	s.NoError(json.Unmarshal(resp.Body.Bytes(), &actual))
+	s.Equal("flow-1", actual.Id)
+	s.Equal([]string{"myProject-myTarget"}, actual.Services)
+}
+
+func (s ServerTestSuite) Test_CreateFlow_RunsFlowWithOptionsOfServerOverriddenByRequest() {
+	s.request("POST", "/v1/flows", `{"target": "api", "scale": "+2", "flow": ["deploy", "scale"], "blue_green": true}`)
+
+	s.waitForFlow("flow-1")
+	actual := s.runs.opts[0]
+	s.Equal("api", actual.Target)
+	s.Equal("+2", actual.Scale)
+	s.Equal([]string{"deploy", "scale"}, actual.Flow)
+	s.True(actual.BlueGreen)
+	s.Equal("http://consul", actual.ServiceDiscoveryAddress)
+}
+
+func (s ServerTestSuite) Test_CreateFlow_ReturnsBadRequest_WhenRequestHasUnknownFields() {
+	resp := s.request("POST", "/v1/flows", `{"targte": "api"}`)
+
+	s.Equal(http.StatusBadRequest, resp.Code)
+	s.Contains(resp.Body.String(), "targte")
+}
+
+func (s ServerTestSuite) Test_CreateFlow_ReturnsBadRequest_WhenRequestSetsComputedFields() {
+	for _, body := range []string{`{"servicename": "other"}`, `{"flowpath": "/etc/passwd"}`, `{"currentcolor": "blue"}`} {
+		resp := s.request("POST", "/v1/flows", body)
+
+		s.Equal(http.StatusBadRequest, resp.Code, body)
+	}
+	s.Empty(s.server.flows)
+}
+
+func (s ServerTestSuite) Test_CreateFlow_ReturnsForbidden_WhenRequestSetsUnsafeOptions() {
+	for _, body := range []string{
+		`{"hooks": {"before_deploy": [{"command": "rm -rf /"}]}}`,
+		`{"pipeline": [{"type": "deploy"}, {"type": "shell", "params": {"command": "rm -rf /"}}]}`,
+		`{"pipeline": [{"type": "notify", "params": {"url": "http://internal"}}]}`,
+		`{"pipeline": [{"type": "test", "params": {"target": "tests", "compose_path": "/etc/passwd"}}]}`,
+		`{"report": "/etc/cron.d/flow"}`,
+		`{"compose_path": "/tmp/docker-compose.yml"}`,
+		`{"services": [{"target": "api", "compose_path": "/tmp/docker-compose.yml"}]}`,
+		`{"webhooks": [{"url": "http://internal"}]}`,
+		`{"host": "tcp://other-host:2376"}`,
+		`{"proxy_docker_host": "tcp://other-host:2376"}`,
+		`{"proxy_image": "my/proxy"}`,
+		`{"proxy_network": "host"}`,
+		`{"proxy_env": ["MODE=swarm"]}`,
+		`{"proxies": [{"docker_host": "tcp://other-host:2376"}]}`,
+		`{"consul_address": "http://internal"}`,
+		`{"proxy_host": "http://internal"}`,
+		`{"metrics_pushgateway": "http://internal"}`,
+	} {
+		resp := s.request("POST", "/v1/flows", body)
+
+		s.Equal(http.StatusForbidden, resp.Code, body)
+		s.Contains(resp.Body.String(), "--allow-unsafe-options", body)
+	}
+	s.server.wg.Wait()
+	s.Empty(s.runs.opts)
+}
+
+func (s ServerTestSuite) Test_CreateFlow_AcceptsUnsafeOptions_WhenServerAllowsThem() {
+	s.server.Opts.AllowUnsafeOptions = true
+
+	resp := s.request("POST", "/v1/flows", `{"report": "/tmp/report.json", "pipeline": [{"type": "deploy"}, {"type": "shell", "params": {"command": "./smoke.sh"}}]}`)
+
+	s.Equal(http.StatusAccepted, resp.Code)
+	s.waitForFlow("flow-1")
+	s.Equal("/tmp/report.json", s.runs.opts[0].Report)
+}
+
+func (s ServerTestSuite) Test_CreateFlow_ReturnsBadRequest_WhenOptionsAreInvalid() {
+	s.opts.Target = ""
+
+	resp := s.request("POST", "/v1/flows", `{}`)
+
+	s.Equal(http.StatusBadRequest, resp.Code)
+	s.Contains(resp.Body.String(), "target argument is required")
+	s.Empty(s.server.flows)
+}
+
+func (s ServerTestSuite) Test_CreateFlow_ReturnsNamesOfAllServices_WhenServicesAreSpecified() {
+	s.opts.Target = ""
+
+	resp := s.request("POST", "/v1/flows", `{"services": [{"target": "api"}, {"target": "ui"}]}`)
+
+	actual := ServerFlow{}
+	json.Unmarshal(resp.Body.Bytes(), &actual)
+	s.Equal([]string{"myProject-api", "myProject-ui"}, actual.Services)
+}
+
+// GET /v1/flows/{id}
+
+func (s ServerTestSuite) Test_GetFlow_ReturnsStatusReportAndLogs() {
+	s.request("POST", "/v1/flows", `{}`)
+
+	actual := s.waitForFlow("flow-1")
+
+	s.Equal(ReportStatusSuccess, actual.Status)
+	s.Equal(ReportStatusSuccess, actual.Report.Status)
+	s.Equal("Deploying (myTarget)...\n", actual.Logs)
+	s.NotNil(actual.StartedAt)
+	s.NotNil(actual.FinishedAt)
+}
+
+func (s ServerTestSuite) Test_GetFlow_ReturnsFailure_WhenFlowFails() {
+	s.runs.err = fmt.Errorf("This is an error")
+	s.request("POST", "/v1/flows", `{}`)
+
+	actual := s.waitForFlow("flow-1")
+
+	s.Equal(ReportStatusFailure, actual.Status)
+	s.Equal("This is an error", actual.Error)
+}
+
+func (s ServerTestSuite) Test_GetFlow_ReturnsNotFound_WhenFlowDoesNotExist() {
+	resp := s.request("GET", "/v1/flows/flow-123", "")
+
+	s.Equal(http.StatusNotFound, resp.Code)
+}
+
+func (s ServerTestSuite) Test_GetFlow_ForgetsOldestFlows_WhenHistoryIsExceeded() {
+	s.server.Opts.History = 1
+	s.request("POST", "/v1/flows", `{}`)
+	s.waitForFlow("flow-1")
+	s.request("POST", "/v1/flows", `{}`)
+	s.waitForFlow("flow-2")
+
+	s.Equal(http.StatusNotFound, s.request("GET", "/v1/flows/flow-1", "").Code)
+	s.Equal(http.StatusOK, s.request("GET", "/v1/flows/flow-2", "").Code)
+}
+
+// Queue
+
+func (s ServerTestSuite) Test_Run_QueuesFlowsOfTheSameService() {
+	s.runs.release = make(chan struct{})
+	s.request("POST", "/v1/flows", `{}`)
+	<-s.runs.started
+	s.request("POST", "/v1/flows", `{}`)
+
+	s.Equal(ServerFlowStatusQueued, s.getFlow("flow-2").Status)
+	close(s.runs.release)
+	s.waitForFlow("flow-1")
+	s.waitForFlow("flow-2")
+	s.Equal(1, s.runs.maxRun)
+}
+
+func (s ServerTestSuite) Test_Run_RunsFlowsOfDifferentServicesInParallel() {
+	s.runs.release = make(chan struct{})
+	s.request("POST", "/v1/flows", `{"target": "api"}`)
+	s.request("POST", "/v1/flows", `{"target": "ui"}`)
+
+	<-s.runs.started
+	<-s.runs.started
+	close(s.runs.release)
+	s.waitForFlow("flow-1")
+	s.waitForFlow("flow-2")
+	s.Equal(2, s.runs.maxRun)
+}
+
+func (s ServerTestSuite) Test_Run_FailsQueuedFlows_WhenServerIsStopped() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.server = NewServer(ctx, ServerOpts{})
+	s.runs.release = make(chan struct{})
+	s.request("POST", "/v1/flows", `{}`)
+	<-s.runs.started
+	s.request("POST", "/v1/flows", `{}`)
+
+	cancel()
+	actual := s.waitForFlow("flow-2")
+	close(s.runs.release)
+
+	s.Equal(ReportStatusFailure, actual.Status)
+	s.Contains(actual.Error, "stopped")
+	s.server.wg.Wait()
+}
+
+// GET /v1/services/{name}
+
+func (s ServerTestSuite) Test_GetService_ReturnsColorScaleAndFlows() {
+	mockObj := getServiceDiscoveryMock(Opts{ServiceDiscoveryAddress: "http://consul", ServiceName: "myProject-myTarget"}, "GetRun")
+	mockObj.On("GetRun", mock.Anything, "http://consul", "myProject-myTarget").Return(FlowRun{Id: "myRunId", Status: FlowRunStatusCompleted}, nil)
+	serviceDiscovery = mockObj
+	s.request("POST", "/v1/flows", `{}`)
+	s.waitForFlow("flow-1")
+
+	resp := s.request("GET", "/v1/services/myProject-myTarget", "")
+
+	s.Equal(http.StatusOK, resp.Code)
+	actual := ServerService{}
+	s.NoError(json.Unmarshal(resp.Body.Bytes(), &actual))
+	s.Equal("myProject-myTarget", actual.Name)
+	s.Equal("orange", actual.Color)
+	s.Equal(5, actual.Scale)
+	s.Equal("myRunId", actual.LastRun.Id)
+	s.Equal([]string{"flow-1"}, actual.Flows)
+}
+
+func (s ServerTestSuite) Test_GetService_ReturnsBadGateway_WhenConsulFails() {
+	mockObj := getServiceDiscoveryMock(Opts{ServiceDiscoveryAddress: "http://consul", ServiceName: "myProject-myTarget"}, "GetColor")
+	mockObj.On("GetColor", mock.Anything, mock.Anything, mock.Anything).Return("", fmt.Errorf("This is an error"))
+	serviceDiscovery = mockObj
+
+	resp := s.request("GET", "/v1/services/myProject-myTarget", "")
+
+	s.Equal(http.StatusBadGateway, resp.Code)
+}
+
+// Authentication and routing
+
+func (s ServerTestSuite) Test_ServeHTTP_ReturnsUnauthorized_WhenTokenIsInvalid() {
+	s.server.Opts.Token = "myToken"
+	req := httptest.NewRequest("GET", "/v1/flows/flow-1", nil)
+	req.Header.Set("Authorization", "Bearer otherToken")
+	resp := httptest.NewRecorder()
+
+	s.server.ServeHTTP(resp, req)
+
+	s.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func (s ServerTestSuite) Test_ServeHTTP_AcceptsValidToken() {
+	s.server.Opts.Token = "myToken"
+	req := httptest.NewRequest("POST", "/v1/flows", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer myToken")
+	resp := httptest.NewRecorder()
+
+	s.server.ServeHTTP(resp, req)
+
+	s.Equal(http.StatusAccepted, resp.Code)
+	s.server.wg.Wait()
+}
+
+func (s ServerTestSuite) Test_ServeHTTP_ReturnsMethodNotAllowed() {
+	s.Equal(http.StatusMethodNotAllowed, s.request("GET", "/v1/flows", "").Code)
+	s.Equal(http.StatusMethodNotAllowed, s.request("DELETE", "/v1/services/myService", "").Code)
+}
+
+func (s ServerTestSuite) Test_ServeHTTP_ReturnsNotFound_WhenPathIsUnknown() {
+	s.Equal(http.StatusNotFound, s.request("GET", "/v2/flows", "").Code)
+}
+
+// ValidateServerOpts
+
+func (s ServerTestSuite) Test_ValidateServerOpts_ReturnsNil_WhenTokenIsSpecified() {
+	s.NoError(ValidateServerOpts(ServerOpts{Address: ":8080", Token: "myToken"}))
+}
+
+func (s ServerTestSuite) Test_ValidateServerOpts_ReturnsNil_WhenAddressIsLoopback() {
+	for _, address := range []string{"127.0.0.1:8080", "localhost:8080", "[::1]:8080"} {
+		s.NoError(ValidateServerOpts(ServerOpts{Address: address}), address)
+	}
+}
+
+func (s ServerTestSuite) Test_ValidateServerOpts_ReturnsError_WhenTokenIsEmptyAndAddressIsNotLoopback() {
+	for _, address := range []string{":8080", "0.0.0.0:8080", "10.0.0.1:8080", "[::]:8080", "myhost:8080"} {
+		s.Error(ValidateServerOpts(ServerOpts{Address: address}), address)
+	}
+}
+
+// RunServerFlow
+
+func (s ServerTestSuite) Test_RunServerFlow_RunsDockerFlowWithOptionsAsConfig() {
+	s.mockExecCmd(`echo "$0 $1"; echo "TAG=$TAG"; cat "$2"; env | grep '^FLOW_' || echo "no variables"; echo '{"status": "success"}' > "$(dirname "$2")/report.json"`)
+	os.Setenv("FLOW_TARGET", "otherTarget")
+	defer os.Unsetenv("FLOW_TARGET")
+	output := &serverFlowLog{}
+
+	report, err := RunServerFlow(context.Background(), *s.opts, []string{"TAG=1.2"}, output)
+
+	s.NoError(err)
+	s.Equal(ReportStatusSuccess, report.Status)
+	actual := output.String()
+	s.Contains(actual, "docker-flow --config")
+	s.Contains(actual, "TAG=1.2")
+	s.Contains(actual, "target: myTarget")
+	s.Contains(actual, "consul_address: http://consul")
+	s.Contains(actual, "report: ")
+	s.NotContains(actual, "profile:")
+	s.Contains(actual, "no variables")
+}
+
+func (s ServerTestSuite) Test_RunServerFlow_PassesVariablesOfRequestToFlowUnchanged() {
+	s.mockExecCmd(`cat "$2"`)
+	s.opts.ServicePath = []string{"/api/${HOME}"}
+	s.opts.Target = "pa$$word"
+	s.opts.Pipeline = []Stage{{Type: FLOW_SHELL, Params: map[string]string{"command": "echo ${FLOW_NEXT_COLOR}"}}}
+	s.opts.Hooks = map[string][]Hook{HookAfter + FLOW_DEPLOY: {{Command: "echo $HOME"}}}
+	output := &serverFlowLog{}
+
+	RunServerFlow(context.Background(), *s.opts, nil, output)
+
+	util.ReadFile = func(fileName string) ([]byte, error) {
+		return []byte(output.String()), nil
+	}
+	actual := Opts{Config: dockerFlowPath}
+	s.NoError(ParseYml(&actual))
+	s.Equal([]string{"/api/${HOME}"}, actual.ServicePath)
+	s.Equal("pa$$word", actual.Target)
+	s.Equal("echo ${FLOW_NEXT_COLOR}", actual.Pipeline[0].Params["command"])
+	s.Equal(s.opts.Hooks, actual.Hooks)
+}
+
+func (s ServerTestSuite) Test_RunServerFlow_ReturnsError_WhenFlowFails() {
+	s.mockExecCmd(`echo "Something went wrong"; exit 1`)
+
+	report, err := RunServerFlow(context.Background(), *s.opts, nil, &serverFlowLog{})
+
+	s.Error(err)
+	s.Nil(report)
+}
+
+func (s ServerTestSuite) Test_RunServerFlow_StopsFlow_WhenContextIsCancelled() {
+	s.mockExecCmd(`trap 'echo "Cleaning up"; exit 130' TERM; echo started; while true; do sleep 0.01; done`)
+	ctx, cancel := context.WithCancel(context.Background())
+	output := &serverFlowLog{}
+	go func() {
+		for !strings.Contains(output.String(), "started") {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+
+	_, err := RunServerFlow(ctx, *s.opts, nil, output)
+
+	s.Error(err)
+	s.Contains(output.String(), "Cleaning up")
+}
+
+func (s ServerTestSuite) request(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	resp := httptest.NewRecorder()
+	s.server.ServeHTTP(resp, req)
+	return resp
+}
+
+func (s ServerTestSuite) getFlow(id string) ServerFlow {
+	flow := ServerFlow{}
+	json.Unmarshal(s.request("GET", "/v1/flows/"+id, "").Body.Bytes(), &flow)
+	return flow
+}
+
+func (s ServerTestSuite) waitForFlow(id string) ServerFlow {
+	for i := 0; i < 200; i++ {
+		if flow := s.getFlow(id); flow.FinishedAt != nil {
+			return flow
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Fail("The flow " + id + " did not finish")
+	return ServerFlow{}
+}
+
+func (s ServerTestSuite) mockExecCmd(script string) {
+	serverExecutable = func() (string, error) {
+		return "docker-flow", nil
+	}
+	util.TempDir = ioutil.TempDir
+	util.WriteFile = ioutil.WriteFile
+	util.ReadFile = ioutil.ReadFile
+	util.ExecCmd = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		return exec.Command("sh", append([]string{"-c", script, name}, arg...)...)
+	}
+}
+
+func TestServerTestSuite(t *testing.T) {
+	loadServerOptsOrig := loadServerOpts
+	processOptsOrig := processOpts
+	runServerFlowOrig := runServerFlow
+	getFlowRunIdOrig := getFlowRunId
+	serverExecutableOrig := serverExecutable
+	execCmdOrig := util.ExecCmd
+	tempDirOrig := util.TempDir
+	writeFileOrig := util.WriteFile
+	readFileOrig := util.ReadFile
+	defer func() {
+		loadServerOpts = loadServerOptsOrig
+		processOpts = processOptsOrig
+		runServerFlow = runServerFlowOrig
+		getFlowRunId = getFlowRunIdOrig
+		serverExecutable = serverExecutableOrig
+		util.ExecCmd = execCmdOrig
+		util.TempDir = tempDirOrig
+		util.WriteFile = writeFileOrig
+		util.ReadFile = readFileOrig
+	}()
+	suite.Run(t, new(ServerTestSuite))
+}
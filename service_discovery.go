@@ -1,5 +1,7 @@
 package main
 
+import "context"
+
 const BlueColor = "blue"
 const GreenColor = "green"
 
@@ -10,9 +12,11 @@ func getServiceDiscovery() ServiceDiscovery {
 }
 
 type ServiceDiscovery interface {
-	GetScaleCalc(address, serviceName, scale string) (int, error)
+	GetScaleCalc(ctx context.Context, address, serviceName, scale string) (int, error)
 	GetNextColor(currentColor string) string
-	GetColor(address, serviceName string) (string, error)
-	PutScale(address, serviceName string, value int) (string, error)
-	PutColor(address, serviceName, value string) (string, error)
+	GetColor(ctx context.Context, address, serviceName string) (string, error)
+	PutScale(ctx context.Context, address, serviceName string, value int) (string, error)
+	PutColor(ctx context.Context, address, serviceName, value string) (string, error)
+	GetRun(ctx context.Context, address, serviceName string) (FlowRun, error)
+	PutRun(ctx context.Context, address, serviceName string, run FlowRun) error
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -10,8 +11,8 @@ type ServiceDiscoveryMock struct {
 	mock.Mock
 }
 
-func (m *ServiceDiscoveryMock) GetScaleCalc(address, serviceName, scale string) (int, error) {
-	args := m.Called(address, serviceName, scale)
+func (m *ServiceDiscoveryMock) GetScaleCalc(ctx context.Context, address, serviceName, scale string) (int, error) {
+	args := m.Called(ctx, address, serviceName, scale)
 	return args.Int(0), args.Error(1)
 }
 
@@ -20,38 +21,54 @@ func (m *ServiceDiscoveryMock) GetNextColor(currentColor string) string {
 	return args.String(0)
 }
 
-func (m *ServiceDiscoveryMock) GetColor(address, serviceName string) (string, error) {
-	args := m.Called(address, serviceName)
+func (m *ServiceDiscoveryMock) GetColor(ctx context.Context, address, serviceName string) (string, error) {
+	args := m.Called(ctx, address, serviceName)
 	return args.String(0), args.Error(1)
 }
 
-func (m *ServiceDiscoveryMock) PutScale(address, serviceName string, value int) (string, error) {
-	args := m.Called(address, serviceName, value)
+func (m *ServiceDiscoveryMock) PutScale(ctx context.Context, address, serviceName string, value int) (string, error) {
+	args := m.Called(ctx, address, serviceName, value)
 	return args.String(0), args.Error(1)
 }
 
-func (m *ServiceDiscoveryMock) PutColor(address, serviceName, value string) (string, error) {
-	args := m.Called(address, serviceName, value)
+func (m *ServiceDiscoveryMock) PutColor(ctx context.Context, address, serviceName, value string) (string, error) {
+	args := m.Called(ctx, address, serviceName, value)
 	return args.String(0), args.Error(1)
 }
 
+func (m *ServiceDiscoveryMock) GetRun(ctx context.Context, address, serviceName string) (FlowRun, error) {
+	args := m.Called(ctx, address, serviceName)
+	return args.Get(0).(FlowRun), args.Error(1)
+}
+
+func (m *ServiceDiscoveryMock) PutRun(ctx context.Context, address, serviceName string, run FlowRun) error {
+	args := m.Called(ctx, address, serviceName, run)
+	return args.Error(0)
+}
+
 func getServiceDiscoveryMock(opts Opts, skipMethod string) *ServiceDiscoveryMock {
 	mockObj := new(ServiceDiscoveryMock)
 	scaleCalc := 5
 	if skipMethod != "GetScaleCalc" {
-		mockObj.On("GetScaleCalc", opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale).Return(scaleCalc, nil)
+		mockObj.On("GetScaleCalc", mock.Anything, opts.ServiceDiscoveryAddress, opts.ServiceName, opts.Scale).Return(scaleCalc, nil)
 	}
 	if skipMethod != "PutScale" {
-		mockObj.On("PutScale", opts.ServiceDiscoveryAddress, opts.ServiceName, scaleCalc).Return("", nil)
+		mockObj.On("PutScale", mock.Anything, opts.ServiceDiscoveryAddress, opts.ServiceName, scaleCalc).Return("", nil)
 	}
 	if skipMethod != "GetColor" {
-		mockObj.On("GetColor", opts.ServiceDiscoveryAddress, opts.ServiceName).Return("orange", nil)
+		mockObj.On("GetColor", mock.Anything, opts.ServiceDiscoveryAddress, opts.ServiceName).Return("orange", nil)
 	}
 	if skipMethod != "GetNextColor" {
 		mockObj.On("GetNextColor", opts.CurrentColor).Return("pink")
 	}
 	if skipMethod != "PutColor" {
-		mockObj.On("PutColor", mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+		mockObj.On("PutColor", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	}
+	if skipMethod != "GetRun" {
+		mockObj.On("GetRun", mock.Anything, opts.ServiceDiscoveryAddress, opts.ServiceName).Return(FlowRun{}, nil)
+	}
+	if skipMethod != "PutRun" {
+		mockObj.On("PutRun", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	}
 	return mockObj
 }
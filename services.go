@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+type Service struct {
+	Name            string   `yaml:"name"`
+	Target          string   `yaml:"target"`
+	SideTargets     []string `yaml:"side_targets"`
+	PullSideTargets *bool    `yaml:"pull_side_targets"`
+	BlueGreen       *bool    `yaml:"blue_green"`
+	ComposePath     string   `yaml:"compose_path"`
+	Scale           string   `yaml:"scale"`
+	ServicePath     []string `yaml:"service_path"`
+	ServicePathType string   `yaml:"service_path_type"`
+	ServiceDomain   []string `yaml:"service_domain"`
+	DependsOn       []string `yaml:"depends_on"`
+	Repository      string   `yaml:"repository"`
+	Tags            []string `yaml:"tags"`
+	TagVariable     string   `yaml:"tag_variable"`
+}
+
+func (s Service) GetName() string {
+	if len(s.Name) > 0 {
+		return s.Name
+	}
+	return s.Target
+}
+
+func ValidateServices(services []Service) error {
+	names := map[string]Service{}
+	for _, service := range services {
+		if len(service.Target) == 0 {
+			return fmt.Errorf("each service must specify the target")
+		}
+		if _, ok := names[service.GetName()]; ok {
+			return fmt.Errorf("service %s is specified more than once", service.GetName())
+		}
+		names[service.GetName()] = service
+		if len(service.Repository) > 0 && len(service.Tags) == 0 {
+			return fmt.Errorf("service %s must specify the tags of %s that are deployed", service.GetName(), service.Repository)
+		}
+		for _, tag := range service.Tags {
+			if _, err := path.Match(tag, ""); err != nil {
+				return fmt.Errorf("service %s tag %s is not a valid pattern", service.GetName(), tag)
+			}
+		}
+	}
+	for _, service := range services {
+		for _, dep := range service.DependsOn {
+			if _, ok := names[dep]; !ok {
+				return fmt.Errorf("service %s depends on the unknown service %s", service.GetName(), dep)
+			}
+		}
+	}
+	visited := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch visited[name] {
+		case 1:
+			return fmt.Errorf("services have circular dependencies: %s", strings.Join(path, " -> "))
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, dep := range names[name].DependsOn {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		return nil
+	}
+	for _, service := range services {
+		if err := visit(service.GetName(), []string{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getServiceOpts(ctx context.Context, opts Opts, service Service) (Opts, error) {
+	opts.Services = nil
+	opts.Target = service.Target
+	opts.SideTargets = service.SideTargets
+	opts.ServiceName = fmt.Sprintf("%s-%s", opts.Project, service.Target)
+	if service.PullSideTargets != nil {
+		opts.PullSideTargets = *service.PullSideTargets
+	}
+	if service.BlueGreen != nil {
+		opts.BlueGreen = *service.BlueGreen
+	}
+	if len(service.ComposePath) > 0 {
+		opts.ComposePath = service.ComposePath
+	}
+	if len(service.Scale) > 0 {
+		opts.Scale = service.Scale
+	}
+	if len(service.ServicePath) > 0 {
+		opts.ServicePath = service.ServicePath
+	}
+	if len(service.ServicePathType) > 0 {
+		opts.ServicePathType = service.ServicePathType
+	}
+	if len(service.ServiceDomain) > 0 {
+		opts.ServiceDomain = service.ServiceDomain
+	}
+	if err := setColorsAndTargets(ctx, &opts); err != nil {
+		return opts, fmt.Errorf("Could not retrieve the color of the service %s\n%s", service.GetName(), err.Error())
+	}
+	return opts, nil
+}
+
+// runServices runs the flow of each service as soon as the services it depends on succeed.
+// Services without dependencies between them run in parallel.
+func (m Flow) runServices(ctx context.Context, opts Opts) ([]StepResult, error) {
+	done := map[string]chan struct{}{}
+	for _, service := range opts.Services {
+		done[service.GetName()] = make(chan struct{})
+	}
+	failed := map[string]bool{}
+	results := make([][]StepResult, len(opts.Services))
+	errs := make([]error, len(opts.Services))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, service := range opts.Services {
+		wg.Add(1)
+		go func(i int, service Service) {
+			defer wg.Done()
+			defer close(done[service.GetName()])
+			for _, dep := range service.DependsOn {
+				<-done[dep]
+			}
+			mu.Lock()
+			for _, dep := range service.DependsOn {
+				if failed[dep] {
+					failed[service.GetName()] = true
+					errs[i] = fmt.Errorf("The service %s was not run because the service %s failed", service.GetName(), dep)
+				}
+			}
+			mu.Unlock()
+			if errs[i] == nil {
+				results[i], errs[i] = m.runService(ctx, opts, service)
+			} else {
+				results[i] = m.getSkippedResults(opts)
+			}
+			mu.Lock()
+			failed[service.GetName()] = failed[service.GetName()] || errs[i] != nil
+			mu.Unlock()
+			for j := range results[i] {
+				results[i][j].Service = service.GetName()
+			}
+		}(i, service)
+	}
+	wg.Wait()
+	combined := []StepResult{}
+	msgs := []string{}
+	for i, service := range opts.Services {
+		combined = append(combined, results[i]...)
+		if errs[i] != nil {
+			msgs = append(msgs, fmt.Sprintf("The flow of the service %s failed\n%s", service.GetName(), errs[i].Error()))
+		}
+	}
+	if len(msgs) > 0 {
+		return combined, fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+	return combined, nil
+}
+
+func (m Flow) runService(ctx context.Context, opts Opts, service Service) ([]StepResult, error) {
+	serviceOpts, err := getServiceOpts(ctx, opts, service)
+	if err != nil {
+		return m.getSkippedResults(opts), err
+	}
+	if opts.Resume {
+		run, err := getServiceDiscovery().GetRun(ctx, serviceOpts.ServiceDiscoveryAddress, serviceOpts.ServiceName)
+		if err != nil {
+			return m.getSkippedResults(opts), err
+		}
+		if !run.IsUnfinished() {
+			logPrintln(fmt.Sprintf("The service %s has no unfinished flow to resume", service.GetName()))
+			return m.getSkippedResults(opts), nil
+		}
+	}
+	logPrintln(fmt.Sprintf("Running the flow of the service %s...", service.GetName()))
+	return m.Run(ctx, serviceOpts)
+}
+
+func (m Flow) getSkippedResults(opts Opts) []StepResult {
+	stages := opts.Pipeline
+	if len(stages) == 0 {
+		stages = pipeline.GetStages(opts.Flow)
+	}
+	results := []StepResult{}
+	for _, stage := range stages {
+		results = append(results, StepResult{Name: pipeline.getStageName(stage), Type: stage.Type, Status: StepStatusSkipped})
+	}
+	return results
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+	"./compose"
+)
+
+type ServicesTestSuite struct {
+	suite.Suite
+	opts Opts
+	dc   *DockerComposeMock
+	sd   *ServiceDiscoveryMock
+}
+
+func (s *ServicesTestSuite) SetupTest() {
+	s.opts = Opts{
+		ComposePath:             "myComposePath",
+		Project:                 "myProject",
+		Flow:                    []string{"deploy"},
+		ServiceDiscoveryAddress: "myServiceDiscoveryAddress",
+		Services: []Service{
+			{Target: "db"},
+			{Target: "api", DependsOn: []string{"db"}},
+			{Name: "ui", Target: "frontend", DependsOn: []string{"api"}},
+		},
+	}
+	s.dc = getDockerComposeMock(s.opts, "")
+	compose.GetDockerCompose = func() compose.DockerComposer { return s.dc }
+	s.sd = new(ServiceDiscoveryMock)
+	s.sd.On("GetColor", mock.Anything, mock.Anything, mock.Anything).Return(BlueColor, nil)
+	s.sd.On("GetNextColor", BlueColor).Return(GreenColor)
+	s.sd.On("PutColor", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	s.sd.On("GetRun", mock.Anything, mock.Anything, mock.Anything).Return(FlowRun{}, nil)
+	s.sd.On("PutRun", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	serviceDiscovery = s.sd
+	flow = getFlowMock("")
+	logPrintln = func(v ...interface{}) {}
+	logPrintf = func(format string, v ...interface{}) {}
+}
+
+// ValidateServices
+
+func (s ServicesTestSuite) Test_ValidateServices_ReturnsNil() {
+	err := ValidateServices(s.opts.Services)
+
+	s.NoError(err)
+}
+
+func (s ServicesTestSuite) Test_ValidateServices_ReturnsError_WhenTargetIsEmpty() {
+	err := ValidateServices([]Service{{Name: "db"}})
+
+	s.Error(err)
+}
+
+func (s ServicesTestSuite) Test_ValidateServices_ReturnsError_WhenNameIsDuplicated() {
+	err := ValidateServices([]Service{{Target: "db"}, {Name: "db", Target: "other-db"}})
+
+	s.Error(err)
+}
+
+func (s ServicesTestSuite) Test_ValidateServices_ReturnsError_WhenDependencyIsUnknown() {
+	err := ValidateServices([]Service{{Target: "api", DependsOn: []string{"db"}}})
+
+	s.Error(err)
+}
+
+func (s ServicesTestSuite) Test_ValidateServices_ReturnsError_WhenDependenciesAreCircular() {
+	err := ValidateServices([]Service{
+		{Target: "db", DependsOn: []string{"ui"}},
+		{Target: "api", DependsOn: []string{"db"}},
+		{Target: "ui", DependsOn: []string{"api"}},
+	})
+
+	s.EqualError(err, "services have circular dependencies: db -> ui -> api -> db")
+}
+
+func (s ServicesTestSuite) Test_ValidateServices_ReturnsError_WhenRepositoryHasNoTags() {
+	err := ValidateServices([]Service{{Target: "api", Repository: "vfarcic/go-demo"}})
+
+	s.Error(err)
+}
+
+func (s ServicesTestSuite) Test_ValidateServices_ReturnsError_WhenTagIsNotValidPattern() {
+	err := ValidateServices([]Service{{Target: "api", Repository: "vfarcic/go-demo", Tags: []string{"1.[0"}}})
+
+	s.Error(err)
+}
+
+// Run
+
+func (s ServicesTestSuite) Test_Run_DeploysServicesInDependencyOrder() {
+	actual := []string{}
+	var mu sync.Mutex
+	mockObj := getFlowMock("Deploy")
+	mockObj.On("Deploy", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		actual = append(actual, args.Get(1).(Opts).Target)
+	})
+	flow = mockObj
+	s.opts.Services = []Service{s.opts.Services[2], s.opts.Services[1], s.opts.Services[0]}
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.NoError(err)
+	s.Equal([]string{"db", "api", "frontend"}, actual)
+}
+
+func (s ServicesTestSuite) Test_Run_DeploysIndependentServicesInParallel() {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	mockObj := getFlowMock("Deploy")
+	mockObj.On("Deploy", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		started <- args.Get(1).(Opts).Target
+		<-release
+	})
+	flow = mockObj
+	compose.GetDockerCompose = func() compose.DockerComposer { return getDockerComposeMock(s.opts, "") }
+	s.opts.Services = []Service{{Target: "db"}, {Target: "cache"}}
+	done := make(chan error)
+
+	go func() {
+		_, err := Flow{}.Run(context.Background(), s.opts)
+		done <- err
+	}()
+	count := 0
+	timeout := time.After(time.Second)
+	for count < 2 {
+		select {
+		case <-started:
+			count++
+		case <-timeout:
+			s.Fail("Independent services were not deployed in parallel")
+			count = 2
+		}
+	}
+	close(release)
+
+	s.NoError(<-done)
+}
+
+func (s ServicesTestSuite) Test_Run_InvokesDeployWithServiceOpts() {
+	blueGreen := true
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Services = []Service{{
+		Target:      "api",
+		SideTargets: []string{"db"},
+		BlueGreen:   &blueGreen,
+		Scale:       "3",
+		ServicePath: []string{"/api"},
+	}}
+
+	Flow{}.Run(context.Background(), s.opts)
+
+	mockObj.AssertCalled(s.T(), "Deploy", mock.Anything, mock.MatchedBy(func(opts Opts) bool {
+		return opts.ServiceName == "myProject-api" &&
+			opts.Target == "api" &&
+			len(opts.SideTargets) == 1 &&
+			opts.Scale == "3" &&
+			opts.ServicePath[0] == "/api" &&
+			opts.CurrentColor == BlueColor &&
+			opts.NextColor == GreenColor &&
+			opts.NextTarget == "api-green" &&
+			opts.CurrentTarget == "api-blue" &&
+			len(opts.Services) == 0
+	}), s.dc)
+}
+
+func (s ServicesTestSuite) Test_Run_SkipsDependentServices_WhenServiceFails() {
+	mockObj := getFlowMock("Deploy")
+	mockObj.On("Deploy", mock.Anything, mock.MatchedBy(func(opts Opts) bool {
+		return opts.Target == "api"
+	}), mock.Anything).Return(fmt.Errorf("This is an error"))
+	mockObj.On("Deploy", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	flow = mockObj
+
+	actual, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+	s.Equal([]StepResult{
+		{Service: "db", Name: "deploy", Type: "deploy", Status: StepStatusSuccess},
+		{Service: "api", Name: "deploy", Type: "deploy", Status: StepStatusFailure},
+		{Service: "ui", Name: "deploy", Type: "deploy", Status: StepStatusSkipped},
+	}, s.getComparableResults(actual))
+	mockObj.AssertNotCalled(s.T(), "Deploy", mock.Anything, mock.MatchedBy(func(opts Opts) bool {
+		return opts.Target == "frontend"
+	}), mock.Anything)
+}
+
+func (s ServicesTestSuite) Test_Run_ReturnsError_WhenGetColorFails() {
+	sd := new(ServiceDiscoveryMock)
+	sd.On("GetColor", mock.Anything, mock.Anything, mock.Anything).Return("", fmt.Errorf("This is an error"))
+	serviceDiscovery = sd
+
+	_, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.Error(err)
+}
+
+func (s ServicesTestSuite) Test_Run_SkipsServicesWithoutUnfinishedRun_WhenResume() {
+	mockObj := getFlowMock("")
+	flow = mockObj
+	s.opts.Resume = true
+	s.opts.Services = []Service{{Target: "db"}}
+
+	actual, err := Flow{}.Run(context.Background(), s.opts)
+
+	s.NoError(err)
+	s.Equal(StepStatusSkipped, actual[0].Status)
+	mockObj.AssertNotCalled(s.T(), "Deploy", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s ServicesTestSuite) getComparableResults(results []StepResult) []StepResult {
+	actual := []StepResult{}
+	for _, result := range results {
+		result.Duration = 0
+		result.Error = nil
+		actual = append(actual, result)
+	}
+	return actual
+}
+
+func TestServicesTestSuite(t *testing.T) {
+	suite.Run(t, new(ServicesTestSuite))
+}
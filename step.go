@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"./compose"
+	"./util"
+)
+
+const StepStatusSuccess = "success"
+const StepStatusFailure = "failure"
+const StepStatusSkipped = "skipped"
+const ProxyColorCurrent = "current"
+const ProxyColorNext = "next"
+
+type Step interface {
+	Run(ctx context.Context, state *RunState, stage Stage) error
+}
+
+type StepFunc func(ctx context.Context, state *RunState, stage Stage) error
+
+type RunState struct {
+	Opts         Opts
+	Dc           compose.DockerComposer
+	Deployed     bool
+	Failed       bool
+	CurrentColor string
+	NextColor    string
+	Results      []StepResult
+	Run          *FlowRun
+}
+
+type StepResult struct {
+	Service  string
+	Name     string
+	Type     string
+	Status   string
+	Duration time.Duration
+	Error    error
+}
+
+type DeployStep struct{}
+type ScaleStep struct{}
+type StopOldStep struct{}
+type ProxyStep struct{}
+type TestStep struct{}
+type WaitStep struct{}
+type NotifyStep struct{}
+type ShellStep struct{}
+
+var steps = map[string]Step{}
+var stepsMutex = &sync.RWMutex{}
+
+var runStepCmd = func(cmd *exec.Cmd) error { return cmd.Run() }
+
+func init() {
+	RegisterStep(FLOW_DEPLOY, DeployStep{})
+	RegisterStep(FLOW_SCALE, ScaleStep{})
+	RegisterStep(FLOW_STOP_OLD, StopOldStep{})
+	RegisterStep(FLOW_PROXY, ProxyStep{})
+	RegisterStep(FLOW_TEST, TestStep{})
+	RegisterStep(FLOW_WAIT, WaitStep{})
+	RegisterStep(FLOW_NOTIFY, NotifyStep{})
+	RegisterStep(FLOW_SHELL, ShellStep{})
+}
+
+func NewRunState(opts Opts, dc compose.DockerComposer) *RunState {
+	return &RunState{
+		Opts:         opts,
+		Dc:           dc,
+		CurrentColor: opts.CurrentColor,
+		NextColor:    opts.NextColor,
+	}
+}
+
+func RegisterStep(stepType string, step Step) {
+	stepsMutex.Lock()
+	defer stepsMutex.Unlock()
+	steps[strings.ToLower(stepType)] = step
+}
+
+func (f StepFunc) Run(ctx context.Context, state *RunState, stage Stage) error {
+	return f(ctx, state, stage)
+}
+
+func getStep(stepType string) (Step, bool) {
+	stepsMutex.RLock()
+	defer stepsMutex.RUnlock()
+	step, ok := steps[strings.ToLower(stepType)]
+	return step, ok
+}
+
+func (m DeployStep) Run(ctx context.Context, state *RunState, stage Stage) error {
+	if err := getFlow().Deploy(ctx, state.Opts, state.Dc); err != nil {
+		return err
+	}
+	state.Deployed = true
+	logContextPrintln(ctx, "Cleaning...")
+	if _, err := getServiceDiscovery().PutColor(
+		ctx,
+		state.Opts.ServiceDiscoveryAddress,
+		state.Opts.ServiceName,
+		state.NextColor,
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m ScaleStep) Run(ctx context.Context, state *RunState, stage Stage) error {
+	if state.Deployed {
+		return nil
+	}
+	opts := state.Opts
+	if scale, ok := stage.Params["scale"]; ok {
+		opts.Scale = scale
+	}
+	logContextPrintln(ctx, fmt.Sprintf("Scaling (%s)...", opts.CurrentTarget))
+	return getFlow().Scale(ctx, opts, state.Dc, opts.CurrentTarget, true)
+}
+
+func (m StopOldStep) Run(ctx context.Context, state *RunState, stage Stage) error {
+	opts := state.Opts
+	if !opts.BlueGreen {
+		return nil
+	}
+	target := opts.CurrentTarget
+	color := state.CurrentColor
+	if !state.Deployed {
+		target = opts.NextTarget
+		color = state.NextColor
+	}
+	logContextPrintln(ctx, fmt.Sprintf("Stopping old (%s)...", target))
+	if err := state.Dc.CreateFlowFile(
+		opts.FlowPath,
+		opts.ComposePath,
+		opts.ServiceName,
+		opts.Target,
+		opts.SideTargets,
+		color,
+		opts.BlueGreen,
+	); err != nil {
+		return err
+	}
+	if err := state.Dc.StopTargets(ctx, opts.FlowPath, opts.ComposePath, opts.Host, opts.CertPath, opts.Project, []string{target}); err != nil {
+		return err
+	}
+	if err := state.Dc.RemoveFlow(opts.FlowPath); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Run routes the proxy to the next color once it is deployed and to the current color otherwise.
+// Rollback stages route it to the current color even after the deployment since the flow failed.
+// The color parameter (current or next) overrides the choice.
+func (m ProxyStep) Run(ctx context.Context, state *RunState, stage Stage) error {
+	color := state.CurrentColor
+	switch stage.Params["color"] {
+	case ProxyColorCurrent:
+	case ProxyColorNext:
+		color = state.NextColor
+	case "":
+		if state.Deployed && !state.Failed {
+			color = state.NextColor
+		}
+	default:
+		return fmt.Errorf("proxy step color parameter must be %s or %s", ProxyColorCurrent, ProxyColorNext)
+	}
+	return getFlow().Proxy(ctx, state.Opts, haProxy, color)
+}
+
+func (m TestStep) Run(ctx context.Context, state *RunState, stage Stage) error {
+	target := stage.Params["target"]
+	if len(target) == 0 {
+		return fmt.Errorf("test step requires the target parameter")
+	}
+	composePath := stage.Params["compose_path"]
+	if len(composePath) == 0 {
+		composePath = state.Opts.TestComposePath
+	}
+	if len(composePath) == 0 {
+		composePath = state.Opts.ComposePath
+	}
+	logContextPrintln(ctx, fmt.Sprintf("Testing (%s)...", target))
+	args := []string{"-f", composePath}
+	if len(state.Opts.Project) > 0 {
+		args = append(args, "-p", state.Opts.Project)
+	}
+	args = append(args, "run", "--rm", target)
+	cmd := exec.CommandContext(ctx, "docker-compose", args...)
+	cmd.Env = append(util.GetDockerEnv(state.Opts.Host, state.Opts.CertPath), getStepEnv(state)...)
+	cmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
+	if err := runStepCmd(cmd); err != nil {
+		return fmt.Errorf("Docker Compose command: %s\n%s", strings.Join(cmd.Args, " "), err.Error())
+	}
+	return nil
+}
+
+func (m WaitStep) Run(ctx context.Context, state *RunState, stage Stage) error {
+	seconds, err := strconv.Atoi(stage.Params["seconds"])
+	if err != nil {
+		return fmt.Errorf("wait step requires the seconds parameter to be a number")
+	}
+	logContextPrintln(ctx, fmt.Sprintf("Waiting %d seconds...", seconds))
+	if err := util.Sleep(ctx, time.Duration(seconds)*time.Second); err != nil {
+		return fmt.Errorf("The wait was interrupted\n%s", err.Error())
+	}
+	return nil
+}
+
+func (m NotifyStep) Run(ctx context.Context, state *RunState, stage Stage) error {
+	url := stage.Params["url"]
+	if len(url) == 0 {
+		return fmt.Errorf("notify step requires the url parameter")
+	}
+	status := StepStatusSuccess
+	if state.Failed {
+		status = StepStatusFailure
+	}
+	body, err := json.Marshal(map[string]string{
+		"service": state.Opts.ServiceName,
+		"color":   state.NextColor,
+		"status":  status,
+		"message": stage.Params["message"],
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpDo(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Could not send the notification to %s\n%s", url, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Notification to %s returned status code %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (m ShellStep) Run(ctx context.Context, state *RunState, stage Stage) error {
+	command := stage.Params["command"]
+	if len(command) == 0 {
+		return fmt.Errorf("shell step requires the command parameter")
+	}
+	logContextPrintln(ctx, fmt.Sprintf("Running %s...", command))
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(util.GetDockerEnv(state.Opts.Host, state.Opts.CertPath), getStepEnv(state)...)
+	cmd.Stdout = util.OutputWriter(ctx, util.Stdout)
+	cmd.Stderr = util.OutputWriter(ctx, util.Stderr)
+	if err := runStepCmd(cmd); err != nil {
+		return fmt.Errorf("Shell command: %s\n%s", command, err.Error())
+	}
+	return nil
+}
+
+func getStepEnv(state *RunState) []string {
+	return []string{
+		fmt.Sprintf("FLOW_SERVICE_NAME=%s", state.Opts.ServiceName),
+		fmt.Sprintf("FLOW_CURRENT_COLOR=%s", state.CurrentColor),
+		fmt.Sprintf("FLOW_NEXT_COLOR=%s", state.NextColor),
+		fmt.Sprintf("FLOW_CURRENT_TARGET=%s", state.Opts.CurrentTarget),
+		fmt.Sprintf("FLOW_NEXT_TARGET=%s", state.Opts.NextTarget),
+		fmt.Sprintf("FLOW_DEPLOYED=%t", state.Deployed),
+	}
+}
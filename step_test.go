@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+	"./compose"
+	"./util"
+)
+
+type StepTestSuite struct {
+	suite.Suite
+	opts Opts
+	dc   compose.DockerComposer
+}
+
+func (s *StepTestSuite) SetupTest() {
+	s.opts = Opts{
+		ComposePath:   "myComposePath",
+		Target:        "myTarget",
+		NextColor:     "orange",
+		CurrentColor:  "pink",
+		NextTarget:    "myNextTarget",
+		CurrentTarget: "myCurrentTarget",
+		Project:       "myProject",
+		ServiceName:   "myServiceName",
+		BlueGreen:     true,
+	}
+	s.dc = getDockerComposeMock(s.opts, "")
+	flow = getFlowMock("")
+	serviceDiscovery = getServiceDiscoveryMock(s.opts, "")
+	logPrintln = func(v ...interface{}) {}
+}
+
+// RegisterStep
+
+func (s StepTestSuite) Test_RegisterStep_AddsStepToRegistry() {
+	step := StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+		return nil
+	})
+	defer func() { delete(steps, "my-step") }()
+
+	RegisterStep("My-Step", step)
+
+	_, ok := getStep("my-step")
+	s.True(ok)
+}
+
+
+func (s StepTestSuite) Test_DeployStep_InvokesFlowDeployAndPutsColor() {
+	flowMock := getFlowMock("")
+	flow = flowMock
+	scMock := getServiceDiscoveryMock(s.opts, "")
+	serviceDiscovery = scMock
+
+	state := NewRunState(s.opts, s.dc)
+
+	err := DeployStep{}.Run(context.Background(), state, Stage{})
+
+	s.NoError(err)
+	s.True(state.Deployed)
+	flowMock.AssertCalled(s.T(), "Deploy", mock.Anything, s.opts, s.dc)
+	scMock.AssertCalled(s.T(), "PutColor", mock.Anything, s.opts.ServiceDiscoveryAddress, s.opts.ServiceName, s.opts.NextColor)
+}
+
+func (s StepTestSuite) Test_ScaleStep_InvokesFlowScaleWithScaleParam() {
+	flowMock := getFlowMock("")
+	flow = flowMock
+	expected := s.opts
+	expected.Scale = "+2"
+
+	ScaleStep{}.Run(context.Background(), NewRunState(s.opts, s.dc), Stage{Params: map[string]string{"scale": "+2"}})
+
+	flowMock.AssertCalled(s.T(), "Scale", mock.Anything, expected, s.dc, s.opts.CurrentTarget, true)
+}
+
+func (s StepTestSuite) Test_TestStep_RunsDockerComposeTarget() {
+	var actual []string
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = cmd.Args
+		return nil
+	}
+	s.opts.TestComposePath = "docker-compose-test.yml"
+	expected := []string{"docker-compose", "-f", "docker-compose-test.yml", "-p", "myProject", "run", "--rm", "tests"}
+
+	err := TestStep{}.Run(context.Background(), NewRunState(s.opts, nil), Stage{Params: map[string]string{"target": "tests"}})
+
+	s.NoError(err)
+	s.Equal(expected, actual)
+}
+
+func (s StepTestSuite) Test_TestStep_SetsDockerHostOfCommand() {
+	var actual []string
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = cmd.Env
+		return nil
+	}
+	s.opts.Host = "tcp://my-docker-host:2376"
+	s.opts.CertPath = "/path/to/certs"
+
+	TestStep{}.Run(context.Background(), NewRunState(s.opts, nil), Stage{Params: map[string]string{"target": "tests"}})
+
+	s.Contains(actual, "DOCKER_HOST=tcp://my-docker-host:2376")
+	s.Contains(actual, "DOCKER_CERT_PATH=/path/to/certs")
+	s.NotEqual(s.opts.Host, os.Getenv("DOCKER_HOST"))
+}
+
+func (s StepTestSuite) Test_TestStep_ReturnsError_WhenTargetIsEmpty() {
+	err := TestStep{}.Run(context.Background(), NewRunState(s.opts, nil), Stage{})
+
+	s.Error(err)
+}
+
+func (s StepTestSuite) Test_WaitStep_Sleeps() {
+	var actual time.Duration
+	sleepOrig := util.Sleep
+	defer func() { util.Sleep = sleepOrig }()
+	util.Sleep = func(ctx context.Context, d time.Duration) error {
+		actual = d
+		return nil
+	}
+
+	err := WaitStep{}.Run(context.Background(), NewRunState(Opts{}, nil), Stage{Params: map[string]string{"seconds": "3"}})
+
+	s.NoError(err)
+	s.Equal(3*time.Second, actual)
+}
+
+func (s StepTestSuite) Test_WaitStep_ReturnsError_WhenSleepIsInterrupted() {
+	sleepOrig := util.Sleep
+	defer func() { util.Sleep = sleepOrig }()
+	util.Sleep = func(ctx context.Context, d time.Duration) error {
+		return context.Canceled
+	}
+
+	err := WaitStep{}.Run(context.Background(), NewRunState(Opts{}, nil), Stage{Params: map[string]string{"seconds": "60"}})
+
+	s.Error(err)
+}
+
+func (s StepTestSuite) Test_WaitStep_ReturnsError_WhenSecondsIsNotNumber() {
+	err := WaitStep{}.Run(context.Background(), NewRunState(Opts{}, nil), Stage{Params: map[string]string{"seconds": "abc"}})
+
+	s.Error(err)
+}
+
+func (s StepTestSuite) Test_NotifyStep_SendsStatus() {
+	actual := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &actual)
+	}))
+	defer server.Close()
+	stage := Stage{Params: map[string]string{"url": server.URL, "message": "Deployment finished"}}
+	state := NewRunState(s.opts, nil)
+	state.Failed = true
+
+	err := NotifyStep{}.Run(context.Background(), state, stage)
+
+	s.NoError(err)
+	s.Equal("failure", actual["status"])
+	s.Equal(s.opts.ServiceName, actual["service"])
+	s.Equal("Deployment finished", actual["message"])
+}
+
+func (s StepTestSuite) Test_NotifyStep_ReturnsError_WhenStatusCodeIsNotSuccessful() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := NotifyStep{}.Run(context.Background(), NewRunState(s.opts, nil), Stage{Params: map[string]string{"url": server.URL}})
+
+	s.Error(err)
+}
+
+func (s StepTestSuite) Test_ShellStep_RunsCommand() {
+	var actual *exec.Cmd
+	runStepCmd = func(cmd *exec.Cmd) error {
+		actual = cmd
+		return nil
+	}
+
+	err := ShellStep{}.Run(context.Background(), NewRunState(s.opts, nil), Stage{Params: map[string]string{"command": "echo hello"}})
+
+	s.NoError(err)
+	s.Equal([]string{"sh", "-c", "echo hello"}, actual.Args)
+	s.Contains(actual.Env, "FLOW_NEXT_COLOR=orange")
+}
+
+func (s StepTestSuite) Test_ShellStep_ReturnsError_WhenCommandFails() {
+	runStepCmd = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("This is an error")
+	}
+
+	err := ShellStep{}.Run(context.Background(), NewRunState(s.opts, nil), Stage{Params: map[string]string{"command": "exit 1"}})
+
+	s.Error(err)
+}
+
+// Suite
+
+func TestStepTestSuite(t *testing.T) {
+	dockerHost := os.Getenv("DOCKER_HOST")
+	dockerCertPath := os.Getenv("DOCKER_CERT_PATH")
+	runStepCmdOrig := runStepCmd
+	defer func() {
+		os.Setenv("DOCKER_HOST", dockerHost)
+		os.Setenv("DOCKER_CERT_PATH", dockerCertPath)
+		runStepCmd = runStepCmdOrig
+	}()
+	suite.Run(t, new(StepTestSuite))
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+type RetryPolicy struct {
+	Attempts int
+	Delay    time.Duration
+	MaxDelay time.Duration
+	RetryOn  []string
+}
+
+type permanentError struct {
+	err error
+}
+
+type retryPolicyKey struct{}
+
+var RetryPrintf = func(ctx context.Context, format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent marks an error that should not be retried regardless of the policy.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+func GetRetryPolicy(ctx context.Context) RetryPolicy {
+	if policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return policy
+	}
+	return RetryPolicy{Attempts: 1}
+}
+
+func (p RetryPolicy) IsRetryable(err error) bool {
+	if _, ok := err.(permanentError); ok {
+		return false
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range p.RetryOn {
+		if strings.Contains(msg, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry invokes f until it succeeds or the retry policy stored in the context gives up.
+// The delay between attempts doubles with each retry up to the maximum delay.
+func Retry(ctx context.Context, name string, f func() error) error {
+	policy := GetRetryPolicy(ctx)
+	delay := policy.Delay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.Attempts || ctx.Err() != nil || !policy.IsRetryable(err) {
+			if p, ok := err.(permanentError); ok {
+				return p.err
+			}
+			return err
+		}
+		RetryPrintf(ctx, "%s failed (attempt %d of %d), retrying in %s\n%s", name, attempt, policy.Attempts, delay, err.Error())
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
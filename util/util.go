@@ -1,31 +1,58 @@
 package util
 
 import (
+	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 )
 
 var ReadFile = ioutil.ReadFile
 var WriteFile = ioutil.WriteFile
 var RemoveFile = os.Remove
-var ExecCmd = exec.Command
-var SetDockerHost = func(host, certPath string) {
-	if len(host) > 0 {
-		os.Setenv("DOCKER_HOST", host)
-	} else {
-		os.Unsetenv("DOCKER_HOST")
-	}
-	if len(certPath) > 0 {
-		os.Setenv("DOCKER_CERT_PATH", certPath)
-	} else {
-		os.Unsetenv("DOCKER_CERT_PATH")
-	}
+var RenameFile = os.Rename
+var MkdirAll = os.MkdirAll
+var Stdout io.Writer = os.Stdout
+var Stderr io.Writer = os.Stderr
+
+// OutputWriter returns the writer for the output of a command run with the context.
+// It is replaced when the output needs to tell apart the flows that run the commands.
+var OutputWriter = func(ctx context.Context, w io.Writer) io.Writer {
+	return w
 }
+var TempDir = ioutil.TempDir
+var ExecCmd = exec.CommandContext
 var RunCmd = func(cmd *exec.Cmd) error {
 	return cmd.Run()
 }
-var Sleep = func(d time.Duration) {
-	time.Sleep(d)
+var Sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// GetDockerEnv returns the environment of the process with the Docker host and cert path set for a single command.
+// The process environment is not changed since flows of different services can run Docker commands at the same time.
+func GetDockerEnv(host, certPath string) []string {
+	env := []string{}
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "DOCKER_HOST=") && !strings.HasPrefix(variable, "DOCKER_CERT_PATH=") {
+			env = append(env, variable)
+		}
+	}
+	if len(host) > 0 {
+		env = append(env, "DOCKER_HOST="+host)
+	}
+	if len(certPath) > 0 {
+		env = append(env, "DOCKER_CERT_PATH="+certPath)
+	}
+	return env
 }
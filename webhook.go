@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+	"./util"
+)
+
+const WebhookEventStart = "start"
+const WebhookEventStep = "step"
+const WebhookEventSuccess = "success"
+const WebhookEventFailure = "failure"
+const WebhookEventRollback = "rollback"
+const WebhookDefaultTimeout = 10
+
+var webhookEvents = []string{WebhookEventStart, WebhookEventStep, WebhookEventSuccess, WebhookEventFailure, WebhookEventRollback}
+
+type Webhook struct {
+	Name    string            `yaml:"name"`
+	Url     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	Events  []string          `yaml:"events"`
+	Timeout int               `yaml:"timeout"`
+	Retries int               `yaml:"retries"`
+}
+
+// WebhookEvent is sent as the JSON body of webhooks without a body template and is the data of those with it.
+type WebhookEvent struct {
+	Event         string  `json:"event"`
+	RunId         string  `json:"run_id"`
+	Service       string  `json:"service"`
+	Step          string  `json:"step,omitempty"`
+	Status        string  `json:"status,omitempty"`
+	CurrentColor  string  `json:"current_color"`
+	NextColor     string  `json:"next_color"`
+	CurrentTarget string  `json:"current_target"`
+	NextTarget    string  `json:"next_target"`
+	Scale         int     `json:"scale,omitempty"`
+	Duration      float64 `json:"duration,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func (w Webhook) getName() string {
+	if len(w.Name) > 0 {
+		return w.Name
+	}
+	return w.Url
+}
+
+func (w Webhook) isSubscribed(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (w Webhook) getBody(event WebhookEvent) ([]byte, error) {
+	if len(w.Body) == 0 {
+		return json.Marshal(event)
+	}
+	tmpl, err := template.New(w.getName()).Funcs(webhookFuncs).Parse(w.Body)
+	if err != nil {
+		return nil, err
+	}
+	body := new(bytes.Buffer)
+	if err := tmpl.Execute(body, event); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// Send requests the webhook and retries failed requests up to the number of retries of the webhook.
+// Each request is aborted when it takes longer than the timeout of the webhook.
+func (w Webhook) Send(policy util.RetryPolicy, event WebhookEvent) error {
+	body, err := w.getBody(event)
+	if err != nil {
+		return fmt.Errorf("Could not create the body of the webhook\n%s", err.Error())
+	}
+	method := strings.ToUpper(w.Method)
+	if len(method) == 0 {
+		method = "POST"
+	}
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = WebhookDefaultTimeout
+	}
+	policy.Attempts = w.Retries + 1
+	policy.RetryOn = nil
+	// Webhooks are sent even when the flow is cancelled so that the failure is reported
+	ctx := util.WithRetryPolicy(context.Background(), policy)
+	return util.Retry(ctx, fmt.Sprintf("The %s webhook (%s)", event.Event, w.getName()), func() error {
+		reqCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+		req, err := http.NewRequest(method, w.Url, bytes.NewReader(body))
+		if err != nil {
+			return util.Permanent(err)
+		}
+		if len(w.Body) == 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for key, value := range w.Headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := httpDo(req.WithContext(reqCtx))
+		if err != nil {
+			return fmt.Errorf("The request to %s failed\n%s", w.Url, err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err := fmt.Errorf("The request to %s failed with status code %d", w.Url, resp.StatusCode)
+			if resp.StatusCode < 500 {
+				return util.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// SendWebhooks adds the details of the flow to the event and sends it to the webhooks subscribed to it.
+// Webhooks that fail are logged without failing the flow.
+func SendWebhooks(ctx context.Context, state *RunState, event WebhookEvent) {
+	if len(state.Opts.Webhooks) == 0 {
+		return
+	}
+	event.Service = state.Opts.ServiceName
+	event.CurrentColor = state.CurrentColor
+	event.NextColor = state.NextColor
+	event.CurrentTarget = state.Opts.CurrentTarget
+	event.NextTarget = state.Opts.NextTarget
+	event.Scale = getReportService(ctx).getScale()
+	if state.Run != nil {
+		event.RunId = state.Run.Id
+	}
+	for _, webhook := range state.Opts.Webhooks {
+		if !webhook.isSubscribed(event.Event) {
+			continue
+		}
+		if err := webhook.Send(getRetryPolicy(state.Opts), event); err != nil {
+			logContextPrintf(ctx, "The %s webhook (%s) failed\n%s", event.Event, webhook.getName(), err.Error())
+		}
+	}
+}
+
+func ValidateWebhooks(webhooks []Webhook) error {
+	for _, webhook := range webhooks {
+		if len(webhook.Url) == 0 {
+			return fmt.Errorf("each webhook must specify the url")
+		}
+		for _, event := range webhook.Events {
+			valid := false
+			for _, e := range webhookEvents {
+				valid = valid || e == event
+			}
+			if !valid {
+				return fmt.Errorf("webhook %s event %s must be one of %s", webhook.getName(), event, strings.Join(webhookEvents, ", "))
+			}
+		}
+		if webhook.Timeout < 0 || webhook.Retries < 0 {
+			return fmt.Errorf("webhook %s timeout and retries must not be negative", webhook.getName())
+		}
+		if _, err := template.New(webhook.getName()).Funcs(webhookFuncs).Parse(webhook.Body); err != nil {
+			return fmt.Errorf("webhook %s body is not a valid template\n%s", webhook.getName(), err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"./util"
+)
+
+type WebhookTestSuite struct {
+	suite.Suite
+	opts     Opts
+	server   *httptest.Server
+	received *webhookRequests
+	steps    map[string]Step
+	httpDo   func(req *http.Request) (*http.Response, error)
+}
+
+type webhookRequests struct {
+	requests []*http.Request
+	bodies   []string
+	status   []int
+	mu       sync.Mutex
+}
+
+func (s *WebhookTestSuite) SetupTest() {
+	received := &webhookRequests{}
+	s.received = received
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.mu.Lock()
+		defer received.mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		received.requests = append(received.requests, r)
+		received.bodies = append(received.bodies, string(body))
+		status := http.StatusOK
+		if len(received.status) > 0 {
+			status, received.status = received.status[0], received.status[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	s.opts = Opts{
+		ServiceName:   "go-demo-app",
+		CurrentTarget: "app-blue",
+		NextTarget:    "app-green",
+		RetryDelay:    1,
+		RetryMaxDelay: 1,
+		Webhooks:      []Webhook{{Url: s.server.URL}},
+	}
+	s.steps = steps
+	s.httpDo = httpDo
+	httpDo = http.DefaultClient.Do
+	steps = map[string]Step{
+		"success": StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+			return nil
+		}),
+		"failure": StepFunc(func(ctx context.Context, state *RunState, stage Stage) error {
+			return fmt.Errorf("This is an error")
+		}),
+	}
+	logPrintln = func(v ...interface{}) {}
+	logPrintf = func(format string, v ...interface{}) {}
+	util.RetryPrintf = func(ctx context.Context, format string, v ...interface{}) {}
+}
+
+func (s *WebhookTestSuite) TearDownTest() {
+	s.server.Close()
+	steps = s.steps
+	httpDo = s.httpDo
+}
+
+// Send
+
+func (s WebhookTestSuite) Test_Send_PostsEventAsJson() {
+	event := WebhookEvent{Event: WebhookEventSuccess, Service: "go-demo-app", NextColor: GreenColor, Scale: 3}
+
+	err := Webhook{Url: s.server.URL}.Send(util.RetryPolicy{}, event)
+
+	s.NoError(err)
+	s.Equal("POST", s.received.requests[0].Method)
+	s.Equal("application/json", s.received.requests[0].Header.Get("Content-Type"))
+	actual := WebhookEvent{}
+	json.Unmarshal([]byte(s.received.bodies[0]), &actual)
+	s.Equal(event, actual)
+}
+
+func (s WebhookTestSuite) Test_Send_UsesMethodHeadersAndBodyTemplate() {
+	webhook := Webhook{
+		Url:     s.server.URL,
+		Method:  "put",
+		Headers: map[string]string{"Authorization": "Bearer myToken"},
+		Body:    `{"text": {{json (printf "%s was deployed (%s, %d instances)" .Service .NextColor .Scale)}}}`,
+	}
+
+	err := webhook.Send(util.RetryPolicy{}, WebhookEvent{Service: "go-demo-app", NextColor: GreenColor, Scale: 3})
+
+	s.NoError(err)
+	s.Equal("PUT", s.received.requests[0].Method)
+	s.Equal("Bearer myToken", s.received.requests[0].Header.Get("Authorization"))
+	s.Equal(`{"text": "go-demo-app was deployed (green, 3 instances)"}`, s.received.bodies[0])
+}
+
+func (s WebhookTestSuite) Test_Send_RetriesServerErrors() {
+	s.received.status = []int{http.StatusBadGateway, http.StatusServiceUnavailable}
+
+	err := Webhook{Url: s.server.URL, Retries: 2}.Send(util.RetryPolicy{Delay: time.Millisecond}, WebhookEvent{})
+
+	s.NoError(err)
+	s.Len(s.received.requests, 3)
+}
+
+func (s WebhookTestSuite) Test_Send_ReturnsError_WhenRetriesAreExhausted() {
+	s.received.status = []int{http.StatusBadGateway, http.StatusBadGateway}
+
+	err := Webhook{Url: s.server.URL, Retries: 1}.Send(util.RetryPolicy{Delay: time.Millisecond}, WebhookEvent{})
+
+	s.Error(err)
+	s.Len(s.received.requests, 2)
+}
+
+func (s WebhookTestSuite) Test_Send_DoesNotRetryClientErrors() {
+	s.received.status = []int{http.StatusUnauthorized}
+
+	err := Webhook{Url: s.server.URL, Retries: 2}.Send(util.RetryPolicy{Delay: time.Millisecond}, WebhookEvent{})
+
+	s.Error(err)
+	s.Len(s.received.requests, 1)
+}
+
+func (s WebhookTestSuite) Test_Send_ReturnsError_WhenTimeoutIsReached() {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	err := Webhook{Url: server.URL, Timeout: 1}.Send(util.RetryPolicy{}, WebhookEvent{})
+
+	s.Error(err)
+}
+
+// Pipeline
+
+func (s WebhookTestSuite) Test_Run_SendsStartStepAndSuccessEvents() {
+	state := NewRunState(s.opts, nil)
+	state.NextColor = GreenColor
+	state.Run = &FlowRun{Id: "myRunId"}
+
+	pipeline.Run(context.Background(), state, []Stage{{Name: "first", Type: "success"}})
+
+	events := s.getEvents()
+	s.Equal([]string{WebhookEventStart, WebhookEventStep, WebhookEventSuccess}, s.getEventTypes(events))
+	s.Equal("first", events[1].Step)
+	s.Equal(StepStatusSuccess, events[1].Status)
+	s.Equal("myRunId", events[2].RunId)
+	s.Equal("go-demo-app", events[2].Service)
+	s.Equal(GreenColor, events[2].NextColor)
+	s.Equal("app-green", events[2].NextTarget)
+}
+
+func (s WebhookTestSuite) Test_Run_SendsRollbackAndFailureEvents() {
+	stages := []Stage{
+		{Name: "deploy", Type: "failure"},
+		{Name: "restore", Type: "success", When: StageWhenOnFailure},
+	}
+
+	pipeline.Run(context.Background(), NewRunState(s.opts, nil), stages)
+
+	events := s.getEvents()
+	s.Equal(
+		[]string{WebhookEventStart, WebhookEventStep, WebhookEventStep, WebhookEventRollback, WebhookEventFailure},
+		s.getEventTypes(events),
+	)
+	s.Equal(StepStatusSuccess, events[3].Status)
+	s.Equal(StepStatusFailure, events[4].Status)
+	s.Contains(events[4].Error, "This is an error")
+}
+
+func (s WebhookTestSuite) Test_Run_DoesNotSendRollbackEvent_WhenThereIsNothingToRollBack() {
+	pipeline.Run(context.Background(), NewRunState(s.opts, nil), []Stage{{Name: "deploy", Type: "failure"}})
+
+	s.Equal([]string{WebhookEventStart, WebhookEventStep, WebhookEventFailure}, s.getEventTypes(s.getEvents()))
+}
+
+func (s WebhookTestSuite) Test_Run_SendsOnlySubscribedEvents() {
+	s.opts.Webhooks[0].Events = []string{WebhookEventSuccess, WebhookEventFailure}
+
+	pipeline.Run(context.Background(), NewRunState(s.opts, nil), []Stage{{Name: "first", Type: "success"}})
+
+	s.Equal([]string{WebhookEventSuccess}, s.getEventTypes(s.getEvents()))
+}
+
+func (s WebhookTestSuite) Test_Run_DoesNotFail_WhenWebhookFails() {
+	s.received.status = []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}
+
+	err := pipeline.Run(context.Background(), NewRunState(s.opts, nil), []Stage{{Name: "first", Type: "success"}})
+
+	s.NoError(err)
+}
+
+func (s WebhookTestSuite) Test_Run_SendsScaleOfTheFlow() {
+	state := NewRunState(s.opts, nil)
+	ctx := getReport(context.Background()).addService(context.Background(), s.opts, "myRunId")
+	getReportService(ctx).setScale(4)
+
+	pipeline.Run(ctx, state, []Stage{{Name: "first", Type: "success"}})
+
+	s.Equal(4, s.getEvents()[0].Scale)
+}
+
+// ValidateWebhooks
+
+func (s WebhookTestSuite) Test_ValidateWebhooks_ReturnsNil() {
+	err := ValidateWebhooks([]Webhook{{Url: "http://chat", Events: []string{WebhookEventFailure}, Body: "{{.Service}}"}})
+
+	s.NoError(err)
+}
+
+func (s WebhookTestSuite) Test_ValidateWebhooks_ReturnsError_WhenUrlIsEmpty() {
+	err := ValidateWebhooks([]Webhook{{}})
+
+	s.Error(err)
+}
+
+func (s WebhookTestSuite) Test_ValidateWebhooks_ReturnsError_WhenEventIsUnknown() {
+	err := ValidateWebhooks([]Webhook{{Url: "http://chat", Events: []string{"deployed"}}})
+
+	s.Error(err)
+}
+
+func (s WebhookTestSuite) Test_ValidateWebhooks_ReturnsError_WhenBodyIsNotValidTemplate() {
+	err := ValidateWebhooks([]Webhook{{Url: "http://chat", Body: "{{.Service"}})
+
+	s.Error(err)
+}
+
+func (s WebhookTestSuite) Test_ValidateWebhooks_ReturnsError_WhenRetriesAreNegative() {
+	err := ValidateWebhooks([]Webhook{{Url: "http://chat", Retries: -1}})
+
+	s.Error(err)
+}
+
+func (s WebhookTestSuite) getEvents() []WebhookEvent {
+	s.received.mu.Lock()
+	defer s.received.mu.Unlock()
+	events := []WebhookEvent{}
+	for _, body := range s.received.bodies {
+		event := WebhookEvent{}
+		s.NoError(json.Unmarshal([]byte(body), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func (s WebhookTestSuite) getEventTypes(events []WebhookEvent) []string {
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.Event)
+	}
+	return types
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	retryPrintfOrig := util.RetryPrintf
+	defer func() { util.RetryPrintf = retryPrintfOrig }()
+	suite.Run(t, new(WebhookTestSuite))
+}